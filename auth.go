@@ -0,0 +1,263 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const sigV4Algorithm = "AWS4-HMAC-SHA256"
+
+// adminTokenCookie holds the admin token for browsers, which can't send it as
+// a header when loading the admin UI
+const adminTokenCookie = "ess_admin_token"
+
+// sigV4Authorization holds the parsed components of a SigV4 Authorization header
+type sigV4Authorization struct {
+	AccessKey     string
+	Date          string // YYYYMMDD
+	Region        string
+	Service       string
+	SignedHeaders []string
+	Signature     string
+}
+
+// scope returns the credential scope the signature was computed for
+func (a *sigV4Authorization) scope() string {
+	return a.Date + "/" + a.Region + "/" + a.Service + "/aws4_request"
+}
+
+// requireAdminToken is middleware that rejects admin requests without the
+// token set in admin.auth_token, given as a bearer token or in the admin
+// cookie. A GET with the token in a token query parameter sets the cookie and
+// redirects to the same URL without it, so a browser can open the admin UI.
+// It does nothing unless a token is configured.
+func requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := activeConfig.Admin.AuthToken
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		valid := func(given string) bool {
+			return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+		}
+
+		if given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && valid(given) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if cookie, err := r.Cookie(adminTokenCookie); err == nil && valid(cookie.Value) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		query := r.URL.Query()
+		if r.Method == http.MethodGet && query.Has("token") && valid(query.Get("token")) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     adminTokenCookie,
+				Value:    token,
+				Path:     "/admin",
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+			// Keep the token out of the address bar and the browser history
+			query.Del("token")
+			target := *r.URL
+			target.RawQuery = query.Encode()
+			http.Redirect(w, r, target.RequestURI(), http.StatusSeeOther)
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// verifySignature is middleware that rejects SQS requests whose AWS Signature
+// Version 4 doesn't match the configured credentials. It does nothing unless
+// auth.verify_signature is enabled.
+func verifySignature(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := activeConfig.Auth
+		if !auth.VerifySignature {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			sendError(w, r, "MissingAuthenticationToken", "Request is missing Authentication Token", http.StatusForbidden)
+			return
+		}
+
+		parsed, err := parseSigV4Authorization(header)
+		if err != nil {
+			sendError(w, r, "IncompleteSignature", err.Error(), http.StatusForbidden)
+			return
+		}
+		if parsed.AccessKey != auth.AccessKey {
+			sendError(w, r, "InvalidClientTokenId", "The security token included in the request is invalid", http.StatusForbidden)
+			return
+		}
+
+		amzDate := r.Header.Get("X-Amz-Date")
+		if amzDate == "" {
+			sendError(w, r, "IncompleteSignature", "Request is missing the X-Amz-Date header", http.StatusForbidden)
+			return
+		}
+
+		// Read the body for the payload hash and put it back for the handlers
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			sendError(w, r, "InvalidRequest", "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		expected := computeSignature(r, body, parsed, amzDate, auth.SecretKey)
+		if !hmac.Equal([]byte(expected), []byte(parsed.Signature)) {
+			sendError(w, r, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parseSigV4Authorization parses a header of the form
+// "AWS4-HMAC-SHA256 Credential=AKID/20240101/us-east-1/sqs/aws4_request, SignedHeaders=host;x-amz-date, Signature=..."
+func parseSigV4Authorization(header string) (*sigV4Authorization, error) {
+	algorithm, rest, found := strings.Cut(header, " ")
+	if !found || algorithm != sigV4Algorithm {
+		return nil, fmt.Errorf("unsupported authorization algorithm, expected %s", sigV4Algorithm)
+	}
+
+	auth := &sigV4Authorization{}
+	var credential, signedHeaders string
+	for _, part := range strings.Split(rest, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "Credential":
+			credential = value
+		case "SignedHeaders":
+			signedHeaders = value
+		case "Signature":
+			auth.Signature = value
+		}
+	}
+	if credential == "" || signedHeaders == "" || auth.Signature == "" {
+		return nil, fmt.Errorf("authorization header requires Credential, SignedHeaders and Signature")
+	}
+
+	scope := strings.Split(credential, "/")
+	if len(scope) != 5 || scope[4] != "aws4_request" {
+		return nil, fmt.Errorf("malformed credential scope %q", credential)
+	}
+	auth.AccessKey, auth.Date, auth.Region, auth.Service = scope[0], scope[1], scope[2], scope[3]
+	auth.SignedHeaders = strings.Split(signedHeaders, ";")
+
+	return auth, nil
+}
+
+// computeSignature returns the hex-encoded SigV4 signature for the request
+func computeSignature(r *http.Request, body []byte, auth *sigV4Authorization, amzDate, secretKey string) string {
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		auth.scope(),
+		hashHex([]byte(canonicalRequest(r, body, auth.SignedHeaders))),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), auth.Date)
+	key = hmacSHA256(key, auth.Region)
+	key = hmacSHA256(key, auth.Service)
+	key = hmacSHA256(key, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// canonicalRequest builds the SigV4 canonical request string
+func canonicalRequest(r *http.Request, body []byte, signedHeaders []string) string {
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	var headers strings.Builder
+	for _, name := range signedHeaders {
+		var value string
+		if name == "host" {
+			value = r.Host // Go moves the Host header out of r.Header
+		} else {
+			value = strings.Join(r.Header.Values(name), ",")
+		}
+		headers.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = hashHex(body)
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		sigV4Escape(path, false), // non-S3 services encode the path a second time
+		canonicalQueryString(r.URL.Query()),
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+// canonicalQueryString sorts and encodes the query parameters
+func canonicalQueryString(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, sigV4Escape(key, true)+"="+sigV4Escape(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// sigV4Escape percent-encodes everything except the RFC 3986 unreserved
+// characters, and optionally '/'
+func sigV4Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,329 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxBatchEntries is the most entries a batch request may carry
+const maxBatchEntries = 10
+
+// batchEntryIDPattern matches a valid batch entry Id: 1 to 80 alphanumeric
+// characters, hyphens and underscores
+var batchEntryIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,80}$`)
+
+// batchEntry is one entry of a batch request. Query requests carry the
+// entry's parameters with the "<Action>RequestEntry.N." prefix stripped;
+// JSON requests carry the decoded entry object.
+type batchEntry struct {
+	form url.Values
+	json map[string]interface{}
+}
+
+// String returns a string parameter of the entry, or "" if it is missing
+func (e batchEntry) String(name string) string {
+	if e.json != nil {
+		s, _ := e.json[name].(string)
+		return s
+	}
+	return e.form.Get(name)
+}
+
+// Int returns an integer parameter of the entry and whether it was given
+func (e batchEntry) Int(name string) (int, bool) {
+	if e.json != nil {
+		n, ok := e.json[name].(float64)
+		return int(n), ok
+	}
+	if e.form.Get(name) == "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(e.form.Get(name))
+	return n, err == nil
+}
+
+// MessageAttributes returns the entry's message attributes
+func (e batchEntry) MessageAttributes() map[string]interface{} {
+	if e.json != nil {
+		if attrs, ok := e.json["MessageAttributes"].(map[string]interface{}); ok {
+			return attrs
+		}
+		return make(map[string]interface{})
+	}
+	return parseMessageAttributes(e.form)
+}
+
+// MessageSystemAttributes returns the entry's message system attributes
+func (e batchEntry) MessageSystemAttributes() map[string]string {
+	if e.json == nil {
+		return parseMessageSystemAttributes(e.form)
+	}
+	attrs := make(map[string]string)
+	if raw, ok := e.json["MessageSystemAttributes"].(map[string]interface{}); ok {
+		for name, v := range raw {
+			if value, ok := v.(map[string]interface{}); ok {
+				attrs[name], _ = value["StringValue"].(string)
+			}
+		}
+	}
+	return attrs
+}
+
+// parseBatchRequest returns the queue URL and entries of a batch request.
+// Query entries are numbered from 1 under entryPrefix and returned in order.
+func parseBatchRequest(r *http.Request, entryPrefix string) (string, []batchEntry, error) {
+	if r.Header.Get("X-Amz-Target") != "" {
+		jsonBody, err := parseRequestJSON(r)
+		if err != nil {
+			return "", nil, err
+		}
+		queueURL, _ := jsonBody["QueueUrl"].(string)
+		list, _ := jsonBody["Entries"].([]interface{})
+		entries := make([]batchEntry, 0, len(list))
+		for _, v := range list {
+			entry, ok := v.(map[string]interface{})
+			if !ok {
+				entry = map[string]interface{}{}
+			}
+			entries = append(entries, batchEntry{json: entry})
+		}
+		return queueURL, entries, nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return "", nil, err
+	}
+	byIndex := make(map[int]url.Values)
+	for key, values := range r.Form {
+		rest, ok := strings.CutPrefix(key, entryPrefix+".")
+		if !ok {
+			continue
+		}
+		index, field, ok := strings.Cut(rest, ".")
+		n, err := strconv.Atoi(index)
+		if !ok || err != nil || n < 1 {
+			continue
+		}
+		if byIndex[n] == nil {
+			byIndex[n] = make(url.Values)
+		}
+		byIndex[n][field] = values
+	}
+
+	indexes := make([]int, 0, len(byIndex))
+	for n := range byIndex {
+		indexes = append(indexes, n)
+	}
+	sort.Ints(indexes)
+	entries := make([]batchEntry, 0, len(indexes))
+	for _, n := range indexes {
+		entries = append(entries, batchEntry{form: byIndex[n]})
+	}
+	return r.FormValue("QueueUrl"), entries, nil
+}
+
+// validateBatchEntries checks the batch as a whole before any entry is
+// processed: it must have 1 to 10 entries whose Ids are valid and distinct
+func validateBatchEntries(entries []batchEntry) error {
+	if len(entries) == 0 {
+		return &QueueError{"EmptyBatchRequest", "There should be at least one entry in the request"}
+	}
+	if len(entries) > maxBatchEntries {
+		return &QueueError{"TooManyEntriesInBatchRequest",
+			"Maximum number of entries per request are " + strconv.Itoa(maxBatchEntries) + ". You have sent " + strconv.Itoa(len(entries)) + "."}
+	}
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		id := entry.String("Id")
+		if !batchEntryIDPattern.MatchString(id) {
+			return &QueueError{"InvalidBatchEntryId",
+				"A batch entry id can only contain alphanumeric characters, hyphens and underscores. It can be at most 80 letters long."}
+		}
+		if seen[id] {
+			return &QueueError{"BatchEntryIdsNotDistinct", "Id " + id + " repeated"}
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// BatchResultErrorEntry reports an entry of a batch request that failed
+type BatchResultErrorEntry struct {
+	Id          string `xml:"Id" json:"Id"`
+	Code        string `xml:"Code" json:"Code"`
+	Message     string `xml:"Message" json:"Message"`
+	SenderFault bool   `xml:"SenderFault" json:"SenderFault"`
+}
+
+// batchError converts the error of a failed entry into its result
+func batchError(id string, err error) BatchResultErrorEntry {
+	var queueErr *QueueError
+	if errors.As(err, &queueErr) {
+		return BatchResultErrorEntry{Id: id, Code: queueErr.Code, Message: queueErr.Message, SenderFault: true}
+	}
+	return BatchResultErrorEntry{Id: id, Code: "InternalError", Message: err.Error()}
+}
+
+// getBatchQueue parses and validates a batch request and looks up its queue,
+// sending the error response and returning false if any of that fails
+func getBatchQueue(w http.ResponseWriter, r *http.Request, entryPrefix string) (*Queue, []batchEntry, bool) {
+	queueURL, entries, err := parseBatchRequest(r, entryPrefix)
+	if err != nil {
+		sendError(w, r, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	queue, ok := requestQueue(w, r, queueURL)
+	if !ok {
+		return nil, nil, false
+	}
+
+	if err := validateBatchEntries(entries); err != nil {
+		sendQueueError(w, r, err)
+		return nil, nil, false
+	}
+	return queue, entries, true
+}
+
+func handleSendMessageBatch(w http.ResponseWriter, r *http.Request) {
+	queue, entries, ok := getBatchQueue(w, r, "SendMessageBatchRequestEntry")
+	if !ok {
+		return
+	}
+
+	type SendMessageBatchResultEntry struct {
+		Id                           string `xml:"Id" json:"Id"`
+		MessageId                    string `xml:"MessageId" json:"MessageId"`
+		MD5OfMessageBody             string `xml:"MD5OfMessageBody" json:"MD5OfMessageBody"`
+		MD5OfMessageSystemAttributes string `xml:"MD5OfMessageSystemAttributes,omitempty" json:"MD5OfMessageSystemAttributes,omitempty"`
+		SequenceNumber               string `xml:"SequenceNumber,omitempty" json:"SequenceNumber,omitempty"`
+	}
+
+	successful := []SendMessageBatchResultEntry{}
+	failed := []BatchResultErrorEntry{}
+	for _, entry := range entries {
+		id := entry.String("Id")
+		systemAttributes := entry.MessageSystemAttributes()
+		if err := checkMessageSystemAttributes(systemAttributes); err != nil {
+			failed = append(failed, batchError(id, err))
+			continue
+		}
+		deduplicationId, groupId := entry.String("MessageDeduplicationId"), entry.String("MessageGroupId")
+		if err := checkFifoParameters(queue, deduplicationId, groupId); err != nil {
+			failed = append(failed, batchError(id, err))
+			continue
+		}
+		delaySeconds, _ := entry.Int("DelaySeconds")
+
+		msg, err := queue.SendMessage(entry.String("MessageBody"), entry.MessageAttributes(), systemAttributes,
+			delaySeconds, deduplicationId, groupId)
+		if err != nil {
+			failed = append(failed, batchError(id, err))
+			continue
+		}
+		successful = append(successful, SendMessageBatchResultEntry{
+			Id:                           id,
+			MessageId:                    msg.MessageID,
+			MD5OfMessageBody:             msg.MD5OfBody,
+			MD5OfMessageSystemAttributes: systemAttributesMD5(msg.MessageSystemAttributes),
+			SequenceNumber:               msg.SequenceNumber,
+		})
+	}
+
+	type SendMessageBatchResponse struct {
+		XMLName xml.Name `xml:"SendMessageBatchResponse"`
+		Result  struct {
+			Successful []SendMessageBatchResultEntry `xml:"SendMessageBatchResultEntry"`
+			Failed     []BatchResultErrorEntry       `xml:"BatchResultErrorEntry"`
+		} `xml:"SendMessageBatchResult"`
+	}
+
+	resp := SendMessageBatchResponse{}
+	resp.Result.Successful = successful
+	resp.Result.Failed = failed
+	sendResponse(w, r, resp, map[string]interface{}{"Successful": successful, "Failed": failed})
+}
+
+func handleDeleteMessageBatch(w http.ResponseWriter, r *http.Request) {
+	queue, entries, ok := getBatchQueue(w, r, "DeleteMessageBatchRequestEntry")
+	if !ok {
+		return
+	}
+
+	type DeleteMessageBatchResultEntry struct {
+		Id string `xml:"Id" json:"Id"`
+	}
+
+	successful := []DeleteMessageBatchResultEntry{}
+	failed := []BatchResultErrorEntry{}
+	for _, entry := range entries {
+		id := entry.String("Id")
+		if err := queue.DeleteMessage(entry.String("ReceiptHandle")); err != nil {
+			failed = append(failed, batchError(id, err))
+			continue
+		}
+		successful = append(successful, DeleteMessageBatchResultEntry{Id: id})
+	}
+
+	type DeleteMessageBatchResponse struct {
+		XMLName xml.Name `xml:"DeleteMessageBatchResponse"`
+		Result  struct {
+			Successful []DeleteMessageBatchResultEntry `xml:"DeleteMessageBatchResultEntry"`
+			Failed     []BatchResultErrorEntry         `xml:"BatchResultErrorEntry"`
+		} `xml:"DeleteMessageBatchResult"`
+	}
+
+	resp := DeleteMessageBatchResponse{}
+	resp.Result.Successful = successful
+	resp.Result.Failed = failed
+	sendResponse(w, r, resp, map[string]interface{}{"Successful": successful, "Failed": failed})
+}
+
+func handleChangeMessageVisibilityBatch(w http.ResponseWriter, r *http.Request) {
+	queue, entries, ok := getBatchQueue(w, r, "ChangeMessageVisibilityBatchRequestEntry")
+	if !ok {
+		return
+	}
+
+	type ChangeMessageVisibilityBatchResultEntry struct {
+		Id string `xml:"Id" json:"Id"`
+	}
+
+	successful := []ChangeMessageVisibilityBatchResultEntry{}
+	failed := []BatchResultErrorEntry{}
+	for _, entry := range entries {
+		id := entry.String("Id")
+		visibilityTimeout, given := entry.Int("VisibilityTimeout")
+		if !given || visibilityTimeout < 0 || visibilityTimeout > 43200 {
+			failed = append(failed, batchError(id, &QueueError{"InvalidParameterValue",
+				"VisibilityTimeout must be between 0 and 43200 seconds"}))
+			continue
+		}
+		if err := queue.ChangeMessageVisibility(entry.String("ReceiptHandle"), visibilityTimeout); err != nil {
+			failed = append(failed, batchError(id, err))
+			continue
+		}
+		successful = append(successful, ChangeMessageVisibilityBatchResultEntry{Id: id})
+	}
+
+	type ChangeMessageVisibilityBatchResponse struct {
+		XMLName xml.Name `xml:"ChangeMessageVisibilityBatchResponse"`
+		Result  struct {
+			Successful []ChangeMessageVisibilityBatchResultEntry `xml:"ChangeMessageVisibilityBatchResultEntry"`
+			Failed     []BatchResultErrorEntry                   `xml:"BatchResultErrorEntry"`
+		} `xml:"ChangeMessageVisibilityBatchResult"`
+	}
+
+	resp := ChangeMessageVisibilityBatchResponse{}
+	resp.Result.Successful = successful
+	resp.Result.Failed = failed
+	sendResponse(w, r, resp, map[string]interface{}{"Successful": successful, "Failed": failed})
+}
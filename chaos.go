@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// injectFault applies the chaos configuration to an SQS request before its
+// action runs: it delays receives and randomly fails requests with the errors
+// a client's retry logic has to cope with. It reports whether it has already
+// answered the request, in which case the action must not run.
+func injectFault(w http.ResponseWriter, r *http.Request, action string) bool {
+	chaos := activeConfig.Chaos
+
+	if chaos.ThrottleRate > 0 && rand.Float64() < chaos.ThrottleRate {
+		slog.Debug("injected throttling", "action", action)
+		sendError(w, r, "RequestThrottled", "Rate exceeded", http.StatusForbidden)
+		return true
+	}
+
+	switch action {
+	case "SendMessage", "SendMessageBatch":
+		if chaos.SendErrorRate > 0 && rand.Float64() < chaos.SendErrorRate {
+			slog.Debug("injected send error", "action", action)
+			sendError(w, r, "ServiceUnavailable", "The service is unavailable. Please retry", http.StatusServiceUnavailable)
+			return true
+		}
+	case "ReceiveMessage":
+		if chaos.ReceiveLatencyMS > 0 {
+			select {
+			case <-time.After(time.Duration(chaos.ReceiveLatencyMS) * time.Millisecond):
+			case <-r.Context().Done():
+				return true
+			}
+		}
+	}
+	return false
+}
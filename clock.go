@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells queues the time. Everything that depends on how long a message
+// has been around reads it: delays, visibility timeouts, retention and the
+// FIFO deduplication window.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the wall clock, which queues use unless told otherwise
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// offsetClock runs with another clock but can be moved forward instantly, so
+// tests can skip over a visibility timeout or retention period instead of
+// sleeping through it. It is safe for concurrent use.
+type offsetClock struct {
+	base   Clock
+	mu     sync.Mutex
+	offset time.Duration
+}
+
+func (c *offsetClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.base.Now().Add(c.offset)
+}
+
+// Advance moves the clock forward by d and returns the total offset
+func (c *offsetClock) Advance(d time.Duration) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offset += d
+	return c.offset
+}
@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log/slog"
+)
+
+// Message bodies longer than storage.compress_threshold_bytes are kept
+// gzipped in memory and decompressed whenever they are handed out, so soak
+// tests with large bodies need less memory. Clients can't tell: MD5OfBody is
+// always computed over the original body.
+
+// compressBody gzips the message's body if it is over the configured
+// threshold and compressing makes it smaller
+func (m *Message) compressBody() {
+	threshold := activeConfig.Storage.CompressThresholdBytes
+	if threshold <= 0 || len(m.Body) <= threshold {
+		return
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, m.Body); err != nil {
+		return
+	}
+	if err := zw.Close(); err != nil || buf.Len() >= len(m.Body) {
+		return
+	}
+	m.compressedBody = buf.Bytes()
+	m.Body = ""
+}
+
+// bodyText returns the message's body, decompressing it if it was stored
+// compressed
+func (m *Message) bodyText() string {
+	if m.compressedBody == nil {
+		return m.Body
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(m.compressedBody))
+	if err != nil {
+		slog.Error("failed to decompress message body", "message_id", m.MessageID, "error", err)
+		return ""
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		slog.Error("failed to decompress message body", "message_id", m.MessageID, "error", err)
+		return ""
+	}
+	return string(body)
+}
@@ -3,34 +3,275 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"maps"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the Ess-Queue-Ess configuration
 type Config struct {
-	Server ServerConfig  `yaml:"server"`
-	Queues []QueueConfig `yaml:"queues"`
+	Server    ServerConfig     `yaml:"server"`
+	Queues    []QueueConfig    `yaml:"queues"`
+	Instances []InstanceConfig `yaml:"instances"`
+	Auth      AuthConfig       `yaml:"auth"`
+	Log       LogConfig        `yaml:"log"`
+	Admin     AdminConfig      `yaml:"admin"`
+	Storage   StorageConfig    `yaml:"storage"`
+	Chaos     ChaosConfig      `yaml:"chaos"`
+	RateLimit RateLimitConfig  `yaml:"rate_limit"` // applies to each queue without its own
+
+	// Subscriptions map a source queue to queues that get a copy of every
+	// message sent to it, like SNS fan-out with raw message delivery
+	Subscriptions map[string][]string `yaml:"subscriptions"`
 }
 
 // ServerConfig holds HTTP server settings
 type ServerConfig struct {
-	Port int    `yaml:"port"`
-	Host string `yaml:"host"`
+	Port    int    `yaml:"port"`
+	Host    string `yaml:"host"`
+	Lenient bool   `yaml:"lenient"` // enable emulator-specific extensions to the SQS API
+	Debug   bool   `yaml:"debug"`   // record extra diagnostics such as per-message delivery history
+
+	// PurgeCooldown is how many seconds must pass between two PurgeQueue calls
+	// on the same queue. Unset means 60, as in SQS; 0 disables the check.
+	PurgeCooldown *int `yaml:"purge_cooldown"`
+
+	// MaxInFlightMessages and MaxInFlightMessagesFIFO cap the messages a
+	// standard or FIFO queue may have in flight; a receive at the cap fails
+	// with OverLimit. Unset means 120000 and 20000, as in SQS; 0 disables the
+	// check.
+	MaxInFlightMessages     *int `yaml:"max_in_flight_messages"`
+	MaxInFlightMessagesFIFO *int `yaml:"max_in_flight_messages_fifo"`
+
+	// Defaults are the settings given to queues that don't set their own
+	Defaults QueueDefaults `yaml:"defaults"`
+
+	// WarnOnInvalidDLQ logs a warning instead of rejecting a RedrivePolicy whose
+	// dead-letter target doesn't exist (yet) or is the wrong queue type
+	WarnOnInvalidDLQ bool `yaml:"warn_on_invalid_dlq"`
+
+	// AccountID is the AWS account ID used in queue URLs and ARNs
+	AccountID string `yaml:"account_id"`
+
+	// DedupIncludeAttributes makes content-based deduplication hash the
+	// message attributes along with the body. SQS hashes only the body.
+	DedupIncludeAttributes bool `yaml:"dedup_include_attributes"`
+
+	// OrderBySentTime keeps standard queues in send order: messages moved to
+	// a dead-letter queue or redriven are inserted by SentTimestamp instead of
+	// appended, and receives return the oldest visible messages first
+	OrderBySentTime bool `yaml:"order_by_sent_time"`
+
+	// APIVersion pins the SQS API version named in the xmlns of XML
+	// responses. Unset, the Version a request gives is used, or 2012-11-05.
+	APIVersion string `yaml:"api_version"`
+
+	// StartupGracePeriod is how many seconds after startup messages that were
+	// in flight when they were exported stay in flight when imported, so they
+	// don't all become visible at once. Each is held for a share of what is
+	// left of the period, staggering their return. 0 makes them visible
+	// straight away.
+	StartupGracePeriod int `yaml:"startup_grace_period"`
+}
+
+// QueueDefaults holds the default queue settings. Settings left unset fall
+// back to the AWS defaults.
+type QueueDefaults struct {
+	VisibilityTimeout      int `yaml:"default_visibility_timeout"` // seconds, default 30
+	MessageRetentionPeriod int `yaml:"default_retention_period"`   // seconds, default 345600 (4 days)
+	MaximumMessageSize     int `yaml:"default_max_message_size"`   // bytes, default 262144 (256KB)
+	ReceiveMessageWaitTime int `yaml:"default_receive_wait"`       // seconds, default 0
+}
+
+// awsQueueDefaults are the settings SQS gives a queue created without attributes
+var awsQueueDefaults = QueueDefaults{
+	VisibilityTimeout:      30,
+	MessageRetentionPeriod: 345600,
+	MaximumMessageSize:     262144,
+	ReceiveMessageWaitTime: 0,
+}
+
+// validate fills in unset defaults and checks the rest are within the AWS limits
+func (d *QueueDefaults) validate() error {
+	if d.VisibilityTimeout == 0 {
+		d.VisibilityTimeout = awsQueueDefaults.VisibilityTimeout
+	}
+	if d.MessageRetentionPeriod == 0 {
+		d.MessageRetentionPeriod = awsQueueDefaults.MessageRetentionPeriod
+	}
+	if d.MaximumMessageSize == 0 {
+		d.MaximumMessageSize = awsQueueDefaults.MaximumMessageSize
+	}
+
+	if d.VisibilityTimeout < 0 || d.VisibilityTimeout > 43200 {
+		return fmt.Errorf("server.defaults.default_visibility_timeout must be from 0 to 43200 seconds")
+	}
+	if d.MessageRetentionPeriod < 60 || d.MessageRetentionPeriod > 1209600 {
+		return fmt.Errorf("server.defaults.default_retention_period must be from 60 to 1209600 seconds")
+	}
+	if d.MaximumMessageSize < 1024 || d.MaximumMessageSize > 262144 {
+		return fmt.Errorf("server.defaults.default_max_message_size must be from 1024 to 262144 bytes")
+	}
+	if d.ReceiveMessageWaitTime < 0 || d.ReceiveMessageWaitTime > 20 {
+		return fmt.Errorf("server.defaults.default_receive_wait must be from 0 to 20 seconds")
+	}
+	return nil
+}
+
+// StorageConfig controls how messages are kept in memory
+type StorageConfig struct {
+	// CompressThresholdBytes makes message bodies longer than this many bytes
+	// be stored gzipped. 0 stores every body as is.
+	CompressThresholdBytes int `yaml:"compress_threshold_bytes"`
+}
+
+// ChaosConfig injects latency and errors into SQS requests, to exercise a
+// client's timeout and retry handling. Everything is off by default.
+type ChaosConfig struct {
+	ReceiveLatencyMS int     `yaml:"receive_latency_ms"` // delay before every ReceiveMessage, on top of long polling
+	SendErrorRate    float64 `yaml:"send_error_rate"`    // fraction of sends failing with ServiceUnavailable (503)
+	ThrottleRate     float64 `yaml:"throttle_rate"`      // fraction of all SQS requests failing with RequestThrottled
+}
+
+// RateLimitConfig caps how many sends and receives a queue accepts per
+// second, throttling the excess with RequestThrottled. 0 means unlimited.
+type RateLimitConfig struct {
+	SendPerSecond    float64 `yaml:"send_per_second"`    // SendMessage and SendMessageBatch calls
+	ReceivePerSecond float64 `yaml:"receive_per_second"` // ReceiveMessage calls
+}
+
+// AdminConfig holds settings for the admin UI and API
+type AdminConfig struct {
+	CORS CORSConfig `yaml:"cors"`
+
+	// AuthToken, when set, must be sent as "Authorization: Bearer <token>"
+	// with every admin UI and admin API request
+	AuthToken string `yaml:"auth_token"`
+}
+
+// CORSConfig lists the cross-origin requests allowed to the admin API. With
+// no allowed origins, only same-origin requests work.
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins"` // "*" allows any origin
+	AllowedMethods []string `yaml:"allowed_methods"` // default GET, POST, DELETE
+	AllowedHeaders []string `yaml:"allowed_headers"` // default Content-Type
+}
+
+// LogConfig controls log output
+type LogConfig struct {
+	Level  string `yaml:"level"`  // debug, info, warn or error; default info
+	Format string `yaml:"format"` // text or json; default text
+}
+
+func (c LogConfig) levelOrDefault() string {
+	if c.Level == "" {
+		return "info"
+	}
+	return c.Level
+}
+
+// AuthConfig controls request authentication. By default every request is
+// accepted; with VerifySignature set, SQS requests must carry a valid AWS
+// Signature Version 4 computed with the configured credentials.
+type AuthConfig struct {
+	VerifySignature bool   `yaml:"verify_signature"`
+	AccessKey       string `yaml:"access_key"`
+	SecretKey       string `yaml:"secret_key"`
+}
+
+// InstanceConfig defines an additional emulator instance with its own isolated
+// set of queues, served under the /<name>/ path prefix
+type InstanceConfig struct {
+	Name          string              `yaml:"name"`
+	Queues        []QueueConfig       `yaml:"queues"`
+	Subscriptions map[string][]string `yaml:"subscriptions"`
 }
 
 // QueueConfig represents a queue to be created at startup
 type QueueConfig struct {
-	Name                   string            `yaml:"name"`
-	VisibilityTimeout      int               `yaml:"visibility_timeout"`        // seconds, default 30
-	MessageRetentionPeriod int               `yaml:"message_retention_period"`  // seconds, default 345600 (4 days)
-	MaximumMessageSize     int               `yaml:"maximum_message_size"`      // bytes, default 262144 (256KB)
-	MaxReceiveCount        int               `yaml:"max_receive_count"`         // default 3
-	DelaySeconds           int               `yaml:"delay_seconds"`             // default 0
-	ReceiveMessageWaitTime int               `yaml:"receive_message_wait_time"` // seconds, default 0
-	Attributes             map[string]string `yaml:"attributes"`                // additional custom attributes
+	Name                      string            `yaml:"name"`
+	VisibilityTimeout         int               `yaml:"visibility_timeout"`           // seconds, default 30
+	MessageRetentionPeriod    int               `yaml:"message_retention_period"`     // seconds, default 345600 (4 days)
+	MaximumMessageSize        int               `yaml:"maximum_message_size"`         // bytes, default 262144 (256KB)
+	MaxReceiveCount           int               `yaml:"max_receive_count"`            // default 3
+	DelaySeconds              int               `yaml:"delay_seconds"`                // default 0
+	ReceiveMessageWaitTime    int               `yaml:"receive_message_wait_time"`    // seconds, default 0
+	MaxVisibilityTimeout      int               `yaml:"max_visibility_timeout"`       // seconds, default 43200 (12 hours)
+	MaxLongPollWaiters        int               `yaml:"max_long_poll_waiters"`        // concurrent long polls, default 0 (unlimited)
+	DeduplicationWindow       int               `yaml:"deduplication_window_seconds"` // FIFO only, seconds, default 300
+	MaxMessages               int               `yaml:"max_messages"`                 // sends fail with OverLimit once the queue holds this many, default 0 (unlimited)
+	StrictOrdering            bool              `yaml:"strict_ordering"`              // standard only: receive the earliest-sent visible messages first
+	RateLimit                 RateLimitConfig   `yaml:"rate_limit"`                   // overrides the global rate_limit for this queue
+	Fifo                      bool              `yaml:"fifo"`                         // FIFO queue; the name must end with .fifo
+	ContentBasedDeduplication bool              `yaml:"content_based_deduplication"`  // FIFO only
+	DeadLetterTarget          string            `yaml:"dead_letter_target"`           // name of a configured queue to move messages to after max_receive_count receives
+	Trigger                   *TriggerConfig    `yaml:"trigger"`                      // deliver messages to a webhook, like a Lambda trigger
+	Attributes                map[string]string `yaml:"attributes"`                   // additional custom attributes
+}
+
+// TriggerConfig makes the server POST a queue's messages to a webhook as SQS
+// events, the way a Lambda event source mapping would
+type TriggerConfig struct {
+	URL       string `yaml:"url"`
+	BatchSize int    `yaml:"batch_size"` // messages per event, 1-10, default 10
+}
+
+// validate checks the webhook URL and fills in the default batch size
+func (t *TriggerConfig) validate() error {
+	parsed, err := url.Parse(t.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("trigger.url %q must be an http or https URL", t.URL)
+	}
+	if t.BatchSize == 0 {
+		t.BatchSize = 10
+	}
+	if t.BatchSize < 1 || t.BatchSize > 10 {
+		return fmt.Errorf("trigger.batch_size must be between 1 and 10, got %d", t.BatchSize)
+	}
+	return nil
+}
+
+// defaultAccountID is the account ID of queues when none is configured
+const defaultAccountID = "000000000000"
+
+// activeConfig holds the configuration the server is running with. It starts
+// out with the built-in defaults and is replaced when a config file is loaded.
+var activeConfig = &Config{
+	Server: ServerConfig{
+		Port:      9324,
+		Host:      "0.0.0.0",
+		AccountID: defaultAccountID,
+		Defaults:  awsQueueDefaults,
+	},
+}
+
+// purgeCooldown returns the minimum time between purges of a queue
+func purgeCooldown() time.Duration {
+	if activeConfig.Server.PurgeCooldown == nil {
+		return 60 * time.Second
+	}
+	return time.Duration(*activeConfig.Server.PurgeCooldown) * time.Second
+}
+
+// maxInFlightMessages returns the most messages a queue may have in flight,
+// or 0 for no limit
+func maxInFlightMessages(fifo bool) int {
+	limit, fallback := activeConfig.Server.MaxInFlightMessages, 120000
+	if fifo {
+		limit, fallback = activeConfig.Server.MaxInFlightMessagesFIFO, 20000
+	}
+	if limit == nil {
+		return fallback
+	}
+	return *limit
 }
 
 // LoadConfig reads and parses the YAML configuration file
@@ -52,45 +293,456 @@ func LoadConfig(path string) (*Config, error) {
 	if config.Server.Host == "" {
 		config.Server.Host = "0.0.0.0"
 	}
+	if config.Server.AccountID == "" {
+		config.Server.AccountID = defaultAccountID
+	}
+	if !validAccountID(config.Server.AccountID) {
+		return nil, fmt.Errorf("server.account_id %q must be 12 digits", config.Server.AccountID)
+	}
+
+	if config.Server.APIVersion != "" && !validAPIVersion(config.Server.APIVersion) {
+		return nil, fmt.Errorf("server.api_version %q must be a date such as 2012-11-05", config.Server.APIVersion)
+	}
+
+	for name, limit := range map[string]*int{
+		"max_in_flight_messages":      config.Server.MaxInFlightMessages,
+		"max_in_flight_messages_fifo": config.Server.MaxInFlightMessagesFIFO,
+	} {
+		if limit != nil && *limit < 0 {
+			return nil, fmt.Errorf("server.%s must not be negative", name)
+		}
+	}
+
+	if config.Server.StartupGracePeriod < 0 || config.Server.StartupGracePeriod > 43200 {
+		return nil, fmt.Errorf("server.startup_grace_period must be from 0 to 43200 seconds")
+	}
+
+	if config.Chaos.ReceiveLatencyMS < 0 {
+		return nil, fmt.Errorf("chaos.receive_latency_ms must not be negative")
+	}
+	for name, rate := range map[string]float64{
+		"send_error_rate": config.Chaos.SendErrorRate,
+		"throttle_rate":   config.Chaos.ThrottleRate,
+	} {
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("chaos.%s must be from 0 to 1", name)
+		}
+	}
+
+	if config.RateLimit.SendPerSecond < 0 || config.RateLimit.ReceivePerSecond < 0 {
+		return nil, fmt.Errorf("rate_limit rates must not be negative")
+	}
+
+	if config.Storage.CompressThresholdBytes < 0 {
+		return nil, fmt.Errorf("storage.compress_threshold_bytes must not be negative")
+	}
+
+	if err := config.Server.Defaults.validate(); err != nil {
+		return nil, err
+	}
+
+	if config.Auth.VerifySignature && (config.Auth.AccessKey == "" || config.Auth.SecretKey == "") {
+		return nil, fmt.Errorf("auth.verify_signature requires auth.access_key and auth.secret_key")
+	}
+
+	applyQueueDefaults(config.Queues, config.Server.Defaults)
+	if err := resolveQueueAttributes(config.Queues, config.Server.AccountID); err != nil {
+		return nil, err
+	}
+	if err := validateTriggers(config.Queues); err != nil {
+		return nil, err
+	}
+	if err := validateSubscriptions(config.Subscriptions); err != nil {
+		return nil, err
+	}
+
+	instanceNames := make(map[string]bool)
+	for _, instance := range config.Instances {
+		if instance.Name == "" || strings.Contains(instance.Name, "/") {
+			return nil, fmt.Errorf("invalid instance name %q", instance.Name)
+		}
+		if instance.Name == "admin" || instance.Name == "health" {
+			return nil, fmt.Errorf("instance name %q is reserved", instance.Name)
+		}
+		if instanceNames[instance.Name] {
+			return nil, fmt.Errorf("duplicate instance name %q", instance.Name)
+		}
+		instanceNames[instance.Name] = true
+		applyQueueDefaults(instance.Queues, config.Server.Defaults)
+		if err := resolveQueueAttributes(instance.Queues, config.Server.AccountID); err != nil {
+			return nil, fmt.Errorf("instance %q: %w", instance.Name, err)
+		}
+		if err := validateTriggers(instance.Queues); err != nil {
+			return nil, fmt.Errorf("instance %q: %w", instance.Name, err)
+		}
+		if err := validateSubscriptions(instance.Subscriptions); err != nil {
+			return nil, fmt.Errorf("instance %q: %w", instance.Name, err)
+		}
+	}
+
+	return &config, nil
+}
+
+// validAccountID reports whether id looks like an AWS account ID
+func validAccountID(id string) bool {
+	if len(id) != 12 {
+		return false
+	}
+	for _, c := range id {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// checkQueueNames rejects a queue list that names the same queue twice.
+// CreateQueue returns the existing queue for a name it has seen, so the second
+// entry's settings would otherwise be silently ignored.
+func checkQueueNames(queues []QueueConfig) error {
+	seen := make(map[string]bool, len(queues))
+	for _, q := range queues {
+		if seen[q.Name] {
+			return fmt.Errorf("duplicate queue name %q", q.Name)
+		}
+		seen[q.Name] = true
+	}
+	return nil
+}
+
+// validateTriggers validates the webhook triggers of the configured queues
+func validateTriggers(queues []QueueConfig) error {
+	for _, q := range queues {
+		if q.Trigger == nil {
+			continue
+		}
+		if err := q.Trigger.validate(); err != nil {
+			return fmt.Errorf("queue %s: %w", q.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateSubscriptions checks that fan-out subscriptions name their queues
+// and don't copy a queue into itself
+func validateSubscriptions(subscriptions map[string][]string) error {
+	for source, destinations := range subscriptions {
+		if source == "" {
+			return fmt.Errorf("subscriptions: source queue name must not be empty")
+		}
+		for _, dest := range destinations {
+			if dest == "" {
+				return fmt.Errorf("subscriptions: %q has an empty subscriber queue name", source)
+			}
+			if dest == source {
+				return fmt.Errorf("subscriptions: %q cannot subscribe to itself", source)
+			}
+		}
+	}
+	return nil
+}
 
-	// Apply queue defaults
-	for i := range config.Queues {
-		q := &config.Queues[i]
+// applyQueueDefaults fills in the defaults for queue settings left unset
+func applyQueueDefaults(queues []QueueConfig, defaults QueueDefaults) {
+	for i := range queues {
+		q := &queues[i]
 		if q.VisibilityTimeout == 0 {
-			q.VisibilityTimeout = 30
+			q.VisibilityTimeout = defaults.VisibilityTimeout
 		}
 		if q.MessageRetentionPeriod == 0 {
-			q.MessageRetentionPeriod = 345600 // 4 days
+			q.MessageRetentionPeriod = defaults.MessageRetentionPeriod
 		}
 		if q.MaximumMessageSize == 0 {
-			q.MaximumMessageSize = 262144 // 256KB
+			q.MaximumMessageSize = defaults.MaximumMessageSize
+		}
+		if q.ReceiveMessageWaitTime == 0 {
+			q.ReceiveMessageWaitTime = defaults.ReceiveMessageWaitTime
 		}
 		if q.MaxReceiveCount == 0 {
 			q.MaxReceiveCount = 3
 		}
+		if q.MaxVisibilityTimeout == 0 {
+			q.MaxVisibilityTimeout = 43200 // 12 hours
+		}
+		if q.DeduplicationWindow == 0 {
+			q.DeduplicationWindow = defaultDeduplicationWindow
+		}
 		if q.Attributes == nil {
 			q.Attributes = make(map[string]string)
 		}
 	}
+}
+
+// resolveQueueAttributes turns the fifo, content_based_deduplication and
+// dead_letter_target settings into the FifoQueue, ContentBasedDeduplication
+// and RedrivePolicy attributes that CreateQueue understands. A setting that
+// contradicts an attribute given directly is an error, as is a dead-letter
+// target that isn't one of the queues.
+func resolveQueueAttributes(queues []QueueConfig, accountID string) error {
+	names := make(map[string]bool, len(queues))
+	for _, q := range queues {
+		names[q.Name] = true
+	}
 
-	return &config, nil
+	for i := range queues {
+		q := &queues[i]
+		if q.Fifo {
+			if fifo, ok := q.Attributes["FifoQueue"]; ok && fifo != "true" {
+				return fmt.Errorf("queue %s: fifo contradicts attributes.FifoQueue %q", q.Name, fifo)
+			}
+			q.Attributes["FifoQueue"] = "true"
+		}
+		if q.ContentBasedDeduplication {
+			if contentBased, ok := q.Attributes["ContentBasedDeduplication"]; ok && contentBased != "true" {
+				return fmt.Errorf("queue %s: content_based_deduplication contradicts attributes.ContentBasedDeduplication %q",
+					q.Name, contentBased)
+			}
+			q.Attributes["ContentBasedDeduplication"] = "true"
+		}
+
+		if q.DeadLetterTarget == "" {
+			continue
+		}
+		if _, ok := q.Attributes["RedrivePolicy"]; ok {
+			return fmt.Errorf("queue %s: dead_letter_target and attributes.RedrivePolicy can't both be set", q.Name)
+		}
+		if q.DeadLetterTarget == q.Name {
+			return fmt.Errorf("queue %s: dead_letter_target must be another queue", q.Name)
+		}
+		if !names[q.DeadLetterTarget] {
+			return fmt.Errorf("queue %s: dead_letter_target %q is not a queue in the config", q.Name, q.DeadLetterTarget)
+		}
+		policy, err := json.Marshal(RedrivePolicy{
+			DeadLetterTargetArn: "arn:aws:sqs:" + awsRegion + ":" + accountID + ":" + q.DeadLetterTarget,
+			MaxReceiveCount:     q.MaxReceiveCount,
+		})
+		if err != nil {
+			return err
+		}
+		q.Attributes["RedrivePolicy"] = string(policy)
+	}
+	return nil
+}
+
+// BootstrapQueues creates the configured queues in the queue manager. Every
+// queue is created before any redrive policy is applied, so a queue may name
+// a dead-letter queue that comes after it in the list.
+func BootstrapQueues(queueManager *QueueManager, queues []QueueConfig) error {
+	if err := checkQueueNames(queues); err != nil {
+		return err
+	}
+	for _, queueCfg := range queues {
+		if _, err := createConfiguredQueue(queueManager, queueCfg); err != nil {
+			return err
+		}
+	}
+	for _, queueCfg := range queues {
+		if err := applyConfiguredRedrivePolicy(queueManager, queueCfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createConfiguredQueue creates a queue from its configuration, leaving out
+// its RedrivePolicy since the dead-letter queue may not exist yet.
+// applyConfiguredRedrivePolicy sets it once every queue has been created.
+func createConfiguredQueue(queueManager *QueueManager, queueCfg QueueConfig) (*Queue, error) {
+	attributes := queueCfg.Attributes
+	if _, ok := attributes["RedrivePolicy"]; ok {
+		attributes = maps.Clone(attributes)
+		delete(attributes, "RedrivePolicy")
+	}
+	queue, err := queueManager.CreateQueue(queueCfg.Name, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create queue %s: %w", queueCfg.Name, err)
+	}
+
+	applyQueueConfig(queue, queueCfg)
+	return queue, nil
 }
 
-// BootstrapQueues creates queues defined in the configuration
-func BootstrapQueues(config *Config) error {
+// applyConfiguredRedrivePolicy sets the RedrivePolicy of a queue created by
+// createConfiguredQueue, if it has one
+func applyConfiguredRedrivePolicy(queueManager *QueueManager, queueCfg QueueConfig) error {
+	policy, ok := queueCfg.Attributes["RedrivePolicy"]
+	if !ok {
+		return nil
+	}
+	queue, exists := queueManager.GetQueue(queueCfg.Name)
+	if !exists {
+		// Deleted through the API in the meantime
+		return nil
+	}
+	if err := queue.applyAttributes(map[string]string{"RedrivePolicy": policy}, queueManager.GetQueue); err != nil {
+		return fmt.Errorf("failed to create queue %s: %w", queueCfg.Name, err)
+	}
+	return nil
+}
+
+// applyQueueConfig copies the scalar settings from the configuration onto the queue
+func applyQueueConfig(queue *Queue, queueCfg QueueConfig) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.VisibilityTimeout = queueCfg.VisibilityTimeout
+	queue.MessageRetentionPeriod = queueCfg.MessageRetentionPeriod
+	queue.MaximumMessageSize = queueCfg.MaximumMessageSize
+	queue.MaxReceiveCount = queueCfg.MaxReceiveCount
+	queue.DelaySeconds = queueCfg.DelaySeconds
+	queue.ReceiveMessageWaitTime = queueCfg.ReceiveMessageWaitTime
+	queue.MaxVisibilityTimeout = queueCfg.MaxVisibilityTimeout
+	queue.MaxLongPollWaiters = queueCfg.MaxLongPollWaiters
+	queue.DeduplicationWindow = queueCfg.DeduplicationWindow
+	queue.MaxMessages = queueCfg.MaxMessages
+	queue.StrictOrdering = queueCfg.StrictOrdering
+	queue.RateLimit = queueCfg.RateLimit
+
+	queue.Trigger = nil
+	if queueCfg.Trigger != nil {
+		trigger := *queueCfg.Trigger
+		queue.Trigger = &trigger
+		queue.startTrigger()
+	}
+}
+
+// AttributeChange records the before and after value of a queue setting
+type AttributeChange struct {
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// QueueChange lists the settings that a reload changed on an existing queue
+type QueueChange struct {
+	Name    string                     `json:"name"`
+	Changes map[string]AttributeChange `json:"changes"`
+}
+
+// ReloadResult describes what the last configuration reload did
+type ReloadResult struct {
+	Time      time.Time     `json:"time"`
+	Path      string        `json:"path"`
+	Added     []string      `json:"added"`
+	Updated   []QueueChange `json:"updated"`
+	Unchanged []string      `json:"unchanged"`
+	Error     string        `json:"error,omitempty"`
+}
+
+var (
+	lastReload   *ReloadResult
+	lastReloadMu sync.RWMutex
+)
+
+// LastReload returns the result of the most recent configuration reload, or nil
+func LastReload() *ReloadResult {
+	lastReloadMu.RLock()
+	defer lastReloadMu.RUnlock()
+	return lastReload
+}
+
+// ReloadConfig re-reads the configuration file, creates queues that don't exist yet
+// and applies the configured settings to queues that do. Queues that are not in the
+// file are left untouched. Only the default instance's queues are reloaded. The
+// result is logged and kept for the admin API.
+func ReloadConfig(path string, queueManager *QueueManager) (*ReloadResult, error) {
+	result := &ReloadResult{
+		Time:      time.Now(),
+		Path:      path,
+		Added:     make([]string, 0),
+		Updated:   make([]QueueChange, 0),
+		Unchanged: make([]string, 0),
+	}
+
+	err := reloadQueues(path, queueManager, result)
+	if err != nil {
+		result.Error = err.Error()
+		slog.Error("config reload failed", "path", path, "error", err)
+	} else {
+		slog.Info("config reloaded", "path", path,
+			"added", len(result.Added), "updated", len(result.Updated), "unchanged", len(result.Unchanged))
+		for _, name := range result.Added {
+			slog.Info("config reload added queue", "queue", name)
+		}
+		for _, change := range result.Updated {
+			for setting, values := range change.Changes {
+				slog.Info("config reload updated queue", "queue", change.Name,
+					"setting", setting, "before", values.Before, "after", values.After)
+			}
+		}
+	}
+
+	lastReloadMu.Lock()
+	lastReload = result
+	lastReloadMu.Unlock()
+
+	return result, err
+}
+
+func reloadQueues(path string, queueManager *QueueManager, result *ReloadResult) error {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	if err := checkQueueNames(config.Queues); err != nil {
+		return err
+	}
+
+	// As at startup, new queues are all created before any redrive policy
+	// is applied, so a queue may name a dead-letter queue that is new in
+	// the file and comes after it
+	added := make(map[string]bool)
 	for _, queueCfg := range config.Queues {
-		queue, err := queueManager.CreateQueue(queueCfg.Name, queueCfg.Attributes)
-		if err != nil {
-			return fmt.Errorf("failed to create queue %s: %w", queueCfg.Name, err)
+		if _, exists := queueManager.GetQueue(queueCfg.Name); exists {
+			continue
 		}
+		if _, err := createConfiguredQueue(queueManager, queueCfg); err != nil {
+			return err
+		}
+		added[queueCfg.Name] = true
+		result.Added = append(result.Added, queueCfg.Name)
+	}
 
-		// Apply queue configuration
-		queue.VisibilityTimeout = queueCfg.VisibilityTimeout
-		queue.MessageRetentionPeriod = queueCfg.MessageRetentionPeriod
-		queue.MaximumMessageSize = queueCfg.MaximumMessageSize
-		queue.MaxReceiveCount = queueCfg.MaxReceiveCount
-		queue.DelaySeconds = queueCfg.DelaySeconds
-		queue.ReceiveMessageWaitTime = queueCfg.ReceiveMessageWaitTime
+	for _, queueCfg := range config.Queues {
+		if added[queueCfg.Name] {
+			if err := applyConfiguredRedrivePolicy(queueManager, queueCfg); err != nil {
+				return err
+			}
+			continue
+		}
+
+		queue, exists := queueManager.GetQueue(queueCfg.Name)
+		if !exists {
+			continue
+		}
+
+		before := queue.Settings()
+		if err := queue.applyAttributes(queueCfg.Attributes, queueManager.GetQueue); err != nil {
+			return fmt.Errorf("failed to update queue %s: %w", queueCfg.Name, err)
+		}
+		applyQueueConfig(queue, queueCfg)
+		after := queue.Settings()
+
+		changes := make(map[string]AttributeChange)
+		for setting, value := range after {
+			if before[setting] != value {
+				changes[setting] = AttributeChange{Before: before[setting], After: value}
+			}
+		}
+		if len(changes) == 0 {
+			result.Unchanged = append(result.Unchanged, queueCfg.Name)
+		} else {
+			result.Updated = append(result.Updated, QueueChange{Name: queueCfg.Name, Changes: changes})
+		}
 	}
+
+	queueManager.SetSubscriptions(config.Subscriptions)
+
 	return nil
 }
+
+// validAPIVersion reports whether version looks like an SQS API version,
+// which is a date such as 2012-11-05
+func validAPIVersion(version string) bool {
+	_, err := time.Parse("2006-01-02", version)
+	return err == nil
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Queue event types
+const (
+	eventSent       = "sent"
+	eventReceived   = "received"
+	eventDeleted    = "deleted"
+	eventMovedToDLQ = "moved_to_dlq"
+	eventExpired    = "expired"
+)
+
+// QueueEvent describes a change to a queue's messages. Counts are taken
+// right after the change so subscribers can update without refetching.
+type QueueEvent struct {
+	Type      string      `json:"type"`
+	Queue     string      `json:"queue"`
+	MessageID string      `json:"message_id,omitempty"`
+	DLQ       string      `json:"dlq,omitempty"` // target queue of a moved_to_dlq event
+	Time      time.Time   `json:"time"`
+	Counts    QueueCounts `json:"counts"`
+}
+
+// QueueCounts are the message counts of a queue by state
+type QueueCounts struct {
+	Visible    int `json:"visible"`
+	NotVisible int `json:"not_visible"`
+	Delayed    int `json:"delayed"`
+}
+
+// subscriberBuffer is how many events a slow subscriber may fall behind
+// before further events are dropped for it
+const subscriberBuffer = 256
+
+// eventBus fans queue events out to subscribers. Publishing never blocks, so
+// it is safe to publish while holding a queue lock.
+type eventBus struct {
+	mu          sync.RWMutex
+	subscribers map[chan QueueEvent]struct{}
+}
+
+// queueEvents is the bus that all queues publish to
+var queueEvents = &eventBus{subscribers: make(map[chan QueueEvent]struct{})}
+
+// Subscribe returns a channel of events and a function that unsubscribes
+// and closes it
+func (b *eventBus) Subscribe() (<-chan QueueEvent, func()) {
+	ch := make(chan QueueEvent, subscriberBuffer)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// HasSubscribers reports whether anyone is listening, so publishers can skip
+// building events nobody will see
+func (b *eventBus) HasSubscribers() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscribers) > 0
+}
+
+// Publish sends the event to every subscriber that has room for it
+func (b *eventBus) Publish(event QueueEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -3,53 +3,158 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"embed"
+	"encoding/base64"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"mime"
 	"net/http"
 	"net/url"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"text/template"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/google/uuid"
 )
 
 //go:embed admin.html
 var adminHTML embed.FS
 
-var queueManager = NewQueueManager()
+type contextKey string
 
-// SQS API Handler
-func sqsHandler(w http.ResponseWriter, r *http.Request) {
-	var action string
+const (
+	queueManagerKey  contextKey = "queueManager"
+	correlationIDKey contextKey = "correlationID"
+	requestJSONKey   contextKey = "requestJSON"
+)
 
-	// AWS CLI/SDK can send requests in multiple formats:
-	// 1. Query protocol (form-encoded) - older style
-	// 2. JSON protocol with X-Amz-Target header - newer AWS CLI default
+// correlationIDHeader lets clients tag requests with their own trace ID
+const correlationIDHeader = "X-Correlation-Id"
 
-	// Check for X-Amz-Target header (JSON protocol)
-	target := r.Header.Get("X-Amz-Target")
-	if target != "" {
-		// Extract action from target like "AmazonSQS.CreateQueue"
-		parts := strings.Split(target, ".")
-		if len(parts) == 2 {
-			action = parts[1]
+// withQueueManager makes qm the queue manager for the requests handled by next
+func withQueueManager(qm *QueueManager) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), queueManagerKey, qm)))
+		})
+	}
+}
+
+// getQueueManager returns the queue manager serving the request
+func getQueueManager(r *http.Request) *QueueManager {
+	return r.Context().Value(queueManagerKey).(*QueueManager)
+}
+
+// withCorrelationID takes the correlation ID from the X-Correlation-Id header,
+// falling back to the request ID, and echoes it on the response. It must run
+// after middleware.RequestID.
+func withCorrelationID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(correlationIDHeader)
+		if id == "" {
+			id = middleware.GetReqID(r.Context())
 		}
-	} else {
-		// Fall back to Query protocol (form-encoded)
+		w.Header().Set(correlationIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), correlationIDKey, id)))
+	})
+}
+
+// getCorrelationID returns the correlation ID of the request, for logging
+func getCorrelationID(r *http.Request) string {
+	id, _ := r.Context().Value(correlationIDKey).(string)
+	return id
+}
+
+// requestJSON is the decoded body of a JSON protocol request, or the error
+// decoding it
+type requestJSON struct {
+	body map[string]interface{}
+	err  error
+}
+
+// decodeRequestJSON reads the body of a JSON protocol request once and keeps
+// the decoded parameters in the request context, where parseRequestJSON and
+// getRequestParam find them. Handlers can then look up parameters as often as
+// they like without finding the body already consumed. It must run after
+// verifySignature, which needs the raw body.
+func decodeRequestJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("X-Amz-Target") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var decoded requestJSON
+		decoded.body, decoded.err = readRequestJSON(r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestJSONKey, decoded)))
+	})
+}
+
+// corsOriginAllowed reports whether origin is listed in
+// admin.cors.allowed_origins, or any origin is allowed with "*"
+func corsOriginAllowed(origin string) bool {
+	for _, o := range activeConfig.Admin.CORS.AllowedOrigins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// adminCORS adds CORS headers for origins listed in admin.cors.allowed_origins
+// and answers preflight requests with 204 No Content
+func adminCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cors := activeConfig.Admin.CORS
+		origin := r.Header.Get("Origin")
+
+		if origin != "" && corsOriginAllowed(origin) {
+			methods, headers := cors.AllowedMethods, cors.AllowedHeaders
+			if len(methods) == 0 {
+				methods = []string{http.MethodGet, http.MethodPost, http.MethodDelete}
+			}
+			if len(headers) == 0 {
+				headers = []string{"Content-Type"}
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SQS API Handler
+func sqsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Amz-Target") == "" {
 		if err := r.ParseForm(); err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
 			return
 		}
-		action = r.FormValue("Action")
 	}
+	action := requestAction(r)
 
-	log.Printf("SQS Action: %s", action)
+	slog.Info("sqs action", "action", action, "correlation_id", getCorrelationID(r))
+
+	if injectFault(w, r, action) {
+		return
+	}
 
 	switch action {
 	case "CreateQueue":
@@ -58,14 +163,26 @@ func sqsHandler(w http.ResponseWriter, r *http.Request) {
 		handleDeleteQueue(w, r)
 	case "ListQueues":
 		handleListQueues(w, r)
+	case "GetQueueUrl":
+		handleGetQueueUrl(w, r)
 	case "SendMessage":
 		handleSendMessage(w, r)
 	case "ReceiveMessage":
 		handleReceiveMessage(w, r)
 	case "DeleteMessage":
 		handleDeleteMessage(w, r)
+	case "ChangeMessageVisibility":
+		handleChangeMessageVisibility(w, r)
+	case "SendMessageBatch":
+		handleSendMessageBatch(w, r)
+	case "DeleteMessageBatch":
+		handleDeleteMessageBatch(w, r)
+	case "ChangeMessageVisibilityBatch":
+		handleChangeMessageVisibilityBatch(w, r)
 	case "GetQueueAttributes":
 		handleGetQueueAttributes(w, r)
+	case "SetQueueAttributes":
+		handleSetQueueAttributes(w, r)
 	case "PurgeQueue":
 		handlePurgeQueue(w, r)
 	case "StartMessageMoveTask":
@@ -74,26 +191,38 @@ func sqsHandler(w http.ResponseWriter, r *http.Request) {
 		handleListMessageMoveTasks(w, r)
 	case "CancelMessageMoveTask":
 		handleCancelMessageMoveTask(w, r)
+	case "AddPermission":
+		handleAddPermission(w, r)
+	case "RemovePermission":
+		handleRemovePermission(w, r)
 	default:
-		sendError(w, "InvalidAction", "Unknown action: "+action, http.StatusBadRequest)
+		sendError(w, r, "InvalidAction", "Unknown action: "+action, http.StatusBadRequest)
+	}
+}
+
+// requestAction returns the SQS action a request calls. AWS CLI/SDK can send
+// requests in two formats:
+// 1. Query protocol (form-encoded) - older style
+// 2. JSON protocol with X-Amz-Target header - newer AWS CLI default
+func requestAction(r *http.Request) string {
+	if target := r.Header.Get("X-Amz-Target"); target != "" {
+		// Extract action from target like "AmazonSQS.CreateQueue"
+		parts := strings.Split(target, ".")
+		if len(parts) == 2 {
+			return parts[1]
+		}
+		return ""
 	}
+	return getRequestParam(r, "Action")
 }
 
 // getRequestParam extracts a parameter from either JSON body or form data
 func getRequestParam(r *http.Request, paramName string) string {
 	// Check if this is a JSON request (X-Amz-Target header present)
 	if r.Header.Get("X-Amz-Target") != "" {
-		// Parse JSON body
-		var jsonBody map[string]interface{}
-		if err := json.NewDecoder(r.Body).Decode(&jsonBody); err == nil {
-			if val, ok := jsonBody[paramName]; ok {
-				if strVal, ok := val.(string); ok {
-					return strVal
-				}
-			}
-		}
-		// Reset body for potential re-reads
-		return ""
+		jsonBody, _ := parseRequestJSON(r)
+		strVal, _ := jsonBody[paramName].(string)
+		return strVal
 	}
 
 	// Fall back to form data
@@ -103,8 +232,19 @@ func getRequestParam(r *http.Request, paramName string) string {
 	return r.FormValue(paramName)
 }
 
-// parseRequestJSON parses the JSON body into a map
+// parseRequestJSON returns the parameters of a JSON protocol request, as
+// decoded by decodeRequestJSON. A request that didn't pass through it has its
+// body read here instead.
 func parseRequestJSON(r *http.Request) (map[string]interface{}, error) {
+	if decoded, ok := r.Context().Value(requestJSONKey).(requestJSON); ok {
+		return decoded.body, decoded.err
+	}
+	return readRequestJSON(r)
+}
+
+// readRequestJSON reads the JSON body into a map, leaving the body in place
+// for anything that reads it later
+func readRequestJSON(r *http.Request) (map[string]interface{}, error) {
 	var jsonBody map[string]interface{}
 
 	// Read body
@@ -132,7 +272,7 @@ func handleCreateQueue(w http.ResponseWriter, r *http.Request) {
 	if r.Header.Get("X-Amz-Target") != "" {
 		jsonBody, err := parseRequestJSON(r)
 		if err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
 			return
 		}
 
@@ -152,7 +292,7 @@ func handleCreateQueue(w http.ResponseWriter, r *http.Request) {
 	} else {
 		// Form-encoded request
 		if err := r.ParseForm(); err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
 			return
 		}
 		queueName = r.FormValue("QueueName")
@@ -160,13 +300,13 @@ func handleCreateQueue(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if queueName == "" {
-		sendError(w, "MissingParameter", "QueueName is required", http.StatusBadRequest)
+		sendError(w, r, "MissingParameter", "QueueName is required", http.StatusBadRequest)
 		return
 	}
 
-	queue, err := queueManager.CreateQueue(queueName, attributes)
+	queue, err := getQueueManager(r).CreateQueue(queueName, attributes)
 	if err != nil {
-		sendError(w, "InternalError", err.Error(), http.StatusInternalServerError)
+		sendError(w, r, "InvalidParameterValue", err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -191,14 +331,45 @@ func handleCreateQueue(w http.ResponseWriter, r *http.Request) {
 	sendResponse(w, r, resp, jsonResp)
 }
 
+func handleGetQueueUrl(w http.ResponseWriter, r *http.Request) {
+	queueName := getRequestParam(r, "QueueName")
+	if queueName == "" {
+		sendError(w, r, "MissingParameter", "QueueName is required", http.StatusBadRequest)
+		return
+	}
+
+	queue, exists := getQueueManager(r).GetQueue(queueName)
+	if !exists {
+		sendNonExistentQueue(w, r)
+		return
+	}
+
+	type GetQueueUrlResponse struct {
+		XMLName xml.Name `xml:"GetQueueUrlResponse"`
+		Result  struct {
+			QueueUrl string `xml:"QueueUrl"`
+		} `xml:"GetQueueUrlResult"`
+	}
+
+	type GetQueueUrlJSONResponse struct {
+		QueueUrl string `json:"QueueUrl"`
+	}
+
+	resp := GetQueueUrlResponse{}
+	resp.Result.QueueUrl = "http://" + r.Host + queue.URL
+
+	sendResponse(w, r, resp, GetQueueUrlJSONResponse{QueueUrl: resp.Result.QueueUrl})
+}
+
 func handleDeleteQueue(w http.ResponseWriter, r *http.Request) {
 	var queueURL string
+	isJSON := r.Header.Get("X-Amz-Target") != ""
 
 	// Check if this is a JSON request
-	if r.Header.Get("X-Amz-Target") != "" {
+	if isJSON {
 		jsonBody, err := parseRequestJSON(r)
 		if err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
 			return
 		}
 
@@ -208,58 +379,92 @@ func handleDeleteQueue(w http.ResponseWriter, r *http.Request) {
 	} else {
 		// Form-encoded request
 		if err := r.ParseForm(); err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
 			return
 		}
 		queueURL = r.FormValue("QueueUrl")
 	}
 
-	queueName := extractQueueName(queueURL)
+	queueName, err := extractQueueName(queueURL)
+	if err != nil {
+		sendQueueError(w, r, err)
+		return
+	}
+
+	if !getQueueManager(r).DeleteQueue(queueName) {
+		sendNonExistentQueue(w, r)
+		return
+	}
 
-	if queueManager.DeleteQueue(queueName) {
+	if isJSON {
+		sendJSONResponse(w, r, struct{}{})
+	} else {
 		type DeleteQueueResponse struct {
 			XMLName xml.Name `xml:"DeleteQueueResponse"`
 		}
-		sendXMLResponse(w, DeleteQueueResponse{})
-	} else {
-		sendError(w, "NonExistentQueue", "Queue does not exist", http.StatusBadRequest)
+		sendXMLResponse(w, r, DeleteQueueResponse{})
 	}
 }
 
 func handleListQueues(w http.ResponseWriter, r *http.Request) {
-	var prefix string
+	var prefix, nextToken string
+	var maxResults int
 
 	// Check if this is a JSON request
 	if r.Header.Get("X-Amz-Target") != "" {
 		jsonBody, err := parseRequestJSON(r)
 		if err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
 			return
 		}
 
 		if p, ok := jsonBody["QueueNamePrefix"].(string); ok {
 			prefix = p
 		}
+		if max, ok := jsonBody["MaxResults"].(float64); ok {
+			maxResults = int(max)
+		}
+		if token, ok := jsonBody["NextToken"].(string); ok {
+			nextToken = token
+		}
 	} else {
 		// Form-encoded request
 		if err := r.ParseForm(); err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
 			return
 		}
 		prefix = r.FormValue("QueueNamePrefix")
+		maxResults = parseIntDefault(r.FormValue("MaxResults"), 0)
+		nextToken = r.FormValue("NextToken")
+	}
+
+	if maxResults < 0 || maxResults > 1000 {
+		sendError(w, r, "InvalidParameterValue", "MaxResults must be between 1 and 1000", http.StatusBadRequest)
+		return
+	}
+
+	offset := 0
+	if nextToken != "" {
+		var err error
+		if offset, err = decodeListQueuesToken(nextToken); err != nil {
+			sendError(w, r, "InvalidParameterValue", "Invalid NextToken", http.StatusBadRequest)
+			return
+		}
 	}
 
-	urls := queueManager.ListQueues(prefix)
+	urls, nextOffset := getQueueManager(r).ListQueues(prefix, offset, maxResults)
 
 	type ListQueuesResponse struct {
 		XMLName xml.Name `xml:"ListQueuesResponse" json:"-"`
 		Result  struct {
 			QueueUrls []string `xml:"QueueUrl" json:"QueueUrls"`
+			NextToken string   `xml:"NextToken,omitempty" json:"-"`
 		} `xml:"ListQueuesResult" json:"-"`
 	}
 
 	type ListQueuesJSONResponse struct {
 		QueueUrls []string `json:"QueueUrls"`
+		NextToken string   `json:"NextToken,omitempty"`
 	}
 
 	resp := ListQueuesResponse{}
@@ -274,20 +479,49 @@ func handleListQueues(w http.ResponseWriter, r *http.Request) {
 		QueueUrls: fullUrls,
 	}
 
+	// Only hand out a NextToken when more results remain
+	if nextOffset > 0 {
+		resp.Result.NextToken = encodeListQueuesToken(nextOffset)
+		jsonResp.NextToken = resp.Result.NextToken
+	}
+
 	sendResponse(w, r, resp, jsonResp)
 }
 
+// encodeListQueuesToken wraps a ListQueues offset in an opaque pagination token
+func encodeListQueuesToken(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+// decodeListQueuesToken extracts the offset from a ListQueues pagination token
+func decodeListQueuesToken(token string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+	offsetStr, ok := strings.CutPrefix(string(data), "offset:")
+	if !ok {
+		return 0, fmt.Errorf("malformed token")
+	}
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("malformed token")
+	}
+	return offset, nil
+}
+
 func handleSendMessage(w http.ResponseWriter, r *http.Request) {
 	var queueURL, body string
 	var delaySeconds int
 	var attributes map[string]interface{}
+	var systemAttributes map[string]string
 	var deduplicationId, groupId string
 
 	// Check if this is a JSON request
 	if r.Header.Get("X-Amz-Target") != "" {
 		jsonBody, err := parseRequestJSON(r)
 		if err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
 			return
 		}
 
@@ -305,6 +539,14 @@ func handleSendMessage(w http.ResponseWriter, r *http.Request) {
 		} else {
 			attributes = make(map[string]interface{})
 		}
+		systemAttributes = make(map[string]string)
+		if attrs, ok := jsonBody["MessageSystemAttributes"].(map[string]interface{}); ok {
+			for name, v := range attrs {
+				if value, ok := v.(map[string]interface{}); ok {
+					systemAttributes[name], _ = value["StringValue"].(string)
+				}
+			}
+		}
 		// FIFO-specific parameters
 		if dedupId, ok := jsonBody["MessageDeduplicationId"].(string); ok {
 			deduplicationId = dedupId
@@ -315,53 +557,70 @@ func handleSendMessage(w http.ResponseWriter, r *http.Request) {
 	} else {
 		// Form-encoded request
 		if err := r.ParseForm(); err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
 			return
 		}
 		queueURL = r.FormValue("QueueUrl")
 		body = r.FormValue("MessageBody")
 		delaySeconds = parseIntDefault(r.FormValue("DelaySeconds"), 0)
 		attributes = parseMessageAttributes(r.Form)
+		systemAttributes = parseMessageSystemAttributes(r.Form)
 		deduplicationId = r.FormValue("MessageDeduplicationId")
 		groupId = r.FormValue("MessageGroupId")
 	}
 
-	queueName := extractQueueName(queueURL)
+	queue, ok := requestQueue(w, r, queueURL)
+	if !ok {
+		return
+	}
 
-	queue, exists := queueManager.GetQueue(queueName)
-	if !exists {
-		sendError(w, "NonExistentQueue", "Queue does not exist", http.StatusBadRequest)
+	if err := checkMessageSystemAttributes(systemAttributes); err != nil {
+		sendQueueError(w, r, err)
+		return
+	}
+	if err := checkFifoParameters(queue, deduplicationId, groupId); err != nil {
+		sendQueueError(w, r, err)
 		return
 	}
 
-	msg := queue.SendMessage(body, attributes, delaySeconds, deduplicationId, groupId)
+	msg, err := queue.SendMessage(body, attributes, systemAttributes, delaySeconds, deduplicationId, groupId)
+	if err != nil {
+		sendQueueError(w, r, err)
+		return
+	}
 
 	type SendMessageResponse struct {
 		XMLName xml.Name `xml:"SendMessageResponse" json:"-"`
 		Result  struct {
-			MD5OfMessageBody string `xml:"MD5OfMessageBody" json:"MD5OfMessageBody"`
-			MessageId        string `xml:"MessageId" json:"MessageId"`
-			SequenceNumber   string `xml:"SequenceNumber,omitempty" json:"SequenceNumber,omitempty"`
+			MD5OfMessageBody             string `xml:"MD5OfMessageBody" json:"MD5OfMessageBody"`
+			MD5OfMessageSystemAttributes string `xml:"MD5OfMessageSystemAttributes,omitempty" json:"MD5OfMessageSystemAttributes,omitempty"`
+			MessageId                    string `xml:"MessageId" json:"MessageId"`
+			SequenceNumber               string `xml:"SequenceNumber,omitempty" json:"SequenceNumber,omitempty"`
 		} `xml:"SendMessageResult" json:"-"`
 	}
 
 	type SendMessageJSONResponse struct {
-		MD5OfMessageBody string `json:"MD5OfMessageBody"`
-		MessageId        string `json:"MessageId"`
-		SequenceNumber   string `json:"SequenceNumber,omitempty"`
+		MD5OfMessageBody             string `json:"MD5OfMessageBody"`
+		MD5OfMessageSystemAttributes string `json:"MD5OfMessageSystemAttributes,omitempty"`
+		MessageId                    string `json:"MessageId"`
+		SequenceNumber               string `json:"SequenceNumber,omitempty"`
 	}
 
+	systemAttributesDigest := systemAttributesMD5(msg.MessageSystemAttributes)
+
 	resp := SendMessageResponse{}
 	resp.Result.MD5OfMessageBody = msg.MD5OfBody
+	resp.Result.MD5OfMessageSystemAttributes = systemAttributesDigest
 	resp.Result.MessageId = msg.MessageID
 	if msg.SequenceNumber != "" {
 		resp.Result.SequenceNumber = msg.SequenceNumber
 	}
 
 	jsonResp := SendMessageJSONResponse{
-		MD5OfMessageBody: msg.MD5OfBody,
-		MessageId:        msg.MessageID,
-		SequenceNumber:   msg.SequenceNumber,
+		MD5OfMessageBody:             msg.MD5OfBody,
+		MD5OfMessageSystemAttributes: systemAttributesDigest,
+		MessageId:                    msg.MessageID,
+		SequenceNumber:               msg.SequenceNumber,
 	}
 
 	sendResponse(w, r, resp, jsonResp)
@@ -371,12 +630,15 @@ func handleReceiveMessage(w http.ResponseWriter, r *http.Request) {
 	var queueURL string
 	var maxMessages, visibilityTimeout int
 	var visibilityTimeoutProvided bool
+	var autoDelete bool
+	var attributeNames []string
+	waitTimeSeconds := -1 // use the queue's ReceiveMessageWaitTime
 
 	// Check if this is a JSON request
 	if r.Header.Get("X-Amz-Target") != "" {
 		jsonBody, err := parseRequestJSON(r)
 		if err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
 			return
 		}
 
@@ -392,10 +654,20 @@ func handleReceiveMessage(w http.ResponseWriter, r *http.Request) {
 			visibilityTimeout = int(vis)
 			visibilityTimeoutProvided = true
 		}
+		if wait, ok := jsonBody["WaitTimeSeconds"].(float64); ok {
+			waitTimeSeconds = int(wait)
+		}
+		switch v := jsonBody["AutoDelete"].(type) {
+		case bool:
+			autoDelete = v
+		case string:
+			autoDelete = v == "true"
+		}
+		attributeNames = parseAttributeNames(r, jsonBody)
 	} else {
 		// Form-encoded request
 		if err := r.ParseForm(); err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
 			return
 		}
 		queueURL = r.FormValue("QueueUrl")
@@ -404,14 +676,24 @@ func handleReceiveMessage(w http.ResponseWriter, r *http.Request) {
 			visibilityTimeout = parseIntDefault(r.FormValue("VisibilityTimeout"), 0)
 			visibilityTimeoutProvided = true
 		}
+		waitTimeSeconds = parseIntDefault(r.FormValue("WaitTimeSeconds"), -1)
+		autoDelete = r.FormValue("AutoDelete") == "true"
+		attributeNames = parseAttributeNames(r, nil)
 	}
 
-	queueName := extractQueueName(queueURL)
-	waitTimeSeconds := parseIntDefault(r.FormValue("WaitTimeSeconds"), 0)
+	// AutoDelete is not part of the SQS API, so only honor it in lenient mode
+	if autoDelete && !activeConfig.Server.Lenient {
+		sendError(w, r, "InvalidParameterValue", "AutoDelete is an emulator extension and requires server.lenient to be enabled", http.StatusBadRequest)
+		return
+	}
 
-	queue, exists := queueManager.GetQueue(queueName)
-	if !exists {
-		sendError(w, "NonExistentQueue", "Queue does not exist", http.StatusBadRequest)
+	if waitTimeSeconds > 20 {
+		sendError(w, r, "InvalidParameterValue", "WaitTimeSeconds must be between 0 and 20 seconds", http.StatusBadRequest)
+		return
+	}
+
+	queue, ok := requestQueue(w, r, queueURL)
+	if !ok {
 		return
 	}
 
@@ -419,14 +701,36 @@ func handleReceiveMessage(w http.ResponseWriter, r *http.Request) {
 	if !visibilityTimeoutProvided {
 		visibilityTimeout = queue.VisibilityTimeout
 	}
+	if waitTimeSeconds < 0 {
+		waitTimeSeconds = queue.ReceiveMessageWaitTime
+	}
 
-	messages := queue.ReceiveMessages(maxMessages, visibilityTimeout, waitTimeSeconds)
+	messages, err := queue.LongPoll(r.Context(), waitTimeSeconds, func() ([]*Message, error) {
+		if autoDelete {
+			return queue.ReceiveAndDeleteMessages(maxMessages), nil
+		}
+		return queue.ReceiveMessages(maxMessages, visibilityTimeout)
+	})
+	if err != nil {
+		sendQueueError(w, r, err)
+		return
+	}
+	if len(messages) == 0 {
+		queue.RecordEmptyReceive()
+	}
+
+	type Attribute struct {
+		Name  string `xml:"Name"`
+		Value string `xml:"Value"`
+	}
 
 	type MessageElement struct {
-		MessageId     string `xml:"MessageId" json:"MessageId"`
-		ReceiptHandle string `xml:"ReceiptHandle" json:"ReceiptHandle"`
-		MD5OfBody     string `xml:"MD5OfBody" json:"MD5OfBody"`
-		Body          string `xml:"Body" json:"Body"`
+		MessageId     string            `xml:"MessageId" json:"MessageId"`
+		ReceiptHandle string            `xml:"ReceiptHandle" json:"ReceiptHandle"`
+		MD5OfBody     string            `xml:"MD5OfBody" json:"MD5OfBody"`
+		Body          string            `xml:"Body" json:"Body"`
+		AttributeList []Attribute       `xml:"Attribute" json:"-"`
+		Attributes    map[string]string `xml:"-" json:"Attributes,omitempty"`
 	}
 
 	type ReceiveMessageResponse struct {
@@ -434,14 +738,28 @@ func handleReceiveMessage(w http.ResponseWriter, r *http.Request) {
 		Messages []MessageElement `xml:"ReceiveMessageResult>Message" json:"Messages"`
 	}
 
+	queue.mu.RLock()
+	fifo := queue.FifoQueue
+	queue.mu.RUnlock()
+
 	resp := ReceiveMessageResponse{}
 	for _, msg := range messages {
-		resp.Messages = append(resp.Messages, MessageElement{
+		element := MessageElement{
 			MessageId:     msg.MessageID,
 			ReceiptHandle: msg.ReceiptHandle,
 			MD5OfBody:     msg.MD5OfBody,
-			Body:          msg.Body,
-		})
+			Body:          msg.bodyText(),
+		}
+		if attrs := selectMessageAttributes(msg, fifo, attributeNames); len(attrs) > 0 {
+			element.Attributes = attrs
+			for name, value := range attrs {
+				element.AttributeList = append(element.AttributeList, Attribute{Name: name, Value: value})
+			}
+			sort.Slice(element.AttributeList, func(i, j int) bool {
+				return element.AttributeList[i].Name < element.AttributeList[j].Name
+			})
+		}
+		resp.Messages = append(resp.Messages, element)
 	}
 
 	// Send JSON or XML based on request type
@@ -456,7 +774,7 @@ func handleDeleteMessage(w http.ResponseWriter, r *http.Request) {
 	if isJSON {
 		jsonBody, err := parseRequestJSON(r)
 		if err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
 			return
 		}
 
@@ -469,32 +787,88 @@ func handleDeleteMessage(w http.ResponseWriter, r *http.Request) {
 	} else {
 		// Form-encoded request
 		if err := r.ParseForm(); err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
 			return
 		}
 		queueURL = r.FormValue("QueueUrl")
 		receiptHandle = r.FormValue("ReceiptHandle")
 	}
 
-	queueName := extractQueueName(queueURL)
+	queue, ok := requestQueue(w, r, queueURL)
+	if !ok {
+		return
+	}
 
-	queue, exists := queueManager.GetQueue(queueName)
-	if !exists {
-		sendError(w, "NonExistentQueue", "Queue does not exist", http.StatusBadRequest)
+	if err := queue.DeleteMessage(receiptHandle); err != nil {
+		sendQueueError(w, r, err)
 		return
 	}
 
-	if queue.DeleteMessage(receiptHandle) {
-		if isJSON {
-			sendJSONResponse(w, struct{}{})
-		} else {
-			type DeleteMessageResponse struct {
-				XMLName xml.Name `xml:"DeleteMessageResponse"`
-			}
-			sendXMLResponse(w, DeleteMessageResponse{})
+	if isJSON {
+		sendJSONResponse(w, r, struct{}{})
+	} else {
+		type DeleteMessageResponse struct {
+			XMLName xml.Name `xml:"DeleteMessageResponse"`
+		}
+		sendXMLResponse(w, r, DeleteMessageResponse{})
+	}
+}
+
+func handleChangeMessageVisibility(w http.ResponseWriter, r *http.Request) {
+	var queueURL, receiptHandle string
+	visibilityTimeout := -1
+	isJSON := r.Header.Get("X-Amz-Target") != ""
+
+	if isJSON {
+		jsonBody, err := parseRequestJSON(r)
+		if err != nil {
+			sendError(w, r, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
+			return
 		}
+
+		if url, ok := jsonBody["QueueUrl"].(string); ok {
+			queueURL = url
+		}
+		if receipt, ok := jsonBody["ReceiptHandle"].(string); ok {
+			receiptHandle = receipt
+		}
+		if vis, ok := jsonBody["VisibilityTimeout"].(float64); ok {
+			visibilityTimeout = int(vis)
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			sendError(w, r, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
+			return
+		}
+		queueURL = r.FormValue("QueueUrl")
+		receiptHandle = r.FormValue("ReceiptHandle")
+		if r.FormValue("VisibilityTimeout") != "" {
+			visibilityTimeout = parseIntDefault(r.FormValue("VisibilityTimeout"), -1)
+		}
+	}
+
+	if visibilityTimeout < 0 || visibilityTimeout > 43200 {
+		sendError(w, r, "InvalidParameterValue", "VisibilityTimeout must be between 0 and 43200 seconds", http.StatusBadRequest)
+		return
+	}
+
+	queue, ok := requestQueue(w, r, queueURL)
+	if !ok {
+		return
+	}
+
+	if err := queue.ChangeMessageVisibility(receiptHandle, visibilityTimeout); err != nil {
+		sendQueueError(w, r, err)
+		return
+	}
+
+	if isJSON {
+		sendJSONResponse(w, r, struct{}{})
 	} else {
-		sendError(w, "ReceiptHandleIsInvalid", "Invalid receipt handle", http.StatusBadRequest)
+		type ChangeMessageVisibilityResponse struct {
+			XMLName xml.Name `xml:"ChangeMessageVisibilityResponse"`
+		}
+		sendXMLResponse(w, r, ChangeMessageVisibilityResponse{})
 	}
 }
 
@@ -506,7 +880,7 @@ func handleGetQueueAttributes(w http.ResponseWriter, r *http.Request) {
 	if isJSON {
 		jsonBody, err := parseRequestJSON(r)
 		if err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
 			return
 		}
 
@@ -516,17 +890,14 @@ func handleGetQueueAttributes(w http.ResponseWriter, r *http.Request) {
 	} else {
 		// Form-encoded request
 		if err := r.ParseForm(); err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
 			return
 		}
 		queueURL = r.FormValue("QueueUrl")
 	}
 
-	queueName := extractQueueName(queueURL)
-
-	queue, exists := queueManager.GetQueue(queueName)
-	if !exists {
-		sendError(w, "NonExistentQueue", "Queue does not exist", http.StatusBadRequest)
+	queue, ok := requestQueue(w, r, queueURL)
+	if !ok {
 		return
 	}
 
@@ -540,7 +911,7 @@ func handleGetQueueAttributes(w http.ResponseWriter, r *http.Request) {
 		resp := GetQueueAttributesJSONResponse{
 			Attributes: attrs,
 		}
-		sendJSONResponse(w, resp)
+		sendJSONResponse(w, r, resp)
 	} else {
 		// XML response for Query protocol
 		type Attribute struct {
@@ -563,57 +934,155 @@ func handleGetQueueAttributes(w http.ResponseWriter, r *http.Request) {
 			})
 		}
 
-		sendXMLResponse(w, resp)
+		sendXMLResponse(w, r, resp)
 	}
 }
 
-func handlePurgeQueue(w http.ResponseWriter, r *http.Request) {
+func handleSetQueueAttributes(w http.ResponseWriter, r *http.Request) {
 	var queueURL string
+	var attributes map[string]string
 
 	// Check if this is a JSON request
 	if r.Header.Get("X-Amz-Target") != "" {
 		jsonBody, err := parseRequestJSON(r)
 		if err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
 			return
 		}
 
 		if url, ok := jsonBody["QueueUrl"].(string); ok {
 			queueURL = url
 		}
+		attributes = make(map[string]string)
+		if attrs, ok := jsonBody["Attributes"].(map[string]interface{}); ok {
+			for k, v := range attrs {
+				if strVal, ok := v.(string); ok {
+					attributes[k] = strVal
+				}
+			}
+		}
 	} else {
 		// Form-encoded request
 		if err := r.ParseForm(); err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
 			return
 		}
 		queueURL = r.FormValue("QueueUrl")
+		attributes = parseAttributes(r.Form, "Attribute")
 	}
 
-	queueName := extractQueueName(queueURL)
+	queue, ok := requestQueue(w, r, queueURL)
+	if !ok {
+		return
+	}
 
-	queue, exists := queueManager.GetQueue(queueName)
-	if !exists {
-		sendError(w, "NonExistentQueue", "Queue does not exist", http.StatusBadRequest)
+	if err := queue.SetAttributes(attributes); err != nil {
+		sendQueueError(w, r, err)
+		return
+	}
+
+	type SetQueueAttributesResponse struct {
+		XMLName xml.Name `xml:"SetQueueAttributesResponse"`
+	}
+	sendResponse(w, r, SetQueueAttributesResponse{}, struct{}{})
+}
+
+func handlePurgeQueue(w http.ResponseWriter, r *http.Request) {
+	var queueURL string
+	isJSON := r.Header.Get("X-Amz-Target") != ""
+
+	// Check if this is a JSON request
+	if isJSON {
+		jsonBody, err := parseRequestJSON(r)
+		if err != nil {
+			sendError(w, r, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
+			return
+		}
+
+		if url, ok := jsonBody["QueueUrl"].(string); ok {
+			queueURL = url
+		}
+	} else {
+		// Form-encoded request
+		if err := r.ParseForm(); err != nil {
+			sendError(w, r, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
+			return
+		}
+		queueURL = r.FormValue("QueueUrl")
+	}
+
+	queue, ok := requestQueue(w, r, queueURL)
+	if !ok {
 		return
 	}
 
-	queue.PurgeQueue()
+	if err := queue.PurgeQueue(); err != nil {
+		sendQueueError(w, r, err)
+		return
+	}
 
-	type PurgeQueueResponse struct {
-		XMLName xml.Name `xml:"PurgeQueueResponse"`
+	if isJSON {
+		sendJSONResponse(w, r, struct{}{})
+	} else {
+		type PurgeQueueResponse struct {
+			XMLName xml.Name `xml:"PurgeQueueResponse"`
+		}
+		sendXMLResponse(w, r, PurgeQueueResponse{})
 	}
-	sendXMLResponse(w, PurgeQueueResponse{})
 }
 
 // Helper functions
 
-func extractQueueName(queueURL string) string {
-	parsedURL, err := url.Parse(queueURL)
+// extractQueueName returns the queue name from a queue URL, which is the last
+// non-empty path segment. That covers the account-prefixed URLs the server
+// hands out (/000000000000/name, or /acct1/000000000000/name in an instance)
+// as well as bare /name URLs and URLs with a trailing slash, and also the
+// forms some SDKs send instead of a full URL: a path, a URL without a scheme
+// or just the queue name. A value that names no queue at all is an
+// InvalidParameterValue *QueueError.
+func extractQueueName(queueURL string) (string, error) {
+	invalid := func(reason string) error {
+		return &QueueError{"InvalidParameterValue",
+			"Value " + queueURL + " for parameter QueueUrl is invalid. Reason: " + reason}
+	}
+
+	queuePath := strings.TrimSpace(queueURL)
+	if queuePath == "" {
+		return "", &QueueError{"InvalidParameterValue", "The parameter QueueUrl must be the URL or name of a queue."}
+	}
+	parsedURL, err := url.Parse(queuePath)
+	if err != nil {
+		return "", invalid("Unable to parse the URL.")
+	}
+	queuePath = parsedURL.Path
+	if parsedURL.Opaque != "" {
+		// host:port/account/name without a scheme parses as an opaque URL
+		// with the host as its scheme
+		queuePath = parsedURL.Opaque
+	}
+	queuePath = strings.TrimRight(queuePath, "/")
+	name := queuePath[strings.LastIndex(queuePath, "/")+1:]
+	if name == "" {
+		return "", invalid("The URL has no queue name.")
+	}
+	return name, nil
+}
+
+// requestQueue looks up the queue a request's QueueUrl refers to. If there is
+// none it sends InvalidParameterValue when the URL names no queue at all, or
+// NonExistentQueue when the named queue doesn't exist, and returns false.
+func requestQueue(w http.ResponseWriter, r *http.Request, queueURL string) (*Queue, bool) {
+	queueName, err := extractQueueName(queueURL)
 	if err != nil {
-		return strings.TrimPrefix(queueURL, "/")
+		sendQueueError(w, r, err)
+		return nil, false
+	}
+	queue, exists := getQueueManager(r).GetQueue(queueName)
+	if !exists {
+		sendNonExistentQueue(w, r)
+		return nil, false
 	}
-	return strings.TrimPrefix(parsedURL.Path, "/")
+	return queue, true
 }
 
 func parseAttributes(form url.Values, prefix string) map[string]string {
@@ -635,14 +1104,138 @@ func parseAttributes(form url.Values, prefix string) map[string]string {
 	return attrs
 }
 
+// parseMessageAttributes parses MessageAttribute.N.Name and its
+// Value.DataType, Value.StringValue and Value.BinaryValue from a Query
+// request, into the same shape the JSON protocol uses
 func parseMessageAttributes(form url.Values) map[string]interface{} {
-	// Simplified - should properly parse MessageAttribute.N.Name/Value/DataType
-	return make(map[string]interface{})
-}
-
-func parseIntDefault(s string, defaultVal int) int {
-	if s == "" {
-		return defaultVal
+	attrs := make(map[string]interface{})
+	for i := 1; ; i++ {
+		prefix := "MessageAttribute." + strconv.Itoa(i)
+		name := form.Get(prefix + ".Name")
+		if name == "" {
+			break
+		}
+		value := map[string]interface{}{"DataType": form.Get(prefix + ".Value.DataType")}
+		if s, ok := form[prefix+".Value.StringValue"]; ok {
+			value["StringValue"] = s[0]
+		}
+		if b, ok := form[prefix+".Value.BinaryValue"]; ok {
+			value["BinaryValue"] = b[0]
+		}
+		attrs[name] = value
+	}
+	return attrs
+}
+
+// parseMessageSystemAttributes parses MessageSystemAttribute.N.Name and
+// MessageSystemAttribute.N.Value.StringValue pairs from a Query request
+func parseMessageSystemAttributes(form url.Values) map[string]string {
+	attrs := make(map[string]string)
+	for i := 1; ; i++ {
+		prefix := "MessageSystemAttribute." + strconv.Itoa(i)
+		name := form.Get(prefix + ".Name")
+		if name == "" {
+			break
+		}
+		attrs[name] = form.Get(prefix + ".Value.StringValue")
+	}
+	return attrs
+}
+
+// checkMessageSystemAttributes rejects any message system attribute other
+// than AWSTraceHeader, the only one a sender may set
+func checkMessageSystemAttributes(attrs map[string]string) error {
+	for name := range attrs {
+		if name != "AWSTraceHeader" {
+			return &QueueError{"InvalidParameterValue", "Message system attribute " + name + " is not supported; only AWSTraceHeader is"}
+		}
+	}
+	return nil
+}
+
+// checkFifoParameters rejects MessageDeduplicationId and MessageGroupId on a
+// standard queue, since only FIFO queues take them
+func checkFifoParameters(queue *Queue, deduplicationId, groupId string) error {
+	queue.mu.RLock()
+	fifo := queue.FifoQueue
+	queue.mu.RUnlock()
+	if !fifo && (deduplicationId != "" || groupId != "") {
+		return &QueueError{"InvalidParameterValue", "The request includes parameters that aren't valid for this queue type"}
+	}
+	return nil
+}
+
+// parseAttributeNames returns the attribute names a ReceiveMessage request
+// asks for, from both AttributeNames and MessageSystemAttributeNames
+func parseAttributeNames(r *http.Request, jsonBody map[string]interface{}) []string {
+	var names []string
+	if jsonBody != nil {
+		for _, key := range []string{"AttributeNames", "MessageSystemAttributeNames"} {
+			if list, ok := jsonBody[key].([]interface{}); ok {
+				for _, v := range list {
+					if name, ok := v.(string); ok {
+						names = append(names, name)
+					}
+				}
+			}
+		}
+		return names
+	}
+
+	for _, prefix := range []string{"AttributeName", "MessageSystemAttributeName"} {
+		for i := 1; ; i++ {
+			name := r.Form.Get(prefix + "." + strconv.Itoa(i))
+			if name == "" {
+				break
+			}
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// selectMessageAttributes returns the message's system attributes that were
+// requested by name, or all of them if "All" was requested. Messages from
+// FIFO queues also carry their sequence number, group ID and deduplication ID.
+func selectMessageAttributes(msg *Message, fifo bool, names []string) map[string]string {
+	available := map[string]string{
+		"ApproximateReceiveCount": strconv.Itoa(msg.ReceiveCount),
+		"SentTimestamp":           strconv.FormatInt(msg.SentTimestamp.UnixMilli(), 10),
+		"SenderId":                activeConfig.Server.AccountID,
+	}
+	if !msg.FirstReceivedTime.IsZero() {
+		available["ApproximateFirstReceiveTimestamp"] = strconv.FormatInt(msg.FirstReceivedTime.UnixMilli(), 10)
+	}
+	for name, value := range msg.MessageSystemAttributes {
+		available[name] = value
+	}
+	if fifo {
+		available["SequenceNumber"] = msg.SequenceNumber
+		available["MessageGroupId"] = msg.MessageGroupId
+		available["MessageDeduplicationId"] = msg.MessageDeduplicationId
+	}
+
+	selected := make(map[string]string)
+	for _, name := range names {
+		if name == "All" {
+			return available
+		}
+		if value, ok := available[name]; ok {
+			selected[name] = value
+		}
+	}
+	return selected
+}
+
+// yamlQuote renders s as a single-quoted YAML scalar so values such as JSON
+// policies survive a round trip through LoadConfig unchanged
+func yamlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func parseIntDefault(s string, defaultVal int) int {
+	if s == "" {
+		return defaultVal
 	}
 	val, err := strconv.Atoi(s)
 	if err != nil {
@@ -651,36 +1244,170 @@ func parseIntDefault(s string, defaultVal int) int {
 	return val
 }
 
-func sendXMLResponse(w http.ResponseWriter, v interface{}) {
-	w.Header().Set("Content-Type", "text/xml")
-	w.WriteHeader(http.StatusOK)
+// ResponseMetadata is included in every SQS response body
+type ResponseMetadata struct {
+	RequestId string `xml:"RequestId" json:"RequestId"`
+}
+
+// awsRequestID gives every request an ID, reusing one sent by the client in
+// X-Request-Id or generating a UUID, and returns it in the x-amzn-RequestId
+// header. It runs ahead of chi's RequestID middleware so both share the value.
+func awsRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(middleware.RequestIDHeader)
+		if requestID == "" {
+			requestID = uuid.New().String()
+			r.Header.Set(middleware.RequestIDHeader, requestID)
+		}
+		w.Header().Set("x-amzn-RequestId", requestID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// defaultAPIVersion is the SQS API version named in the namespace of XML
+// responses when neither the config nor the request gives one
+const defaultAPIVersion = "2012-11-05"
+
+// xmlContentType is the content type of XML responses. SQS sends plain
+// text/xml, but without a charset many HTTP clients decode text/* as
+// ISO-8859-1, which garbles multibyte bodies so they no longer match their
+// MD5OfBody.
+const xmlContentType = "text/xml; charset=utf-8"
+
+// xmlNamespace returns the namespace of XML responses to the request. Like
+// SQS, it names the API version the request asked for, unless the config pins
+// the version.
+func xmlNamespace(r *http.Request) string {
+	version := activeConfig.Server.APIVersion
+	if version == "" {
+		version = r.Form.Get("Version")
+		if !validAPIVersion(version) {
+			version = defaultAPIVersion
+		}
+	}
+	return "http://queue.amazonaws.com/doc/" + version + "/"
+}
+
+// withXMLNamespace adds an xmlns attribute to the root element of an encoded
+// response, which never has attributes of its own
+func withXMLNamespace(body []byte, namespace string) []byte {
+	end := bytes.IndexByte(body, '>')
+	if end < 0 {
+		return body
+	}
+	var out bytes.Buffer
+	out.Write(body[:end])
+	out.WriteString(` xmlns="`)
+	xml.EscapeText(&out, []byte(namespace))
+	out.WriteByte('"')
+	out.Write(body[end:])
+	return out.Bytes()
+}
 
-	encoder := xml.NewEncoder(w)
+func sendXMLResponse(w http.ResponseWriter, r *http.Request, v interface{}) {
+	var buf bytes.Buffer
+	encoder := xml.NewEncoder(&buf)
 	encoder.Indent("", "  ")
 	if err := encoder.Encode(v); err != nil {
-		log.Printf("Error encoding XML: %v", err)
+		slog.Error("failed to encode XML response", "error", err)
+	}
+
+	// Append the ResponseMetadata element inside the response's root element
+	metadata, _ := xml.MarshalIndent(ResponseMetadata{RequestId: middleware.GetReqID(r.Context())}, "  ", "  ")
+	body := withXMLNamespace(buf.Bytes(), xmlNamespace(r))
+	if end := bytes.LastIndex(body, []byte("</")); end > 0 {
+		var out bytes.Buffer
+		out.Write(body[:end])
+		if body[end-1] != '\n' {
+			out.WriteByte('\n')
+		}
+		out.Write(metadata)
+		out.WriteByte('\n')
+		out.Write(body[end:])
+		body = out.Bytes()
+	}
+
+	w.Header().Set("Content-Type", xmlContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// jsonContentTypes are the content types a JSON protocol request may use
+var jsonContentTypes = map[string]bool{
+	"application/x-amz-json-1.0": true,
+	"application/x-amz-json-1.1": true,
+	"application/json":           true,
+}
+
+// jsonContentType returns the content type for a JSON protocol response. It
+// echoes the request's, so a client that sent JSON 1.1 or plain JSON gets
+// back what it expects, and falls back to application/x-amz-json-1.0.
+func jsonContentType(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && jsonContentTypes[mediaType] {
+		return mediaType
 	}
+	return "application/x-amz-json-1.0"
 }
 
-func sendJSONResponse(w http.ResponseWriter, v interface{}) {
-	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+func sendJSONResponse(w http.ResponseWriter, r *http.Request, v interface{}) {
+	// Add ResponseMetadata alongside the response fields
+	var resp map[string]interface{}
+	if data, err := json.Marshal(v); err == nil {
+		json.Unmarshal(data, &resp)
+	}
+	if resp == nil {
+		resp = make(map[string]interface{})
+	}
+	resp["ResponseMetadata"] = ResponseMetadata{RequestId: middleware.GetReqID(r.Context())}
+
+	w.Header().Set("Content-Type", jsonContentType(r))
 	w.WriteHeader(http.StatusOK)
 
-	if err := json.NewEncoder(w).Encode(v); err != nil {
-		log.Printf("Error encoding JSON: %v", err)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("failed to encode JSON response", "error", err)
 	}
 }
 
 func sendResponse(w http.ResponseWriter, r *http.Request, xmlData interface{}, jsonData interface{}) {
 	// If X-Amz-Target header is present, send JSON response
 	if r.Header.Get("X-Amz-Target") != "" {
-		sendJSONResponse(w, jsonData)
+		sendJSONResponse(w, r, jsonData)
 	} else {
-		sendXMLResponse(w, xmlData)
+		sendXMLResponse(w, r, xmlData)
 	}
 }
 
-func sendError(w http.ResponseWriter, code string, message string, status int) {
+// codeNonExistentQueue is the error code SQS returns for a queue that
+// doesn't exist
+const codeNonExistentQueue = "AWS.SimpleQueueService.NonExistentQueue"
+
+// jsonErrorTypes maps error codes to the name the JSON protocol reports in
+// __type, for the codes where the two differ
+var jsonErrorTypes = map[string]string{
+	codeNonExistentQueue: "QueueDoesNotExist",
+	"RequestThrottled":   "ThrottlingException",
+}
+
+// sendError reports an error in the request's protocol. JSON errors carry
+// the query protocol code in the x-amzn-query-error header, as SQS sends it
+// for SDKs that match on those codes.
+func sendError(w http.ResponseWriter, r *http.Request, code string, message string, status int) {
+	if r.Header.Get("X-Amz-Target") != "" {
+		errorType := code
+		if t, ok := jsonErrorTypes[code]; ok {
+			errorType = t
+		}
+		w.Header().Set("Content-Type", jsonContentType(r))
+		w.Header().Set("x-amzn-query-error", code+";Sender")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"__type":  "com.amazonaws.sqs#" + errorType,
+			"message": message,
+		})
+		return
+	}
+
 	type ErrorResponse struct {
 		XMLName xml.Name `xml:"ErrorResponse"`
 		Error   struct {
@@ -688,19 +1415,40 @@ func sendError(w http.ResponseWriter, code string, message string, status int) {
 			Code    string `xml:"Code"`
 			Message string `xml:"Message"`
 		} `xml:"Error"`
+		RequestId string `xml:"RequestId"`
 	}
 
 	resp := ErrorResponse{}
 	resp.Error.Type = "Sender"
 	resp.Error.Code = code
 	resp.Error.Message = message
+	resp.RequestId = middleware.GetReqID(r.Context())
 
-	w.Header().Set("Content-Type", "text/xml")
-	w.WriteHeader(status)
-
-	encoder := xml.NewEncoder(w)
+	var buf bytes.Buffer
+	encoder := xml.NewEncoder(&buf)
 	encoder.Indent("", "  ")
 	encoder.Encode(resp)
+
+	w.Header().Set("Content-Type", xmlContentType)
+	w.WriteHeader(status)
+	w.Write(withXMLNamespace(buf.Bytes(), xmlNamespace(r)))
+}
+
+// sendNonExistentQueue reports that the queue named by the request doesn't
+// exist
+func sendNonExistentQueue(w http.ResponseWriter, r *http.Request) {
+	sendError(w, r, codeNonExistentQueue, "The specified queue does not exist.", http.StatusBadRequest)
+}
+
+// sendQueueError reports an error returned by a Queue method, using its SQS
+// error code when it has one
+func sendQueueError(w http.ResponseWriter, r *http.Request, err error) {
+	var queueErr *QueueError
+	if errors.As(err, &queueErr) {
+		sendError(w, r, queueErr.Code, queueErr.Message, http.StatusBadRequest)
+		return
+	}
+	sendError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
 }
 
 // Health check handler
@@ -709,6 +1457,51 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
 }
 
+// readiness is set once the configuration is loaded and its queues are
+// bootstrapped, for /readyz
+var readiness struct {
+	ready  atomic.Bool
+	queues atomic.Int64 // queues bootstrapped across all instances
+	since  atomic.Int64 // when startup finished, in Unix nanoseconds
+}
+
+// markReady records that startup has finished with the given number of queues
+func markReady(queues int) {
+	readiness.queues.Store(int64(queues))
+	readiness.since.Store(time.Now().UnixNano())
+	readiness.ready.Store(true)
+}
+
+// startupGraceRemaining returns how much of server.startup_grace_period is
+// left, or 0 once it is over or before startup has finished
+func startupGraceRemaining() time.Duration {
+	if !readiness.ready.Load() {
+		return 0
+	}
+	grace := time.Duration(activeConfig.Server.StartupGracePeriod) * time.Second
+	return max(grace-time.Since(time.Unix(0, readiness.since.Load())), 0)
+}
+
+// livezHandler reports that the process is up
+func livezHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "alive"})
+}
+
+// readyzHandler reports whether startup has finished, answering 503 until it has
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !readiness.ready.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "starting"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ready",
+		"queues": readiness.queues.Load(),
+	})
+}
+
 // Root handler
 func rootHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" {
@@ -742,72 +1535,122 @@ type QueueDetails struct {
 	VisibleCount              int                 `json:"visible_count"`
 	NotVisibleCount           int                 `json:"not_visible_count"`
 	DelayedCount              int                 `json:"delayed_count"`
+	AgeOfOldestMessage        int                 `json:"age_of_oldest_message"` // seconds, visible messages only
 	Messages                  []MessageDetails    `json:"messages"`
 	FifoQueue                 bool                `json:"fifo_queue"`
 	ContentBasedDeduplication bool                `json:"content_based_deduplication,omitempty"`
 	RedrivePolicy             *RedrivePolicy      `json:"redrive_policy,omitempty"`
 	RedriveAllowPolicy        *RedriveAllowPolicy `json:"redrive_allow_policy,omitempty"`
+	LongPollWaiters           int                 `json:"long_poll_waiters"`
+	MaxLongPollWaiters        int                 `json:"max_long_poll_waiters,omitempty"`
+	DeduplicationWindow       int                 `json:"deduplication_window_seconds,omitempty"` // FIFO queues only
+	MaxMessages               int                 `json:"max_messages,omitempty"`                 // sends fail once message_count reaches it
+	StrictOrdering            bool                `json:"strict_ordering,omitempty"`              // receives return the earliest-sent messages first
+	SentCount                 int64               `json:"sent_count"`
+	ReceivedCount             int64               `json:"received_count"`
+	DeletedCount              int64               `json:"deleted_count"`
+	DLQMovedCount             int64               `json:"dlq_moved_count"`
+	EmptyReceiveCount         int64               `json:"empty_receive_count"`             // ReceiveMessage calls that returned nothing
+	RedriveSourceQueues       []string            `json:"redrive_source_queues,omitempty"` // queues using this one as their DLQ
 }
 
 type MessageDetails struct {
-	MessageID              string    `json:"message_id"`
-	Body                   string    `json:"body"`
-	MD5OfBody              string    `json:"md5_of_body"`
-	SentTimestamp          time.Time `json:"sent_timestamp"`
-	ReceiveCount           int       `json:"receive_count"`
-	ReceiptHandle          string    `json:"receipt_handle,omitempty"`
-	SequenceNumber         string    `json:"sequence_number,omitempty"`
-	MessageGroupId         string    `json:"message_group_id,omitempty"`
-	MessageDeduplicationId string    `json:"message_deduplication_id,omitempty"`
+	MessageID              string                 `json:"message_id"`
+	State                  string                 `json:"state"` // visible, in_flight or delayed
+	DelayUntil             time.Time              `json:"delay_until"`
+	VisibleAt              *time.Time             `json:"visible_at,omitempty"` // end of the visibility timeout, once received
+	Body                   string                 `json:"body"`
+	MD5OfBody              string                 `json:"md5_of_body"`
+	SentTimestamp          time.Time              `json:"sent_timestamp"`
+	ReceiveCount           int                    `json:"receive_count"`
+	ReceiptHandle          string                 `json:"receipt_handle,omitempty"`
+	SequenceNumber         string                 `json:"sequence_number,omitempty"`
+	MessageGroupId         string                 `json:"message_group_id,omitempty"`
+	MessageDeduplicationId string                 `json:"message_deduplication_id,omitempty"`
+	DeadLetterSourceArn    string                 `json:"dead_letter_source_arn,omitempty"` // queue the message was moved from, in a DLQ
+	PendingDLQ             bool                   `json:"pending_dlq"`                      // used up the redrive policy's receives; moves to the DLQ once visible
+	MessageAttributes      map[string]interface{} `json:"message_attributes,omitempty"`
+	History                []DeliveryEvent        `json:"history,omitempty"`
+	CompressedSize         int                    `json:"compressed_size,omitempty"` // bytes the body takes in memory, when stored compressed
+}
+
+// newMessageDetails describes a message returned by PeekMessages for the
+// admin API. redrivePolicy is its queue's, if any.
+func newMessageDetails(msg *Message, redrivePolicy *RedrivePolicy, now time.Time) MessageDetails {
+	var visibleAt *time.Time
+	if !msg.VisibilityTimeout.IsZero() {
+		t := msg.VisibilityTimeout
+		visibleAt = &t
+	}
+	return MessageDetails{
+		MessageID:              msg.MessageID,
+		State:                  msg.state(now),
+		DelayUntil:             msg.DelayUntil,
+		VisibleAt:              visibleAt,
+		Body:                   msg.bodyText(),
+		MD5OfBody:              msg.MD5OfBody,
+		SentTimestamp:          msg.SentTimestamp,
+		ReceiveCount:           msg.ReceiveCount,
+		ReceiptHandle:          msg.ReceiptHandle,
+		SequenceNumber:         msg.SequenceNumber,
+		MessageGroupId:         msg.MessageGroupId,
+		MessageDeduplicationId: msg.MessageDeduplicationId,
+		DeadLetterSourceArn:    msg.MessageSystemAttributes["DeadLetterQueueSourceArn"],
+		PendingDLQ:             redrivePolicy != nil && msg.ReceiveCount >= redrivePolicy.MaxReceiveCount,
+		MessageAttributes:      msg.MessageAttributes,
+		History:                msg.History,
+		CompressedSize:         len(msg.compressedBody),
+	}
 }
 
 func adminAPIHandler(w http.ResponseWriter, r *http.Request) {
-	queues := queueManager.GetAllQueues()
+	queues := getQueueManager(r).GetAllQueues()
 
 	queueDetails := make([]QueueDetails, 0, len(queues))
 	for _, queue := range queues {
-		queue.mu.RLock()
-
-		now := time.Now()
-		visibleCount := 0
-		notVisibleCount := 0
-		delayedCount := 0
-
-		messages := make([]MessageDetails, 0, len(queue.Messages))
-		for _, msg := range queue.Messages {
-			if now.Before(msg.DelayUntil) {
-				delayedCount++
-			} else if now.Before(msg.VisibilityTimeout) {
-				notVisibleCount++
-			} else {
-				visibleCount++
-			}
+		// Takes the other queues' locks, so it can't be called under this one's
+		redriveSources := getQueueManager(r).DeadLetterSources(queue.Name)
 
-			messages = append(messages, MessageDetails{
-				MessageID:              msg.MessageID,
-				Body:                   msg.Body,
-				MD5OfBody:              msg.MD5OfBody,
-				SentTimestamp:          msg.SentTimestamp,
-				ReceiveCount:           msg.ReceiveCount,
-				ReceiptHandle:          msg.ReceiptHandle,
-				SequenceNumber:         msg.SequenceNumber,
-				MessageGroupId:         msg.MessageGroupId,
-				MessageDeduplicationId: msg.MessageDeduplicationId,
-			})
+		// The counts, the age and the listed messages all come from one
+		// locked pass at one time, so they agree with each other
+		queue.mu.RLock()
+		now := queue.now()
+		counts := queue.countsLocked(now)
+		redrivePolicy := queue.redrivePolicyLocked()
+		peeked := queue.peekMessagesLocked(0)
+		messages := make([]MessageDetails, 0, len(peeked))
+		for i := range peeked {
+			messages = append(messages, newMessageDetails(&peeked[i], redrivePolicy, now))
 		}
 
+		var deduplicationWindow int
+		if queue.FifoQueue {
+			deduplicationWindow = int(queue.deduplicationWindow() / time.Second)
+		}
 		queueDetails = append(queueDetails, QueueDetails{
 			Name:                      queue.Name,
 			URL:                       queue.URL,
-			MessageCount:              len(queue.Messages),
-			VisibleCount:              visibleCount,
-			NotVisibleCount:           notVisibleCount,
-			DelayedCount:              delayedCount,
+			MessageCount:              counts.Visible + counts.NotVisible + counts.Delayed,
+			VisibleCount:              counts.Visible,
+			NotVisibleCount:           counts.NotVisible,
+			DelayedCount:              counts.Delayed,
+			AgeOfOldestMessage:        queue.oldestVisibleAgeLocked(now),
 			Messages:                  messages,
 			FifoQueue:                 queue.FifoQueue,
 			ContentBasedDeduplication: queue.ContentBasedDeduplication,
-			RedrivePolicy:             queue.RedrivePolicy,
+			RedrivePolicy:             redrivePolicy,
 			RedriveAllowPolicy:        queue.RedriveAllowPolicy,
+			LongPollWaiters:           queue.longPollWaiters,
+			MaxLongPollWaiters:        queue.MaxLongPollWaiters,
+			DeduplicationWindow:       deduplicationWindow,
+			MaxMessages:               queue.MaxMessages,
+			StrictOrdering:            queue.StrictOrdering,
+			SentCount:                 queue.SentCount,
+			ReceivedCount:             queue.ReceivedCount,
+			DeletedCount:              queue.DeletedCount,
+			DLQMovedCount:             queue.DLQMovedCount,
+			EmptyReceiveCount:         queue.EmptyReceiveCount,
+			RedriveSourceQueues:       redriveSources,
 		})
 
 		queue.mu.RUnlock()
@@ -820,7 +1663,22 @@ func adminAPIHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"queues": queueDetails,
+		"queues":     queueDetails,
+		"account_id": activeConfig.Server.AccountID,
+	})
+}
+
+// adminMetricsHandler returns every queue's metrics, keyed by queue name, for
+// dashboards written against the CloudWatch SQS metrics
+func adminMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	metrics := make(map[string]QueueMetrics)
+	for _, queue := range getQueueManager(r).GetAllQueues() {
+		metrics[queue.Name] = queue.Metrics()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"queues": metrics,
 	})
 }
 
@@ -836,6 +1694,9 @@ func adminCreateQueueHandler(w http.ResponseWriter, r *http.Request) {
 		VisibilityTimeout      int               `json:"visibility_timeout"`
 		MessageRetentionPeriod int               `json:"message_retention_period"`
 		MaxMessageSize         int               `json:"max_message_size"`
+		MaxLongPollWaiters     int               `json:"max_long_poll_waiters"`
+		DeduplicationWindow    int               `json:"deduplication_window_seconds"`
+		MaxMessages            int               `json:"max_messages"`
 		Attributes             map[string]string `json:"attributes"`
 	}
 
@@ -850,14 +1711,26 @@ func adminCreateQueueHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Set defaults if not provided
+	defaults := activeConfig.Server.Defaults
 	if req.VisibilityTimeout == 0 {
-		req.VisibilityTimeout = 30
+		req.VisibilityTimeout = defaults.VisibilityTimeout
 	}
 	if req.MessageRetentionPeriod == 0 {
-		req.MessageRetentionPeriod = 345600 // 4 days in seconds
+		req.MessageRetentionPeriod = defaults.MessageRetentionPeriod
 	}
 	if req.MaxMessageSize == 0 {
-		req.MaxMessageSize = 262144 // 256 KB
+		req.MaxMessageSize = defaults.MaximumMessageSize
+	}
+	if req.DeduplicationWindow == 0 {
+		req.DeduplicationWindow = defaultDeduplicationWindow
+	}
+	if req.DeduplicationWindow < 0 {
+		http.Error(w, "deduplication_window_seconds must be at least 1", http.StatusBadRequest)
+		return
+	}
+	if req.MaxMessages < 0 {
+		http.Error(w, "max_messages must not be negative", http.StatusBadRequest)
+		return
 	}
 
 	// Build attributes map
@@ -871,9 +1744,9 @@ func adminCreateQueueHandler(w http.ResponseWriter, r *http.Request) {
 		attributes[k] = v
 	}
 
-	queue, err := queueManager.CreateQueue(req.Name, attributes)
+	queue, err := getQueueManager(r).CreateQueue(req.Name, attributes)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -882,6 +1755,9 @@ func adminCreateQueueHandler(w http.ResponseWriter, r *http.Request) {
 	queue.VisibilityTimeout = req.VisibilityTimeout
 	queue.MessageRetentionPeriod = req.MessageRetentionPeriod
 	queue.MaximumMessageSize = req.MaxMessageSize
+	queue.MaxLongPollWaiters = req.MaxLongPollWaiters
+	queue.DeduplicationWindow = req.DeduplicationWindow
+	queue.MaxMessages = req.MaxMessages
 	queue.mu.Unlock()
 
 	w.Header().Set("Content-Type", "application/json")
@@ -910,7 +1786,7 @@ func adminDeleteQueueHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	queueManager.DeleteQueue(queueName)
+	getQueueManager(r).DeleteQueue(queueName)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -919,6 +1795,359 @@ func adminDeleteQueueHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// adminQueueMessagesHandler lists a queue's messages without receiving them,
+// optionally only those whose message attribute attr has the given value.
+// Without a value any message carrying the attribute matches. It is a
+// read-only debugging aid and changes nothing about the messages.
+func adminQueueMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	queueName := chi.URLParam(r, "name")
+	queue, exists := getQueueManager(r).GetQueue(queueName)
+	if !exists {
+		http.Error(w, "Queue not found", http.StatusNotFound)
+		return
+	}
+
+	attr := r.URL.Query().Get("attr")
+	value, hasValue := r.URL.Query().Get("value"), r.URL.Query().Has("value")
+	if attr == "" && hasValue {
+		http.Error(w, "value requires attr", http.StatusBadRequest)
+		return
+	}
+
+	queue.mu.RLock()
+	redrivePolicy := queue.redrivePolicyLocked()
+	now := queue.now()
+	queue.mu.RUnlock()
+
+	messages := make([]MessageDetails, 0)
+	peeked := queue.PeekMessages(0)
+	for i := range peeked {
+		msg := &peeked[i]
+		if attr != "" {
+			attrValue, ok := messageAttributeValue(msg, attr)
+			if !ok || (hasValue && attrValue != value) {
+				continue
+			}
+		}
+		messages = append(messages, newMessageDetails(msg, redrivePolicy, now))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"queue_name": queueName,
+		"count":      len(messages),
+		"messages":   messages,
+	})
+}
+
+// exportedMessage is one line of a queue's NDJSON export. Import reads the
+// same format and uses only the body, attributes, message group ID and
+// whether the message was in flight; the rest is there for reference.
+type exportedMessage struct {
+	MessageID               string                 `json:"message_id,omitempty"`
+	Body                    string                 `json:"body"`
+	MessageAttributes       map[string]interface{} `json:"message_attributes,omitempty"`
+	MessageSystemAttributes map[string]string      `json:"message_system_attributes,omitempty"`
+	MessageGroupId          string                 `json:"message_group_id,omitempty"`
+	MessageDeduplicationId  string                 `json:"message_deduplication_id,omitempty"`
+	SequenceNumber          string                 `json:"sequence_number,omitempty"`
+	SentTimestamp           *time.Time             `json:"sent_timestamp,omitempty"`
+	ReceiveCount            int                    `json:"receive_count,omitempty"`
+	InFlight                bool                   `json:"in_flight,omitempty"`
+}
+
+// adminExportMessagesHandler writes a queue's messages as newline-delimited
+// JSON, one exportedMessage per line, without receiving them
+func adminExportMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	queueName := chi.URLParam(r, "name")
+	queue, exists := getQueueManager(r).GetQueue(queueName)
+	if !exists {
+		http.Error(w, "Queue not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", queueName+".ndjson"))
+	encoder := json.NewEncoder(w)
+	now := queue.Now()
+	for _, msg := range queue.PeekMessages(0) {
+		sent := msg.SentTimestamp
+		if err := encoder.Encode(exportedMessage{
+			MessageID:               msg.MessageID,
+			Body:                    msg.bodyText(),
+			MessageAttributes:       msg.MessageAttributes,
+			MessageSystemAttributes: msg.MessageSystemAttributes,
+			MessageGroupId:          msg.MessageGroupId,
+			MessageDeduplicationId:  msg.MessageDeduplicationId,
+			SequenceNumber:          msg.SequenceNumber,
+			SentTimestamp:           &sent,
+			ReceiveCount:            msg.ReceiveCount,
+			InFlight:                msg.state(now) == messageInFlight,
+		}); err != nil {
+			slog.Error("failed to write queue export", "queue", queueName, "error", err)
+			return
+		}
+	}
+}
+
+// adminImportMessagesHandler sends the messages of an NDJSON export to a
+// queue. Every line is parsed before any is sent, so a malformed file loads
+// nothing. Imported messages are new messages: they get fresh message IDs
+// and, on FIFO queues, fresh deduplication IDs so that none are dropped.
+// During server.startup_grace_period, messages exported in flight are put
+// back in flight, their returns spread over what is left of the period.
+func adminImportMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	queueName := chi.URLParam(r, "name")
+	queue, exists := getQueueManager(r).GetQueue(queueName)
+	if !exists {
+		http.Error(w, "Queue not found", http.StatusNotFound)
+		return
+	}
+
+	queue.mu.RLock()
+	fifo := queue.FifoQueue
+	queue.mu.RUnlock()
+
+	var messages []exportedMessage
+	decoder := json.NewDecoder(r.Body)
+	for {
+		var msg exportedMessage
+		if err := decoder.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid message %d: %v", len(messages)+1, err), http.StatusBadRequest)
+			return
+		}
+		if fifo && msg.MessageGroupId == "" {
+			http.Error(w, fmt.Sprintf("Message %d has no message_group_id, which a FIFO queue requires", len(messages)+1),
+				http.StatusBadRequest)
+			return
+		}
+		messages = append(messages, msg)
+	}
+
+	grace, inFlight, restored := startupGraceRemaining(), 0, 0
+	for _, msg := range messages {
+		if msg.InFlight {
+			inFlight++
+		}
+	}
+
+	messageIds := make([]string, 0, len(messages))
+	for i, msg := range messages {
+		attributes := msg.MessageAttributes
+		if attributes == nil {
+			attributes = map[string]interface{}{}
+		}
+		deduplicationId := ""
+		if fifo {
+			deduplicationId = uuid.New().String()
+		}
+		var message *Message
+		var err error
+		if msg.InFlight && grace > 0 {
+			restored++
+			hiddenFor := grace * time.Duration(restored) / time.Duration(inFlight)
+			message, err = queue.RestoreInFlight(msg.Body, attributes, msg.MessageSystemAttributes, deduplicationId, msg.MessageGroupId, hiddenFor)
+		} else {
+			message, err = queue.SendMessage(msg.Body, attributes, msg.MessageSystemAttributes, 0, deduplicationId, msg.MessageGroupId)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Message %d: %v (%d imported before it)", i+1, err, len(messageIds)), http.StatusBadRequest)
+			return
+		}
+		messageIds = append(messageIds, message.MessageID)
+	}
+
+	slog.Info("imported messages", "queue", queueName, "count", len(messageIds), "restored_in_flight", restored)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"queue_name":  queueName,
+		"imported":    len(messageIds),
+		"in_flight":   restored,
+		"message_ids": messageIds,
+	})
+}
+
+// messageAttributeValue returns the string or base64 binary value of a
+// message attribute and whether the message has it
+func messageAttributeValue(msg *Message, name string) (string, bool) {
+	attr, ok := msg.MessageAttributes[name].(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	if s, ok := attr["StringValue"].(string); ok {
+		return s, true
+	}
+	b, _ := attr["BinaryValue"].(string)
+	return b, true
+}
+
+// adminResetVisibilityHandler makes all in-flight messages of a queue visible again
+func adminResetVisibilityHandler(w http.ResponseWriter, r *http.Request) {
+	queueName := chi.URLParam(r, "name")
+	queue, exists := getQueueManager(r).GetQueue(queueName)
+	if !exists {
+		http.Error(w, "Queue not found", http.StatusNotFound)
+		return
+	}
+
+	reset := queue.ResetVisibility()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"queue_name":  queueName,
+		"reset_count": reset,
+	})
+}
+
+// adminResetQueueHandler clears a queue back to its state when it was
+// created, for setting up tests
+func adminResetQueueHandler(w http.ResponseWriter, r *http.Request) {
+	queueName := chi.URLParam(r, "name")
+	queue, exists := getQueueManager(r).GetQueue(queueName)
+	if !exists {
+		http.Error(w, "Queue not found", http.StatusNotFound)
+		return
+	}
+
+	count := queue.Reset()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":       true,
+		"queue_name":    queueName,
+		"message_count": count,
+	})
+}
+
+// adminReceiveHandler receives messages from a queue for debugging, putting
+// them in flight as ReceiveMessage does. With group_id it receives the head
+// of that message group of a FIFO queue; otherwise it receives up to
+// max_messages (default 1) as the queue would pick them. visibility_timeout
+// defaults to the queue's.
+func adminReceiveHandler(w http.ResponseWriter, r *http.Request) {
+	queueName := chi.URLParam(r, "name")
+	queue, exists := getQueueManager(r).GetQueue(queueName)
+	if !exists {
+		http.Error(w, "Queue not found", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	maxMessages := parseIntDefault(query.Get("max_messages"), 1)
+	if maxMessages < 1 || maxMessages > 10 {
+		http.Error(w, "max_messages must be from 1 to 10", http.StatusBadRequest)
+		return
+	}
+	visibilityTimeout := queue.VisibilityTimeout
+	if value := query.Get("visibility_timeout"); value != "" {
+		var err error
+		visibilityTimeout, err = strconv.Atoi(value)
+		if err != nil || visibilityTimeout < 0 || visibilityTimeout > 43200 {
+			http.Error(w, "visibility_timeout must be from 0 to 43200", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var received []*Message
+	var err error
+	if groupId := query.Get("group_id"); groupId != "" {
+		var msg *Message
+		msg, err = queue.ReceiveFromGroup(groupId, visibilityTimeout)
+		if msg != nil {
+			received = []*Message{msg}
+		}
+	} else {
+		received, err = queue.ReceiveMessages(maxMessages, visibilityTimeout)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The received messages are copied under the lock, since a concurrent
+	// receive or delete may change them as soon as it is released
+	queue.mu.RLock()
+	now := queue.now()
+	redrivePolicy := queue.redrivePolicyLocked()
+	messages := make([]MessageDetails, 0, len(received))
+	for _, msg := range received {
+		messages = append(messages, newMessageDetails(msg, redrivePolicy, now))
+	}
+	queue.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"queue_name": queueName,
+		"messages":   messages,
+	})
+}
+
+// adminMoveToDLQHandler moves one message to its queue's dead-letter queue
+// without it having to be received MaxReceiveCount times first
+func adminMoveToDLQHandler(w http.ResponseWriter, r *http.Request) {
+	queueName := chi.URLParam(r, "name")
+	messageID := chi.URLParam(r, "messageId")
+	queue, exists := getQueueManager(r).GetQueue(queueName)
+	if !exists {
+		http.Error(w, "Queue not found", http.StatusNotFound)
+		return
+	}
+
+	found, err := queue.MoveToDLQ(messageID)
+	if !found {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"queue_name": queueName,
+		"message_id": messageID,
+	})
+}
+
+// adminAdvanceClockHandler moves the queues' clock forward so tests can skip
+// over delays, visibility timeouts and retention periods without sleeping.
+// It is an emulator extension and only available in lenient mode.
+func adminAdvanceClockHandler(w http.ResponseWriter, r *http.Request) {
+	if !activeConfig.Server.Lenient {
+		http.Error(w, "advancing the clock is an emulator extension and requires server.lenient to be enabled", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Seconds int `json:"seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Seconds <= 0 {
+		http.Error(w, "seconds must be positive", http.StatusBadRequest)
+		return
+	}
+
+	offset := getQueueManager(r).AdvanceClock(time.Duration(req.Seconds) * time.Second)
+	slog.Info("clock advanced", "seconds", req.Seconds, "offset", offset)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":        true,
+		"offset_seconds": int64(offset / time.Second),
+	})
+}
+
 // adminSendMessageHandler sends a test message to a queue via the admin API
 func adminSendMessageHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -933,6 +2162,7 @@ func adminSendMessageHandler(w http.ResponseWriter, r *http.Request) {
 		Attributes             map[string]string `json:"attributes"`
 		MessageGroupId         string            `json:"message_group_id"`
 		MessageDeduplicationId string            `json:"message_deduplication_id"`
+		MessageRetentionPeriod int               `json:"message_retention_period"` // seconds; lenient mode only
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -944,20 +2174,35 @@ func adminSendMessageHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Queue name and message body are required", http.StatusBadRequest)
 		return
 	}
+	if req.MessageRetentionPeriod != 0 && !activeConfig.Server.Lenient {
+		http.Error(w, "message_retention_period is an emulator extension and requires server.lenient to be enabled", http.StatusBadRequest)
+		return
+	}
 
-	queue, exists := queueManager.GetQueue(req.QueueName)
+	queue, exists := getQueueManager(r).GetQueue(req.QueueName)
 	if !exists {
 		http.Error(w, "Queue not found", http.StatusNotFound)
 		return
 	}
 
-	// Convert string map to interface map for attributes
+	// The attributes are given as plain strings, so they become String
+	// message attributes
 	attrs := make(map[string]interface{})
 	for k, v := range req.Attributes {
-		attrs[k] = v
+		attrs[k] = map[string]interface{}{"DataType": "String", "StringValue": v}
+	}
+	if req.MessageRetentionPeriod != 0 {
+		attrs[retentionAttribute] = map[string]interface{}{
+			"DataType":    "Number",
+			"StringValue": strconv.Itoa(req.MessageRetentionPeriod),
+		}
 	}
 
-	message := queue.SendMessage(req.MessageBody, attrs, req.DelaySeconds, req.MessageDeduplicationId, req.MessageGroupId)
+	message, err := queue.SendMessage(req.MessageBody, attrs, nil, req.DelaySeconds, req.MessageDeduplicationId, req.MessageGroupId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -968,6 +2213,110 @@ func adminSendMessageHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// maxBulkSendCount caps the number of messages a single bulk send may generate
+const maxBulkSendCount = 10000
+
+// bulkSendTemplateData is the data available to a bulk send body_template
+type bulkSendTemplateData struct {
+	Index     int    // position of the message in the batch, starting at 0
+	Timestamp string // RFC 3339 time the message was generated
+	UUID      string // random UUID, different for every message
+}
+
+// adminBulkSendHandler sends a batch of generated messages to a queue. Each
+// body is rendered from body_template with text/template; every body is
+// rendered before anything is sent, so a bad template sends nothing.
+func adminBulkSendHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		QueueName      string `json:"queue_name"`
+		Count          int    `json:"count"`
+		BodyTemplate   string `json:"body_template"`
+		DelaySeconds   int    `json:"delay_seconds"`
+		MessageGroupId string `json:"message_group_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.QueueName == "" || req.BodyTemplate == "" {
+		http.Error(w, "Queue name and body template are required", http.StatusBadRequest)
+		return
+	}
+	if req.Count < 1 || req.Count > maxBulkSendCount {
+		http.Error(w, fmt.Sprintf("Count must be between 1 and %d", maxBulkSendCount), http.StatusBadRequest)
+		return
+	}
+
+	queue, exists := getQueueManager(r).GetQueue(req.QueueName)
+	if !exists {
+		http.Error(w, "Queue not found", http.StatusNotFound)
+		return
+	}
+
+	tmpl, err := template.New("body_template").Parse(req.BodyTemplate)
+	if err != nil {
+		http.Error(w, "Invalid body_template: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bodies := make([]string, req.Count)
+	for i := range bodies {
+		var body strings.Builder
+		data := bulkSendTemplateData{
+			Index:     i,
+			Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+			UUID:      uuid.New().String(),
+		}
+		if err := tmpl.Execute(&body, data); err != nil {
+			http.Error(w, "Invalid body_template: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		bodies[i] = body.String()
+	}
+
+	queue.mu.RLock()
+	fifo := queue.FifoQueue
+	queue.mu.RUnlock()
+
+	groupId := req.MessageGroupId
+	if fifo && groupId == "" {
+		groupId = "bulk"
+	}
+
+	messageIds := make([]string, 0, len(bodies))
+	for _, body := range bodies {
+		// Give every FIFO message its own deduplication ID so identical
+		// bodies aren't dropped as duplicates
+		deduplicationId := ""
+		if fifo {
+			deduplicationId = uuid.New().String()
+		}
+		message, err := queue.SendMessage(body, map[string]interface{}{}, nil, req.DelaySeconds, deduplicationId, groupId)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		messageIds = append(messageIds, message.MessageID)
+	}
+
+	slog.Info("bulk sent messages", "queue", req.QueueName, "count", len(messageIds))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"queue_name":  req.QueueName,
+		"sent":        len(messageIds),
+		"message_ids": messageIds,
+	})
+}
+
 // adminExportConfigHandler exports the current queue configuration as YAML
 func adminExportConfigHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -975,35 +2324,85 @@ func adminExportConfigHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	queues := queueManager.GetAllQueues()
+	queues := getQueueManager(r).GetAllQueues()
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].Name < queues[j].Name
+	})
 
 	var configYAML strings.Builder
 	configYAML.WriteString("# Ess-Queue-Ess Configuration\n")
 	configYAML.WriteString("# Generated on: " + time.Now().Format(time.RFC3339) + "\n\n")
 	configYAML.WriteString("server:\n")
-	configYAML.WriteString("  port: 9324\n")
-	configYAML.WriteString("  host: 0.0.0.0\n\n")
+	configYAML.WriteString(fmt.Sprintf("  port: %d\n", activeConfig.Server.Port))
+	configYAML.WriteString(fmt.Sprintf("  host: %s\n\n", yamlQuote(activeConfig.Server.Host)))
 	configYAML.WriteString("queues:\n")
 
 	for _, queue := range queues {
 		queue.mu.RLock()
-		configYAML.WriteString(fmt.Sprintf("  - name: %s\n", queue.Name))
+		configYAML.WriteString(fmt.Sprintf("  - name: %s\n", yamlQuote(queue.Name)))
 		configYAML.WriteString(fmt.Sprintf("    visibility_timeout: %d\n", queue.VisibilityTimeout))
 		configYAML.WriteString(fmt.Sprintf("    message_retention_period: %d\n", queue.MessageRetentionPeriod))
 		configYAML.WriteString(fmt.Sprintf("    maximum_message_size: %d\n", queue.MaximumMessageSize))
 		configYAML.WriteString(fmt.Sprintf("    max_receive_count: %d\n", queue.MaxReceiveCount))
+		configYAML.WriteString(fmt.Sprintf("    delay_seconds: %d\n", queue.DelaySeconds))
+		configYAML.WriteString(fmt.Sprintf("    receive_message_wait_time: %d\n", queue.ReceiveMessageWaitTime))
+		if queue.MaxLongPollWaiters > 0 {
+			configYAML.WriteString(fmt.Sprintf("    max_long_poll_waiters: %d\n", queue.MaxLongPollWaiters))
+		}
+		configYAML.WriteString(fmt.Sprintf("    max_visibility_timeout: %d\n", queue.MaxVisibilityTimeout))
+		if queue.MaxMessages > 0 {
+			configYAML.WriteString(fmt.Sprintf("    max_messages: %d\n", queue.MaxMessages))
+		}
+		if queue.StrictOrdering {
+			configYAML.WriteString("    strict_ordering: true\n")
+		}
+		if queue.RateLimit != (RateLimitConfig{}) {
+			configYAML.WriteString("    rate_limit:\n")
+			configYAML.WriteString(fmt.Sprintf("      send_per_second: %g\n", queue.RateLimit.SendPerSecond))
+			configYAML.WriteString(fmt.Sprintf("      receive_per_second: %g\n", queue.RateLimit.ReceivePerSecond))
+		}
+		if queue.FifoQueue && queue.DeduplicationWindow != defaultDeduplicationWindow {
+			configYAML.WriteString(fmt.Sprintf("    deduplication_window_seconds: %d\n", queue.DeduplicationWindow))
+		}
+
+		// FIFO and DLQ settings are carried as queue attributes so that
+		// BootstrapQueues applies them through CreateQueue on re-import
+		attributes := make(map[string]string)
 		if queue.FifoQueue {
-			configYAML.WriteString(fmt.Sprintf("    fifo_queue: true\n"))
-			if queue.ContentBasedDeduplication {
-				configYAML.WriteString(fmt.Sprintf("    content_based_deduplication: true\n"))
+			attributes["FifoQueue"] = "true"
+			attributes["ContentBasedDeduplication"] = strconv.FormatBool(queue.ContentBasedDeduplication)
+			if queue.DeduplicationScope != "" {
+				attributes["DeduplicationScope"] = queue.DeduplicationScope
+				attributes["FifoThroughputLimit"] = queue.fifoThroughputLimit()
 			}
 		}
 		if queue.RedrivePolicy != nil {
-			configYAML.WriteString(fmt.Sprintf("    redrive_policy:\n"))
-			configYAML.WriteString(fmt.Sprintf("      dead_letter_target_arn: %s\n", queue.RedrivePolicy.DeadLetterTargetArn))
-			configYAML.WriteString(fmt.Sprintf("      max_receive_count: %d\n", queue.RedrivePolicy.MaxReceiveCount))
+			if policy, err := json.Marshal(queue.RedrivePolicy); err == nil {
+				attributes["RedrivePolicy"] = string(policy)
+			}
+		}
+		if queue.RedriveAllowPolicy != nil {
+			if policy, err := json.Marshal(queue.RedriveAllowPolicy); err == nil {
+				attributes["RedriveAllowPolicy"] = string(policy)
+			}
 		}
 		queue.mu.RUnlock()
+
+		if len(attributes) == 0 {
+			configYAML.WriteString("    attributes: {}\n")
+			continue
+		}
+
+		names := make([]string, 0, len(attributes))
+		for name := range attributes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		configYAML.WriteString("    attributes:\n")
+		for _, name := range names {
+			configYAML.WriteString(fmt.Sprintf("      %s: %s\n", name, yamlQuote(attributes[name])))
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/x-yaml")
@@ -1011,6 +2410,14 @@ func adminExportConfigHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(configYAML.String()))
 }
 
+// adminLastReloadHandler reports what the most recent SIGHUP configuration reload changed
+func adminLastReloadHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"last_reload": LastReload(),
+	})
+}
+
 // Redrive handlers for DLQ support
 func handleStartMessageMoveTask(w http.ResponseWriter, r *http.Request) {
 	var sourceArn string
@@ -1022,7 +2429,7 @@ func handleStartMessageMoveTask(w http.ResponseWriter, r *http.Request) {
 	if isJSON {
 		jsonBody, err := parseRequestJSON(r)
 		if err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
 			return
 		}
 
@@ -1037,7 +2444,7 @@ func handleStartMessageMoveTask(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		if err := r.ParseForm(); err != nil {
-			sendError(w, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
+			sendError(w, r, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
 			return
 		}
 		sourceArn = r.FormValue("SourceArn")
@@ -1045,35 +2452,75 @@ func handleStartMessageMoveTask(w http.ResponseWriter, r *http.Request) {
 		maxMessages = parseIntDefault(r.FormValue("MaxNumberOfMessagesPerSecond"), 0)
 	}
 
-	// Extract queue names from ARNs
-	sourceName := extractQueueNameFromArn(sourceArn)
+	sourceQueue, exists := getQueueManager(r).GetQueueByArn(sourceArn)
+	if !exists {
+		sendError(w, r, "ResourceNotFoundException", "Source queue does not exist", http.StatusBadRequest)
+		return
+	}
+	sourceName := sourceQueue.Name
+
+	// Only a queue that is some other queue's DLQ can be redriven
+	deadLetterSources := getQueueManager(r).DeadLetterSources(sourceName)
+	if len(deadLetterSources) == 0 {
+		sendError(w, r, "ResourceNotFoundException", "Source queue is not the dead-letter queue of any queue", http.StatusBadRequest)
+		return
+	}
 
-	// If destinationArn is empty, use the source queue's redrive policy
-	var destName string
+	// If destinationArn is empty, move the messages back to the queue that
+	// uses the source as its DLQ
+	var destQueue *Queue
 	if destinationArn != "" {
-		destName = extractQueueNameFromArn(destinationArn)
+		queue, exists := getQueueManager(r).GetQueueByArn(destinationArn)
+		if !exists {
+			sendError(w, r, "ResourceNotFoundException", "Destination queue does not exist", http.StatusBadRequest)
+			return
+		}
+		destQueue = queue
+	} else if len(deadLetterSources) > 1 {
+		sendError(w, r, "InvalidParameterValue",
+			fmt.Sprintf("Source queue is the dead-letter queue of several queues (%s); specify DestinationArn",
+				strings.Join(deadLetterSources, ", ")),
+			http.StatusBadRequest)
+		return
 	} else {
-		// Get the source queue from DLQ and find which queue has this as their DLQ
-		_, exists := queueManager.GetQueue(sourceName)
+		queue, exists := getQueueManager(r).GetQueue(deadLetterSources[0])
 		if !exists {
-			sendError(w, "NonExistentQueue", "Source queue does not exist", http.StatusBadRequest)
+			sendError(w, r, "ResourceNotFoundException", "Destination queue does not exist", http.StatusBadRequest)
 			return
 		}
+		destQueue = queue
+	}
+	destName := destQueue.Name
 
-		// Find which queue has this as their DLQ
-		for _, q := range queueManager.GetAllQueues() {
-			if q.RedrivePolicy != nil && q.RedrivePolicy.DeadLetterTargetArn == sourceArn {
-				destName = q.Name
-				break
-			}
-		}
+	if destQueue == sourceQueue {
+		sendError(w, r, "InvalidParameterValue",
+			"The source queue and destination queue must be different queues", http.StatusBadRequest)
+		return
+	}
+
+	// FIFO messages need a FIFO queue to keep their groups and order, and a
+	// FIFO queue can't take messages without them
+	if destQueue.FifoQueue != sourceQueue.FifoQueue {
+		sendError(w, r, "InvalidParameterValue",
+			"The source queue and destination queue must be of the same type: both FIFO or both standard",
+			http.StatusBadRequest)
+		return
 	}
 
 	if maxMessages == 0 {
 		maxMessages = 100 // Default to moving 100 messages
 	}
 
-	movedCount := queueManager.RedriveMessages(sourceName, "arn:aws:sqs:us-east-1:000000000000:"+destName, maxMessages)
+	movedCount, err := getQueueManager(r).RedriveMessages(sourceName, queueArn(destName), maxMessages)
+	if err != nil {
+		var queueErr *QueueError
+		if errors.As(err, &queueErr) {
+			sendQueueError(w, r, err)
+		} else {
+			sendError(w, r, "InvalidParameterValue", err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
 
 	taskId := uuid.New().String()
 
@@ -1084,7 +2531,7 @@ func handleStartMessageMoveTask(w http.ResponseWriter, r *http.Request) {
 		resp := StartMessageMoveTaskJSONResponse{
 			TaskHandle: taskId,
 		}
-		sendJSONResponse(w, resp)
+		sendJSONResponse(w, r, resp)
 	} else {
 		type StartMessageMoveTaskResponse struct {
 			XMLName xml.Name `xml:"StartMessageMoveTaskResponse"`
@@ -1094,15 +2541,21 @@ func handleStartMessageMoveTask(w http.ResponseWriter, r *http.Request) {
 		}
 		resp := StartMessageMoveTaskResponse{}
 		resp.Result.TaskHandle = taskId
-		sendXMLResponse(w, resp)
+		sendXMLResponse(w, r, resp)
 	}
 
-	log.Printf("Started message move task %s: moved %d messages from %s to %s", taskId, movedCount, sourceName, destName)
+	slog.Info("started message move task", "task", taskId, "source", sourceName, "destination", destName,
+		"moved", movedCount)
 }
 
 func handleListMessageMoveTasks(w http.ResponseWriter, r *http.Request) {
 	isJSON := r.Header.Get("X-Amz-Target") != ""
 
+	if _, exists := getQueueManager(r).GetQueueByArn(getRequestParam(r, "SourceArn")); !exists {
+		sendError(w, r, "ResourceNotFoundException", "Source queue does not exist", http.StatusBadRequest)
+		return
+	}
+
 	// For now, return empty list since we process moves immediately
 	if isJSON {
 		type ListMessageMoveTasksJSONResponse struct {
@@ -1111,7 +2564,7 @@ func handleListMessageMoveTasks(w http.ResponseWriter, r *http.Request) {
 		resp := ListMessageMoveTasksJSONResponse{
 			Results: make([]interface{}, 0),
 		}
-		sendJSONResponse(w, resp)
+		sendJSONResponse(w, r, resp)
 	} else {
 		type ListMessageMoveTasksResponse struct {
 			XMLName xml.Name `xml:"ListMessageMoveTasksResponse"`
@@ -1121,7 +2574,7 @@ func handleListMessageMoveTasks(w http.ResponseWriter, r *http.Request) {
 		}
 		resp := ListMessageMoveTasksResponse{}
 		resp.Result.Results = make([]interface{}, 0)
-		sendXMLResponse(w, resp)
+		sendXMLResponse(w, r, resp)
 	}
 }
 
@@ -1130,11 +2583,11 @@ func handleCancelMessageMoveTask(w http.ResponseWriter, r *http.Request) {
 
 	// Since we process moves immediately, there's nothing to cancel
 	if isJSON {
-		sendJSONResponse(w, struct{}{})
+		sendJSONResponse(w, r, struct{}{})
 	} else {
 		type CancelMessageMoveTaskResponse struct {
 			XMLName xml.Name `xml:"CancelMessageMoveTaskResponse"`
 		}
-		sendXMLResponse(w, CancelMessageMoveTaskResponse{})
+		sendXMLResponse(w, r, CancelMessageMoveTaskResponse{})
 	}
 }
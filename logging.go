@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// setupLogging installs the default slog logger described by the log config.
+// Anything still written through the standard log package goes to the same
+// handler at info level.
+func setupLogging(cfg LogConfig) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.levelOrDefault())); err != nil {
+		return fmt.Errorf("invalid log.level %q: must be debug, info, warn or error", cfg.Level)
+	}
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return fmt.Errorf("invalid log.format %q: must be text or json", cfg.Format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// requestLogger logs one line per HTTP request through slog, replacing chi's
+// middleware.Logger. It runs outermost, so it reads the request and
+// correlation IDs back from the response headers set further in.
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		slog.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"duration", time.Since(start),
+			"request_id", ww.Header().Get("x-amzn-RequestId"),
+			"correlation_id", ww.Header().Get(correlationIDHeader),
+		)
+	})
+}
@@ -4,10 +4,13 @@ package main
 
 import (
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -16,54 +19,152 @@ import (
 func main() {
 	// Parse command line flags
 	configPath := flag.String("config", "", "Path to configuration file")
+	validateOnly := flag.Bool("validate-config", false, "Validate the configuration file, print a report and exit")
 	flag.Parse()
 
-	// Load configuration if provided
+	// Validation runs before anything is started, so it can be used on a
+	// machine where the server is already running
+	if *validateOnly {
+		if *configPath == "" {
+			fmt.Fprintln(os.Stderr, "--validate-config requires --config")
+			os.Exit(2)
+		}
+		if !validateConfigFile(*configPath, os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	setupLogging(activeConfig.Log)
+
+	queueManager := NewQueueManager("")
+	instances := make(map[string]*QueueManager)
+
+	// Load configuration if provided. A config that doesn't load is fatal:
+	// starting on the defaults would silently drop its queues and its auth
+	// settings.
 	if *configPath != "" {
 		config, err := LoadConfig(*configPath)
 		if err != nil {
-			log.Printf("Warning: Failed to load config: %v", err)
-		} else {
-			log.Printf("Loaded configuration from %s", *configPath)
-			if err := BootstrapQueues(config); err != nil {
-				log.Fatalf("Failed to bootstrap queues: %v", err)
-			}
-			log.Printf("Bootstrapped %d queues from configuration", len(config.Queues))
+			fatal("failed to load config", "path", *configPath, "error", err)
+		}
+		activeConfig = config
+		if err := setupLogging(config.Log); err != nil {
+			fatal("invalid log configuration", "error", err)
+		}
+		slog.Info("loaded configuration", "path", *configPath)
+		if err := BootstrapQueues(queueManager, config.Queues); err != nil {
+			fatal("failed to bootstrap queues", "error", err)
+		}
+		slog.Info("bootstrapped queues", "count", len(config.Queues))
+		queueManager.SetSubscriptions(config.Subscriptions)
 
-			// Use port from config if not overridden by environment
-			if os.Getenv("PORT") == "" && config.Server.Port > 0 {
-				os.Setenv("PORT", strconv.Itoa(config.Server.Port))
+		// Each additional instance gets its own isolated queue manager
+		for _, instance := range config.Instances {
+			instanceManager := NewQueueManager("/" + instance.Name)
+			if err := BootstrapQueues(instanceManager, instance.Queues); err != nil {
+				fatal("failed to bootstrap queues", "instance", instance.Name, "error", err)
 			}
+			instanceManager.SetSubscriptions(instance.Subscriptions)
+			instances[instance.Name] = instanceManager
+			slog.Info("bootstrapped queues", "instance", instance.Name, "count", len(instance.Queues))
+		}
+
+		// Use port from config if not overridden by environment
+		if os.Getenv("PORT") == "" && config.Server.Port > 0 {
+			os.Setenv("PORT", strconv.Itoa(config.Server.Port))
 		}
 	}
 
+	// Reload the configuration file on SIGHUP
+	if *configPath != "" {
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		go func() {
+			for range reload {
+				ReloadConfig(*configPath, queueManager)
+			}
+		}()
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "9324" // Default SQS port for local development
 	}
+	if p, err := strconv.Atoi(port); err == nil {
+		activeConfig.Server.Port = p
+	}
 
 	r := chi.NewRouter()
 
 	// Middleware
-	r.Use(middleware.Logger)
+	r.Use(requestLogger)
 	r.Use(middleware.Recoverer)
+	r.Use(awsRequestID)
 	r.Use(middleware.RequestID)
+	r.Use(withCorrelationID)
+	r.Use(withQueueManager(queueManager))
 
 	// Routes
 	r.Get("/health", healthHandler)
-	r.Get("/admin", adminUIHandler)
-	r.Get("/admin/api/queues", adminAPIHandler)
-	r.Post("/admin/api/queue", adminCreateQueueHandler)
-	r.Delete("/admin/api/queue", adminDeleteQueueHandler)
-	r.Post("/admin/api/message", adminSendMessageHandler)
-	r.Get("/admin/api/config/export", adminExportConfigHandler)
-	r.HandleFunc("/*", rootHandler)
-
-	log.Printf("Starting Ess-Queue-Ess on port %s", port)
-	log.Printf("SQS endpoint: http://localhost:%s/", port)
-	log.Printf("Admin UI: http://localhost:%s/admin", port)
+	r.Get("/livez", livezHandler)
+	r.Get("/readyz", readyzHandler)
+	r.With(requireAdminToken).Get("/admin", adminUIHandler)
+	r.With(requireAdminToken).Get("/admin/ws", adminWebSocketHandler)
+	r.Route("/admin/api", func(r chi.Router) {
+		// CORS comes first so preflight requests, which carry no
+		// credentials, are answered
+		r.Use(adminCORS)
+		r.Use(requireAdminToken)
+		r.Get("/queues", adminAPIHandler)
+		r.Get("/metrics", adminMetricsHandler)
+		r.Post("/queue", adminCreateQueueHandler)
+		r.Delete("/queue", adminDeleteQueueHandler)
+		r.Post("/message", adminSendMessageHandler)
+		r.Post("/messages/bulk", adminBulkSendHandler)
+		r.Get("/queues/{name}/messages", adminQueueMessagesHandler)
+		r.Get("/queues/{name}/export", adminExportMessagesHandler)
+		r.Post("/queues/{name}/import", adminImportMessagesHandler)
+		r.Post("/queues/{name}/reset-visibility", adminResetVisibilityHandler)
+		r.Post("/queues/{name}/reset", adminResetQueueHandler)
+		r.Post("/queues/{name}/receive", adminReceiveHandler)
+		r.Post("/queues/{name}/messages/{messageId}/move-to-dlq", adminMoveToDLQHandler)
+		r.Post("/clock/advance", adminAdvanceClockHandler)
+		r.Get("/config/export", adminExportConfigHandler)
+		r.Get("/last-reload", adminLastReloadHandler)
+	})
+	for name, instanceManager := range instances {
+		r.Route("/"+name, func(r chi.Router) {
+			r.Use(withQueueManager(instanceManager))
+			r.Use(verifySignature)
+			r.Use(decodeRequestJSON)
+			r.Use(limitRate)
+			r.HandleFunc("/*", rootHandler)
+		})
+		slog.Info("instance endpoint", "instance", name, "url", "http://localhost:"+port+"/"+name+"/")
+	}
+	r.With(verifySignature, decodeRequestJSON, limitRate).HandleFunc("/*", rootHandler)
+
+	slog.Info("starting Ess-Queue-Ess", "port", port,
+		"sqs_endpoint", "http://localhost:"+port+"/",
+		"admin_ui", "http://localhost:"+port+"/admin")
+	if activeConfig.Auth.VerifySignature {
+		slog.Info("SigV4 signature verification enabled", "access_key", activeConfig.Auth.AccessKey)
+	}
+
+	queueCount := len(queueManager.GetAllQueues())
+	for _, instanceManager := range instances {
+		queueCount += len(instanceManager.GetAllQueues())
+	}
+	markReady(queueCount)
 
 	if err := http.ListenAndServe(":"+port, r); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+		fatal("server failed to start", "error", err)
 	}
 }
+
+// fatal logs an error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,295 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// Queue access policies are stored and returned as the Policy attribute, so
+// tools that manage them with AddPermission and RemovePermission work, but
+// they are never enforced: every caller may do everything.
+
+// permissionLabelPattern matches a valid AddPermission label: 1 to 80
+// alphanumeric characters, hyphens and underscores
+var permissionLabelPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,80}$`)
+
+// accountIDPattern matches an AWS account ID
+var accountIDPattern = regexp.MustCompile(`^[0-9]{12}$`)
+
+// shareableActions are the actions AddPermission can grant to other accounts.
+// The rest may only be called by the queue's owner.
+var shareableActions = map[string]bool{
+	"*":                          true,
+	"SendMessage":                true,
+	"ReceiveMessage":             true,
+	"DeleteMessage":              true,
+	"ChangeMessageVisibility":    true,
+	"GetQueueAttributes":         true,
+	"GetQueueUrl":                true,
+	"ListDeadLetterSourceQueues": true,
+	"ListQueueTags":              true,
+	"PurgeQueue":                 true,
+}
+
+// policyStatement is the statement AddPermission adds to a queue's policy
+type policyStatement struct {
+	Sid       string `json:"Sid"`
+	Effect    string `json:"Effect"`
+	Principal struct {
+		AWS interface{} `json:"AWS"`
+	} `json:"Principal"`
+	Action   interface{} `json:"Action"`
+	Resource string      `json:"Resource"`
+}
+
+// checkPolicy validates a Policy attribute. It must be a JSON object; an empty
+// value removes the policy.
+func checkPolicy(policy string) error {
+	if policy == "" {
+		return nil
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(policy), &document); err != nil {
+		return &QueueError{"InvalidAttributeValue", "Invalid value for the parameter Policy: the policy is not a valid JSON object"}
+	}
+	return nil
+}
+
+// policyDocument decodes the queue's policy, or starts a new one if the queue
+// has none. The statements are always returned as a list, since a policy may
+// give a single statement on its own. The caller must hold the queue lock.
+func (q *Queue) policyDocument() (map[string]interface{}, []interface{}) {
+	var document map[string]interface{}
+	if q.Policy != "" {
+		json.Unmarshal([]byte(q.Policy), &document)
+	}
+	if document == nil {
+		document = map[string]interface{}{
+			"Version": "2012-10-17",
+			"Id":      queueArn(q.Name) + "/SQSDefaultPolicy",
+		}
+	}
+
+	var statements []interface{}
+	switch s := document["Statement"].(type) {
+	case []interface{}:
+		statements = s
+	case map[string]interface{}:
+		statements = []interface{}{s}
+	}
+	return document, statements
+}
+
+// statementSid returns the Sid of a policy statement, or "" if it has none
+func statementSid(statement interface{}) string {
+	s, _ := statement.(map[string]interface{})
+	sid, _ := s["Sid"].(string)
+	return sid
+}
+
+// AddPermission adds a statement labelled label to the queue's policy that
+// allows the accounts to call the actions. Errors are *QueueError.
+func (q *Queue) AddPermission(label string, accountIDs, actions []string) error {
+	if !permissionLabelPattern.MatchString(label) {
+		return &QueueError{"InvalidParameterValue",
+			"Value " + label + " for parameter Label is invalid. Reason: Must be at most 80 alphanumeric characters, hyphens and underscores."}
+	}
+	principals := make([]string, 0, len(accountIDs))
+	for _, id := range accountIDs {
+		if !accountIDPattern.MatchString(id) {
+			return &QueueError{"InvalidParameterValue", "Value " + id + " for parameter AWSAccountId is invalid. Reason: Must be a 12 digit account ID."}
+		}
+		principals = append(principals, "arn:aws:iam::"+id+":root")
+	}
+	grants := make([]string, 0, len(actions))
+	for _, action := range actions {
+		if !shareableActions[action] {
+			return &QueueError{"InvalidParameterValue",
+				"Value SQS:" + action + " for parameter ActionName is invalid. Reason: Only the queue owner is allowed to invoke this action."}
+		}
+		grants = append(grants, "SQS:"+action)
+	}
+
+	statement := policyStatement{Sid: label, Effect: "Allow", Resource: queueArn(q.Name)}
+	// Like SQS, a single principal or action is given on its own, not as a list
+	statement.Principal.AWS = principals
+	if len(principals) == 1 {
+		statement.Principal.AWS = principals[0]
+	}
+	statement.Action = grants
+	if len(grants) == 1 {
+		statement.Action = grants[0]
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	document, statements := q.policyDocument()
+	for _, existing := range statements {
+		if statementSid(existing) == label {
+			return &QueueError{"InvalidParameterValue", "Value " + label + " for parameter Label is invalid. Reason: Already exists."}
+		}
+	}
+	document["Statement"] = append(statements, statement)
+
+	policy, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	q.Policy = string(policy)
+	slog.Info("permission added", "queue", q.Name, "label", label, "accounts", accountIDs, "actions", actions)
+	return nil
+}
+
+// RemovePermission removes the statement labelled label from the queue's
+// policy, and the policy itself once it has no statements left. Errors are
+// *QueueError.
+func (q *Queue) RemovePermission(label string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	document, statements := q.policyDocument()
+	kept := make([]interface{}, 0, len(statements))
+	for _, statement := range statements {
+		if statementSid(statement) != label {
+			kept = append(kept, statement)
+		}
+	}
+	if q.Policy == "" || len(kept) == len(statements) {
+		return &QueueError{"InvalidParameterValue", "Value " + label + " for parameter Label is invalid. Reason: can't find label."}
+	}
+
+	if len(kept) == 0 {
+		q.Policy = ""
+	} else {
+		document["Statement"] = kept
+		policy, err := json.Marshal(document)
+		if err != nil {
+			return err
+		}
+		q.Policy = string(policy)
+	}
+	slog.Info("permission removed", "queue", q.Name, "label", label)
+	return nil
+}
+
+// parseStringList returns a list parameter of a request: the JSON array under
+// jsonName, or the query parameters queryName.1, queryName.2 and so on
+func parseStringList(r *http.Request, jsonBody map[string]interface{}, jsonName, queryName string) []string {
+	var values []string
+	if jsonBody != nil {
+		if list, ok := jsonBody[jsonName].([]interface{}); ok {
+			for _, v := range list {
+				if s, ok := v.(string); ok {
+					values = append(values, s)
+				}
+			}
+		}
+		return values
+	}
+	for i := 1; ; i++ {
+		value := r.Form.Get(queryName + "." + strconv.Itoa(i))
+		if value == "" {
+			break
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+func handleAddPermission(w http.ResponseWriter, r *http.Request) {
+	var queueURL, label string
+	var jsonBody map[string]interface{}
+
+	if r.Header.Get("X-Amz-Target") != "" {
+		var err error
+		if jsonBody, err = parseRequestJSON(r); err != nil {
+			sendError(w, r, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
+			return
+		}
+		queueURL, _ = jsonBody["QueueUrl"].(string)
+		label, _ = jsonBody["Label"].(string)
+	} else {
+		if err := r.ParseForm(); err != nil {
+			sendError(w, r, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
+			return
+		}
+		queueURL = r.FormValue("QueueUrl")
+		label = r.FormValue("Label")
+	}
+	accountIDs := parseStringList(r, jsonBody, "AWSAccountIds", "AWSAccountId")
+	actions := parseStringList(r, jsonBody, "Actions", "ActionName")
+
+	queue, ok := requestQueue(w, r, queueURL)
+	if !ok {
+		return
+	}
+
+	switch {
+	case label == "":
+		sendError(w, r, "MissingParameter", "The request must contain the parameter Label.", http.StatusBadRequest)
+		return
+	case len(accountIDs) == 0:
+		sendError(w, r, "MissingParameter", "The request must contain the parameter AWSAccountIds.", http.StatusBadRequest)
+		return
+	case len(actions) == 0:
+		sendError(w, r, "MissingParameter", "The request must contain the parameter Actions.", http.StatusBadRequest)
+		return
+	}
+
+	if err := queue.AddPermission(label, accountIDs, actions); err != nil {
+		sendQueueError(w, r, err)
+		return
+	}
+
+	type AddPermissionResponse struct {
+		XMLName xml.Name `xml:"AddPermissionResponse"`
+	}
+	sendResponse(w, r, AddPermissionResponse{}, struct{}{})
+}
+
+func handleRemovePermission(w http.ResponseWriter, r *http.Request) {
+	var queueURL, label string
+
+	if r.Header.Get("X-Amz-Target") != "" {
+		jsonBody, err := parseRequestJSON(r)
+		if err != nil {
+			sendError(w, r, "InvalidParameterValue", "Failed to parse JSON request", http.StatusBadRequest)
+			return
+		}
+		queueURL, _ = jsonBody["QueueUrl"].(string)
+		label, _ = jsonBody["Label"].(string)
+	} else {
+		if err := r.ParseForm(); err != nil {
+			sendError(w, r, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
+			return
+		}
+		queueURL = r.FormValue("QueueUrl")
+		label = r.FormValue("Label")
+	}
+
+	queue, ok := requestQueue(w, r, queueURL)
+	if !ok {
+		return
+	}
+	if label == "" {
+		sendError(w, r, "MissingParameter", "The request must contain the parameter Label.", http.StatusBadRequest)
+		return
+	}
+
+	if err := queue.RemovePermission(label); err != nil {
+		sendQueueError(w, r, err)
+		return
+	}
+
+	type RemovePermissionResponse struct {
+		XMLName xml.Name `xml:"RemovePermissionResponse"`
+	}
+	sendResponse(w, r, RemovePermissionResponse{}, struct{}{})
+}
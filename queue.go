@@ -3,12 +3,24 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
-	"log"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
@@ -23,6 +35,10 @@ type Message struct {
 	MessageAttributes      map[string]interface{} `json:"MessageAttributes,omitempty"`
 	MD5OfMessageAttributes string                 `json:"MD5OfMessageAttributes,omitempty"`
 
+	// MessageSystemAttributes holds the system attributes set by the sender,
+	// such as AWSTraceHeader, keyed by name
+	MessageSystemAttributes map[string]string `json:"MessageSystemAttributes,omitempty"`
+
 	// FIFO-specific fields
 	MessageDeduplicationId string `json:"MessageDeduplicationId,omitempty"`
 	MessageGroupId         string `json:"MessageGroupId,omitempty"`
@@ -34,6 +50,112 @@ type Message struct {
 	FirstReceivedTime time.Time
 	VisibilityTimeout time.Time
 	DelayUntil        time.Time
+
+	// RetentionPeriod, in seconds, overrides the queue's MessageRetentionPeriod
+	// for this message when set. It comes from the MessageRetentionPeriod
+	// message attribute, an emulator extension honored in lenient mode.
+	RetentionPeriod int
+
+	// Delivery history, only recorded when server.debug is enabled
+	History []DeliveryEvent
+
+	// compressedBody holds the gzipped body in place of Body when it was
+	// compressed, see compress.go
+	compressedBody []byte
+}
+
+// maxDeliveryHistory bounds the number of delivery events kept per message
+const maxDeliveryHistory = 50
+
+// defaultDeduplicationWindow is how many seconds a FIFO queue remembers a
+// deduplication ID unless configured otherwise, as in SQS
+const defaultDeduplicationWindow = 300
+
+// retentionAttribute is the message attribute that gives a message its own
+// retention period in lenient mode
+const retentionAttribute = "MessageRetentionPeriod"
+
+// messageRetentionPeriod returns the retention period in seconds that the
+// message's MessageRetentionPeriod attribute asks for, or 0 if it has none
+func messageRetentionPeriod(attributes map[string]interface{}) (int, error) {
+	attr, ok := attributes[retentionAttribute].(map[string]interface{})
+	if !ok {
+		return 0, nil
+	}
+	value, _ := attr["StringValue"].(string)
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 1 || seconds > 1209600 {
+		return 0, &QueueError{"InvalidParameterValue",
+			"The MessageRetentionPeriod message attribute must be a number of seconds from 1 to 1209600"}
+	}
+	return seconds, nil
+}
+
+// expiresAt returns when the message is deleted for having outlived its
+// retention period, given the queue's, or the zero time if it never is
+func (m *Message) expiresAt(queueRetentionPeriod int) time.Time {
+	retention := queueRetentionPeriod
+	if m.RetentionPeriod > 0 {
+		retention = m.RetentionPeriod
+	}
+	if retention <= 0 {
+		return time.Time{}
+	}
+	return m.SentTimestamp.Add(time.Duration(retention) * time.Second)
+}
+
+// DeliveryEvent is a single entry in a message's delivery history
+type DeliveryEvent struct {
+	Event string    `json:"event"` // received, visibility_expired, moved_to_dlq
+	Time  time.Time `json:"time"`
+}
+
+// recordEvent appends to the message's delivery history when debugging is enabled,
+// dropping the oldest entries once the history is full
+func (m *Message) recordEvent(event string, at time.Time) {
+	if !activeConfig.Server.Debug {
+		return
+	}
+	m.History = append(m.History, DeliveryEvent{Event: event, Time: at})
+	if len(m.History) > maxDeliveryHistory {
+		m.History = m.History[len(m.History)-maxDeliveryHistory:]
+	}
+}
+
+// setSystemAttribute sets a system attribute, or removes it when value is
+// empty. The map is copied first because fan-out copies of a message share it.
+func (m *Message) setSystemAttribute(name, value string) {
+	attrs := make(map[string]string, len(m.MessageSystemAttributes)+1)
+	for k, v := range m.MessageSystemAttributes {
+		attrs[k] = v
+	}
+	if value == "" {
+		delete(attrs, name)
+	} else {
+		attrs[name] = value
+	}
+	m.MessageSystemAttributes = attrs
+}
+
+// Message states, as counted by the ApproximateNumberOfMessages* attributes
+const (
+	messageVisible  = "visible"
+	messageInFlight = "in_flight"
+	messageDelayed  = "delayed"
+)
+
+// state classifies the message the way SQS counts it: a message is delayed
+// until its delay has passed, even if a visibility timeout is also running,
+// then in flight (not visible) while that timeout lasts, and visible after that
+func (m *Message) state(now time.Time) string {
+	switch {
+	case now.Before(m.DelayUntil):
+		return messageDelayed
+	case now.Before(m.VisibilityTimeout):
+		return messageInFlight
+	default:
+		return messageVisible
+	}
 }
 
 // Queue represents an SQS queue
@@ -41,9 +163,20 @@ type Queue struct {
 	Name       string
 	URL        string
 	Attributes map[string]string
-	Messages   []*Message
+	Messages   []*Message // in send order; deleted messages leave nil slots
 	mu         sync.RWMutex
 
+	// messageIndex maps a message ID to its slot in Messages, so deletes and
+	// receipt handle lookups don't scan the queue. Deleting a message leaves
+	// a nil tombstone in its slot, which compactLocked later squeezes out.
+	messageIndex map[string]int
+	tombstones   int
+
+	// Receive scheduling for standard queues, see ready.go
+	ready     []*Message
+	readyHead int
+	wakeups   wakeupHeap
+
 	// Queue configuration
 	VisibilityTimeout      int // seconds
 	MessageRetentionPeriod int // seconds
@@ -51,25 +184,89 @@ type Queue struct {
 	DelaySeconds           int
 	ReceiveMessageWaitTime int // seconds (long polling)
 	MaxReceiveCount        int // maximum receive count before DLQ (if configured)
+	MaxVisibilityTimeout   int // seconds, ceiling for per-request visibility timeouts
+	MaxLongPollWaiters     int // concurrent long-polling receives allowed, 0 for unlimited
+	DeduplicationWindow    int // seconds a FIFO queue remembers a deduplication ID
+	MaxMessages            int // messages the queue may hold before sends fail, 0 for unlimited
+
+	// StrictOrdering keeps a standard queue in send order, as
+	// server.order_by_sent_time does for every standard queue
+	StrictOrdering bool
+
+	// RateLimit throttles the queue's sends and receives; see allowRequest
+	RateLimit    RateLimitConfig
+	rateLimiters map[string]*tokenBucket // by kind of action, "send" or "receive"
+
+	// Trigger is the webhook the queue's messages are delivered to, if any
+	Trigger *TriggerConfig
 
 	// FIFO configuration
 	FifoQueue                 bool
 	ContentBasedDeduplication bool
-	deduplicationCache        map[string]time.Time // deduplicationId -> timestamp
+	DeduplicationScope        string                         // queue or messageGroup; empty means queue
+	FifoThroughputLimit       string                         // perQueue or perMessageGroupId; empty means perQueue
+	deduplicationCache        map[string]deduplicationRecord // by deduplication ID, see send
 	sequenceNumber            int64
+	sequenceBase              uint64 // see nextSequenceNumberLocked
 
 	// DLQ configuration
 	RedrivePolicy      *RedrivePolicy
 	RedriveAllowPolicy *RedriveAllowPolicy
 
+	// Policy is the queue's access policy document, see policy.go
+	Policy string
+
 	// Background processing
-	stopChan chan struct{}
+	stopChan        chan struct{}
+	longPollWaiters int           // receives currently long polling
+	visibleSignal   chan struct{} // closed when a message may have become visible, see notifyLocked
+	lastPurge       time.Time     // when PurgeQueue last succeeded
+	triggerRunning  bool          // whether the trigger worker is running
+
+	// Cumulative counters since the queue was created. Unlike the approximate
+	// gauges these only grow, which separates churn from depth.
+	SentCount     int64
+	ReceivedCount int64
+	DeletedCount  int64
+	DLQMovedCount int64
+
+	// EmptyReceiveCount counts ReceiveMessage calls that returned no
+	// messages, long polls counting once however often they looked
+	EmptyReceiveCount int64
+
+	// manager owns this queue and is used to resolve dead-letter queues
+	manager *QueueManager
+
+	// clock tells the queue the time, see clock.go
+	clock Clock
 }
 
 // RedrivePolicy defines Dead Letter Queue configuration
 type RedrivePolicy struct {
 	DeadLetterTargetArn string `json:"deadLetterTargetArn"`
-	MaxReceiveCount     int    `json:"maxReceiveCount"`
+	MaxReceiveCount     int    `json:"maxReceiveCount,omitempty"` // 0 defers to the queue's MaxReceiveCount
+}
+
+// defaultMaxReceiveCount is the MaxReceiveCount of a queue that doesn't set one
+const defaultMaxReceiveCount = 3
+
+// redrivePolicyLocked returns the queue's redrive policy with the receive
+// count that actually applies, or nil if it has no dead-letter queue. The
+// policy's own maxReceiveCount takes precedence; a policy without one falls
+// back to the queue's MaxReceiveCount, from max_receive_count in the config
+// or the MaxReceiveCount attribute. The caller must hold the queue lock.
+func (q *Queue) redrivePolicyLocked() *RedrivePolicy {
+	if q.RedrivePolicy == nil {
+		return nil
+	}
+	policy := *q.RedrivePolicy
+	if policy.MaxReceiveCount == 0 {
+		policy.MaxReceiveCount = q.MaxReceiveCount
+	}
+	if policy.MaxReceiveCount <= 0 {
+		policy.MaxReceiveCount = defaultMaxReceiveCount
+	}
+	return &policy
 }
 
 // RedriveAllowPolicy defines which queues can use this as a DLQ
@@ -80,17 +277,65 @@ type RedriveAllowPolicy struct {
 
 // QueueManager manages all queues
 type QueueManager struct {
-	queues map[string]*Queue
-	mu     sync.RWMutex
+	queues     map[string]*Queue
+	mu         sync.RWMutex
+	pathPrefix string // URL path prefix of the instance, empty for the default instance
+
+	// subscriptions maps a source queue to the queues that receive a copy of
+	// every message sent to it
+	subscriptions map[string][]string
+
+	// clock is handed to every queue the manager creates
+	clock Clock
 }
 
-// NewQueueManager creates a new queue manager
-func NewQueueManager() *QueueManager {
+// NewQueueManager creates a new queue manager whose queue URLs live under pathPrefix
+func NewQueueManager(pathPrefix string) *QueueManager {
 	return &QueueManager{
-		queues: make(map[string]*Queue),
+		queues:     make(map[string]*Queue),
+		pathPrefix: pathPrefix,
+		clock:      realClock{},
 	}
 }
 
+// SetClock makes c the clock of the manager and all of its queues
+func (qm *QueueManager) SetClock(c Clock) {
+	qm.mu.Lock()
+	qm.clock = c
+	queues := make([]*Queue, 0, len(qm.queues))
+	for _, queue := range qm.queues {
+		queues = append(queues, queue)
+	}
+	qm.mu.Unlock()
+
+	for _, queue := range queues {
+		queue.mu.Lock()
+		queue.clock = c
+		queue.mu.Unlock()
+	}
+}
+
+// AdvanceClock moves the time seen by the manager's queues forward by d,
+// switching them to an offsetClock first if needed, and then runs the
+// background checks so messages whose visibility timeout or retention period
+// has now passed are dealt with right away. It returns the total offset.
+func (qm *QueueManager) AdvanceClock(d time.Duration) time.Duration {
+	qm.mu.RLock()
+	clock, ok := qm.clock.(*offsetClock)
+	base := qm.clock
+	qm.mu.RUnlock()
+	if !ok {
+		clock = &offsetClock{base: base}
+		qm.SetClock(clock)
+	}
+	offset := clock.Advance(d)
+
+	for _, queue := range qm.GetAllQueues() {
+		queue.runBackgroundChecks()
+	}
+	return offset
+}
+
 // CreateQueue creates a new queue
 func (qm *QueueManager) CreateQueue(name string, attributes map[string]string) (*Queue, error) {
 	qm.mu.Lock()
@@ -100,57 +345,402 @@ func (qm *QueueManager) CreateQueue(name string, attributes map[string]string) (
 		return qm.queues[name], nil // Return existing queue
 	}
 
-	queue := &Queue{
+	queue := newQueue(name, qm.pathPrefix+"/"+activeConfig.Server.AccountID+"/"+name, qm)
+	if attributes != nil {
+		queue.Attributes = attributes
+	}
+
+	// A .fifo name makes a FIFO queue even without the FifoQueue attribute,
+	// but SQS requires the suffix on every FIFO queue and only there, so an
+	// attribute that contradicts the name is rejected
+	hasFifoSuffix := strings.HasSuffix(name, ".fifo")
+	if fifoAttr, ok := attributes["FifoQueue"]; ok {
+		switch {
+		case fifoAttr == "true" && !hasFifoSuffix:
+			return nil, &QueueError{"InvalidParameterValue",
+				"The name of a FIFO queue must end with the .fifo suffix"}
+		case fifoAttr != "true" && hasFifoSuffix:
+			return nil, &QueueError{"InvalidParameterValue",
+				"A queue whose name ends with .fifo must have the FifoQueue attribute set to true"}
+		}
+	}
+	queue.FifoQueue = hasFifoSuffix
+
+	lookup := func(name string) (*Queue, bool) {
+		queue, exists := qm.queues[name]
+		return queue, exists
+	}
+	if err := queue.applyAttributes(attributes, lookup); err != nil {
+		return nil, err
+	}
+
+	// Start background goroutine to check visibility timeouts and DLQ
+	go queue.backgroundChecker()
+
+	qm.queues[name] = queue
+	slog.Info("queue created", "queue", name, "fifo", queue.FifoQueue)
+	return queue, nil
+}
+
+// newQueue returns a queue with the default settings and all of its internal
+// state initialized. Every code path that builds a Queue should go through it.
+// The defaults come from server.defaults in the configuration.
+func newQueue(name, url string, manager *QueueManager) *Queue {
+	defaults := activeConfig.Server.Defaults
+	return &Queue{
 		Name:                   name,
-		URL:                    "/" + name,
-		Attributes:             attributes,
+		URL:                    url,
+		Attributes:             make(map[string]string),
 		Messages:               make([]*Message, 0),
-		VisibilityTimeout:      30,     // default 30 seconds
-		MessageRetentionPeriod: 345600, // default 4 days
-		MaximumMessageSize:     262144, // default 256 KB
+		VisibilityTimeout:      defaults.VisibilityTimeout,
+		MessageRetentionPeriod: defaults.MessageRetentionPeriod,
+		MaximumMessageSize:     defaults.MaximumMessageSize,
 		DelaySeconds:           0,
-		ReceiveMessageWaitTime: 0,
-		MaxReceiveCount:        3, // default max receive count
-		deduplicationCache:     make(map[string]time.Time),
+		ReceiveMessageWaitTime: defaults.ReceiveMessageWaitTime,
+		MaxReceiveCount:        defaultMaxReceiveCount,
+		MaxVisibilityTimeout:   43200, // default 12 hours, the AWS maximum
+		DeduplicationWindow:    defaultDeduplicationWindow,
+		deduplicationCache:     make(map[string]deduplicationRecord),
+		messageIndex:           make(map[string]int),
 		sequenceNumber:         0,
 		stopChan:               make(chan struct{}),
+		manager:                manager,
+		clock:                  managerClock(manager),
 	}
+}
 
-	// Start background goroutine to check visibility timeouts and DLQ
-	go queue.backgroundChecker()
+// managerClock returns the clock of the queue manager, or the wall clock if
+// there is no manager
+func managerClock(manager *QueueManager) Clock {
+	if manager == nil || manager.clock == nil {
+		return realClock{}
+	}
+	return manager.clock
+}
+
+// now returns the current time by the queue's clock. The caller must hold the
+// queue lock.
+func (q *Queue) now() time.Time {
+	if q.clock == nil {
+		return time.Now()
+	}
+	return q.clock.Now()
+}
+
+// Now returns the current time by the queue's clock
+func (q *Queue) Now() time.Time {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.now()
+}
+
+// sequenceNumberOffset puts every sequence number in the 20-digit range, so
+// they look like the ones SQS returns and compare the same as strings and as
+// numbers
+const sequenceNumberOffset uint64 = 10_000_000_000_000_000_000
+
+// nextSequenceNumberLocked returns the sequence number of the next message
+// sent to the queue. Messages aren't kept across restarts, so instead of a
+// saved value the numbers start from the time the queue first took a
+// message, in nanoseconds: a queue created again after a restart carries on
+// above every number it handed out before. The caller must hold the queue
+// lock.
+func (q *Queue) nextSequenceNumberLocked() string {
+	if q.sequenceBase == 0 {
+		q.sequenceBase = sequenceNumberOffset + uint64(q.now().UnixNano())
+	}
+	q.sequenceNumber++
+	return fmt.Sprintf("%020d", q.sequenceBase+uint64(q.sequenceNumber))
+}
+
+// ensureInitialized lazily creates internal state that is missing because the
+// queue was built without newQueue. The caller must hold the queue lock.
+func (q *Queue) ensureInitialized() {
+	if q.deduplicationCache == nil {
+		q.deduplicationCache = make(map[string]deduplicationRecord)
+	}
+	if q.Attributes == nil {
+		q.Attributes = make(map[string]string)
+	}
+	if q.stopChan == nil {
+		q.stopChan = make(chan struct{})
+	}
+	if q.messageIndex == nil {
+		q.messageIndex = make(map[string]int, len(q.Messages))
+		for i, msg := range q.Messages {
+			if msg != nil {
+				q.messageIndex[msg.MessageID] = i
+			}
+		}
+	}
+}
+
+// appendLocked adds a message to the end of the queue. The caller must hold
+// the queue lock.
+func (q *Queue) appendLocked(msg *Message) {
+	q.ensureInitialized()
+	q.messageIndex[msg.MessageID] = len(q.Messages)
+	q.Messages = append(q.Messages, msg)
+	q.scheduleLocked(msg, q.now())
+}
+
+// orderedBySentTime reports whether the queue is a standard queue kept in
+// send order, either by server.order_by_sent_time or by its own
+// strict_ordering setting. The caller must hold the queue lock.
+func (q *Queue) orderedBySentTime() bool {
+	return !q.FifoQueue && (activeConfig.Server.OrderBySentTime || q.StrictOrdering)
+}
+
+// insertLocked adds a message moved in from another queue. Usually it goes
+// to the end, like a new message, but a standard queue kept in send order
+// slots it in by SentTimestamp so the queue stays in send order. The caller
+// must hold the queue lock.
+func (q *Queue) insertLocked(msg *Message) {
+	if !q.orderedBySentTime() {
+		q.appendLocked(msg)
+		return
+	}
+	q.ensureInitialized()
+	q.compactLocked()
+	i := sort.Search(len(q.Messages), func(i int) bool {
+		return q.Messages[i].SentTimestamp.After(msg.SentTimestamp)
+	})
+	q.Messages = slices.Insert(q.Messages, i, msg)
+	for j := i; j < len(q.Messages); j++ {
+		q.messageIndex[q.Messages[j].MessageID] = j
+	}
+	q.scheduleLocked(msg, q.now())
+}
+
+// removeLocked removes the message in slot i in constant time by leaving a
+// tombstone there. The caller must hold the queue lock.
+func (q *Queue) removeLocked(i int) {
+	delete(q.messageIndex, q.Messages[i].MessageID)
+	q.Messages[i] = nil
+	q.tombstones++
+}
+
+// compactLocked squeezes the tombstones out of Messages, keeping the order of
+// the remaining messages, and rebuilds the receive schedule once stale
+// entries dominate it. The caller must hold the queue lock.
+func (q *Queue) compactLocked() {
+	if q.tombstones > 0 {
+		live := make([]*Message, 0, len(q.Messages)-q.tombstones)
+		for _, msg := range q.Messages {
+			if msg != nil {
+				q.messageIndex[msg.MessageID] = len(live)
+				live = append(live, msg)
+			}
+		}
+		q.Messages = live
+		q.tombstones = 0
+	}
+	if q.scheduledLocked() > 2*len(q.Messages) {
+		q.rescheduleAllLocked(q.now())
+	}
+}
+
+// applyAttributes parses the DelaySeconds, FIFO and DLQ related attributes and applies them
+// to the queue. Nothing is changed if any of the attributes are invalid.
+// lookup resolves queue names so the dead-letter target can be validated.
+func (q *Queue) applyAttributes(attributes map[string]string, lookup func(string) (*Queue, bool)) error {
+	delaySeconds := -1
+	if delayStr, ok := attributes["DelaySeconds"]; ok {
+		delay, err := strconv.Atoi(delayStr)
+		if err != nil || delay < 0 || delay > 900 {
+			return fmt.Errorf("invalid DelaySeconds: must be an integer from 0 to 900")
+		}
+		delaySeconds = delay
+	}
+
+	// An empty RedrivePolicy detaches the queue from its dead-letter queue
+	var redrivePolicy *RedrivePolicy
+	redrivePolicyStr, setRedrivePolicy := attributes["RedrivePolicy"]
+	if redrivePolicyStr != "" {
+		policy, err := parseRedrivePolicy(redrivePolicyStr)
+		if err != nil {
+			return err
+		}
+		if err := checkDeadLetterTarget(policy, q.Name, q.FifoQueue, lookup); err != nil {
+			if !activeConfig.Server.WarnOnInvalidDLQ {
+				return err
+			}
+			slog.Warn("invalid redrive policy", "queue", q.Name, "error", err)
+		}
+		redrivePolicy = policy
+	}
+
+	var redriveAllowPolicy *RedriveAllowPolicy
+	if redriveAllowPolicyStr, ok := attributes["RedriveAllowPolicy"]; ok && redriveAllowPolicyStr != "" {
+		policy, err := parseRedriveAllowPolicy(redriveAllowPolicyStr)
+		if err != nil {
+			return err
+		}
+		redriveAllowPolicy = policy
+	}
 
-	// Check if this is a FIFO queue (by name or by attribute)
-	if len(name) > 5 && name[len(name)-5:] == ".fifo" {
-		queue.FifoQueue = true
+	policy, setPolicy := attributes["Policy"]
+	if err := checkPolicy(policy); err != nil {
+		return err
 	}
-	if fifoAttr, ok := attributes["FifoQueue"]; ok && fifoAttr == "true" {
-		queue.FifoQueue = true
+
+	if err := checkEncryptionAttributes(attributes); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// The high throughput attributes are checked together, since one limits
+	// what the other may be
+	scope, setScope := attributes["DeduplicationScope"]
+	throughputLimit, setThroughputLimit := attributes["FifoThroughputLimit"]
+	if setScope || setThroughputLimit {
+		if !q.FifoQueue {
+			return fmt.Errorf("DeduplicationScope and FifoThroughputLimit are only valid for FIFO queues")
+		}
+		if !setScope {
+			scope = q.deduplicationScope()
+		}
+		if !setThroughputLimit {
+			throughputLimit = q.fifoThroughputLimit()
+		}
+		if scope != "queue" && scope != "messageGroup" {
+			return fmt.Errorf("invalid DeduplicationScope %q: must be queue or messageGroup", scope)
+		}
+		if throughputLimit != "perQueue" && throughputLimit != "perMessageGroupId" {
+			return fmt.Errorf("invalid FifoThroughputLimit %q: must be perQueue or perMessageGroupId", throughputLimit)
+		}
+		if throughputLimit == "perMessageGroupId" && scope != "messageGroup" {
+			return fmt.Errorf("FifoThroughputLimit perMessageGroupId requires DeduplicationScope messageGroup")
+		}
 	}
 
 	// Parse FIFO attributes
-	if contentBased, ok := attributes["ContentBasedDeduplication"]; ok && contentBased == "true" {
-		queue.ContentBasedDeduplication = true
+	if contentBased, ok := attributes["ContentBasedDeduplication"]; ok {
+		q.ContentBasedDeduplication = contentBased == "true"
+	}
+	if setScope || setThroughputLimit {
+		q.DeduplicationScope = scope
+		q.FifoThroughputLimit = throughputLimit
+	}
+
+	if delaySeconds >= 0 {
+		q.DelaySeconds = delaySeconds
 	}
 
 	// Parse MaxReceiveCount
 	if maxReceiveStr, ok := attributes["MaxReceiveCount"]; ok {
 		if maxReceive, err := strconv.Atoi(maxReceiveStr); err == nil && maxReceive > 0 {
-			queue.MaxReceiveCount = maxReceive
+			q.MaxReceiveCount = maxReceive
 		}
 	}
 
-	// Parse RedrivePolicy
-	if redrivePolicyStr, ok := attributes["RedrivePolicy"]; ok {
-		queue.RedrivePolicy = parseRedrivePolicy(redrivePolicyStr)
+	if setRedrivePolicy {
+		q.RedrivePolicy = redrivePolicy
+	}
+	if redriveAllowPolicy != nil {
+		q.RedriveAllowPolicy = redriveAllowPolicy
 	}
+	if setPolicy {
+		q.Policy = policy
+	}
+	for _, name := range encryptionAttributes {
+		if value, ok := attributes[name]; ok {
+			q.Attributes[name] = value
+		}
+	}
+
+	return nil
+}
 
-	// Parse RedriveAllowPolicy
-	if redriveAllowPolicyStr, ok := attributes["RedriveAllowPolicy"]; ok {
-		queue.RedriveAllowPolicy = parseRedriveAllowPolicy(redriveAllowPolicyStr)
+// encryptionAttributes are the server-side encryption attributes. Messages
+// aren't encrypted, but the attributes are kept and returned as set so that
+// tools comparing them against their own configuration see no drift.
+var encryptionAttributes = []string{"KmsMasterKeyId", "KmsDataKeyReusePeriodSeconds", "SqsManagedSseEnabled"}
+
+// checkEncryptionAttributes rejects encryption attribute values SQS wouldn't
+// accept. KmsMasterKeyId isn't checked, since any key ID or alias is fine
+// when nothing is encrypted with it.
+func checkEncryptionAttributes(attributes map[string]string) error {
+	if value, ok := attributes["KmsDataKeyReusePeriodSeconds"]; ok {
+		if seconds, err := strconv.Atoi(value); err != nil || seconds < 60 || seconds > 86400 {
+			return fmt.Errorf("invalid KmsDataKeyReusePeriodSeconds: must be an integer from 60 to 86400")
+		}
 	}
+	if value, ok := attributes["SqsManagedSseEnabled"]; ok && value != "true" && value != "false" {
+		return fmt.Errorf("invalid SqsManagedSseEnabled: must be true or false")
+	}
+	return nil
+}
 
-	qm.queues[name] = queue
-	return queue, nil
+// SetAttributes changes the queue's attributes, as SetQueueAttributes does.
+// It accepts the same attributes as CreateQueue, except that FifoQueue can't
+// be changed and the other FIFO attributes are only valid on FIFO queues.
+// Errors are *QueueError.
+func (q *Queue) SetAttributes(attributes map[string]string) error {
+	if _, ok := attributes["FifoQueue"]; ok {
+		return &QueueError{"InvalidAttributeName", "FifoQueue can't be changed after the queue is created"}
+	}
+	for _, name := range []string{"ContentBasedDeduplication", "DeduplicationScope", "FifoThroughputLimit"} {
+		if _, ok := attributes[name]; ok && !q.FifoQueue {
+			return &QueueError{"InvalidAttributeName", name + " is only valid for FIFO queues"}
+		}
+	}
+
+	if err := q.applyAttributes(attributes, q.manager.GetQueue); err != nil {
+		var queueErr *QueueError
+		if errors.As(err, &queueErr) {
+			return err
+		}
+		return &QueueError{"InvalidParameterValue", err.Error()}
+	}
+	slog.Info("queue attributes set", "queue", q.Name, "attributes", len(attributes))
+	return nil
+}
+
+// Settings returns the queue's configurable settings as strings, keyed by
+// attribute name, so that configuration changes can be compared
+func (q *Queue) Settings() map[string]string {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	settings := map[string]string{
+		"VisibilityTimeout":         strconv.Itoa(q.VisibilityTimeout),
+		"MessageRetentionPeriod":    strconv.Itoa(q.MessageRetentionPeriod),
+		"MaximumMessageSize":        strconv.Itoa(q.MaximumMessageSize),
+		"MaxReceiveCount":           strconv.Itoa(q.MaxReceiveCount),
+		"MaxVisibilityTimeout":      strconv.Itoa(q.MaxVisibilityTimeout),
+		"DelaySeconds":              strconv.Itoa(q.DelaySeconds),
+		"ReceiveMessageWaitTime":    strconv.Itoa(q.ReceiveMessageWaitTime),
+		"MaxLongPollWaiters":        strconv.Itoa(q.MaxLongPollWaiters),
+		"DeduplicationWindow":       strconv.Itoa(q.DeduplicationWindow),
+		"MaxMessages":               strconv.Itoa(q.MaxMessages),
+		"StrictOrdering":            strconv.FormatBool(q.StrictOrdering),
+		"SendRateLimit":             strconv.FormatFloat(q.RateLimit.SendPerSecond, 'g', -1, 64),
+		"ReceiveRateLimit":          strconv.FormatFloat(q.RateLimit.ReceivePerSecond, 'g', -1, 64),
+		"FifoQueue":                 strconv.FormatBool(q.FifoQueue),
+		"ContentBasedDeduplication": strconv.FormatBool(q.ContentBasedDeduplication),
+		"DeduplicationScope":        q.DeduplicationScope,
+		"FifoThroughputLimit":       q.FifoThroughputLimit,
+		"RedrivePolicy":             "",
+		"RedriveAllowPolicy":        "",
+		"Trigger":                   "",
+	}
+	if q.RedrivePolicy != nil {
+		if policy, err := json.Marshal(q.RedrivePolicy); err == nil {
+			settings["RedrivePolicy"] = string(policy)
+		}
+	}
+	if q.Trigger != nil {
+		settings["Trigger"] = fmt.Sprintf("%s (batch size %d)", q.Trigger.URL, q.Trigger.BatchSize)
+	}
+	if q.RedriveAllowPolicy != nil {
+		if policy, err := json.Marshal(q.RedriveAllowPolicy); err == nil {
+			settings["RedriveAllowPolicy"] = string(policy)
+		}
+	}
+	return settings
 }
 
 // GetQueue retrieves a queue by name
@@ -161,31 +751,98 @@ func (qm *QueueManager) GetQueue(name string) (*Queue, bool) {
 	return queue, exists
 }
 
+// SetSubscriptions replaces the fan-out subscriptions of this manager's queues
+func (qm *QueueManager) SetSubscriptions(subscriptions map[string][]string) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	qm.subscriptions = subscriptions
+}
+
+// fanOut copies a message sent to source into each of its subscriber queues,
+// the way SNS raw message delivery would. Copies get new message IDs but keep
+// the body and attributes, and are not fanned out again.
+func (qm *QueueManager) fanOut(source string, msg *Message) {
+	qm.mu.RLock()
+	destinations := qm.subscriptions[source]
+	qm.mu.RUnlock()
+
+	for _, name := range destinations {
+		dest, exists := qm.GetQueue(name)
+		if !exists {
+			slog.Warn("subscriber queue does not exist, not copying message",
+				"queue", source, "subscriber", name, "message_id", msg.MessageID)
+			continue
+		}
+
+		var deduplicationId, groupId string
+		if dest.FifoQueue {
+			deduplicationId = msg.MessageDeduplicationId
+			if deduplicationId == "" {
+				deduplicationId = msg.MessageID
+			}
+			groupId = msg.MessageGroupId
+		}
+		copied, _, err := dest.send(msg.bodyText(), msg.MessageAttributes, msg.MessageSystemAttributes, 0, deduplicationId, groupId)
+		if err != nil {
+			slog.Warn("failed to copy message to subscriber queue",
+				"queue", source, "subscriber", name, "message_id", msg.MessageID, "error", err)
+			continue
+		}
+		slog.Debug("message copied to subscriber queue",
+			"queue", source, "subscriber", name, "message_id", msg.MessageID, "copy_message_id", copied.MessageID)
+	}
+}
+
 // DeleteQueue removes a queue
 func (qm *QueueManager) DeleteQueue(name string) bool {
 	qm.mu.Lock()
 	defer qm.mu.Unlock()
 	if queue, exists := qm.queues[name]; exists {
-		// Stop background checker
-		close(queue.stopChan)
+		// Stop the background checker and wake any receives long polling
+		// the queue, which then return an empty result
+		if queue.stopChan != nil {
+			close(queue.stopChan)
+		}
 		delete(qm.queues, name)
+		slog.Info("queue deleted", "queue", name)
 		return true
 	}
 	return false
 }
 
-// ListQueues returns all queue URLs
-func (qm *QueueManager) ListQueues(prefix string) []string {
+// ListQueues returns the URLs of queues whose names start with prefix, sorted by
+// name. When maxResults is positive at most that many URLs are returned starting
+// at offset, along with the offset of the next page (0 when no more remain).
+func (qm *QueueManager) ListQueues(prefix string, offset, maxResults int) ([]string, int) {
 	qm.mu.RLock()
 	defer qm.mu.RUnlock()
 
-	urls := make([]string, 0)
-	for name, queue := range qm.queues {
-		if prefix == "" || len(name) >= len(prefix) && name[:len(prefix)] == prefix {
-			urls = append(urls, queue.URL)
+	names := make([]string, 0)
+	for name := range qm.queues {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
 		}
 	}
-	return urls
+	sort.Strings(names)
+
+	if offset > len(names) {
+		offset = len(names)
+	}
+	end := len(names)
+	if maxResults > 0 && offset+maxResults < end {
+		end = offset + maxResults
+	}
+
+	urls := make([]string, 0, end-offset)
+	for _, name := range names[offset:end] {
+		urls = append(urls, qm.queues[name].URL)
+	}
+
+	nextOffset := 0
+	if end < len(names) {
+		nextOffset = end
+	}
+	return urls, nextOffset
 }
 
 // GetAllQueues returns all queues (for admin UI)
@@ -200,55 +857,168 @@ func (qm *QueueManager) GetAllQueues() []*Queue {
 	return queues
 }
 
-// SendMessage adds a message to the queue
-func (q *Queue) SendMessage(body string, attributes map[string]interface{}, delaySeconds int, deduplicationId, groupId string) *Message {
+// DeadLetterSources returns the sorted names of the queues whose
+// RedrivePolicy uses the named queue as their dead-letter queue
+func (qm *QueueManager) DeadLetterSources(dlqName string) []string {
+	var sources []string
+	for _, queue := range qm.GetAllQueues() {
+		queue.mu.RLock()
+		if queue.RedrivePolicy != nil && extractQueueNameFromArn(queue.RedrivePolicy.DeadLetterTargetArn) == dlqName {
+			sources = append(sources, queue.Name)
+		}
+		queue.mu.RUnlock()
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// SendMessage adds a message to the queue and copies it to any queues
+// subscribed to this one
+func (q *Queue) SendMessage(body string, attributes map[string]interface{}, systemAttributes map[string]string, delaySeconds int, deduplicationId, groupId string) (*Message, error) {
+	msg, duplicate, err := q.send(body, attributes, systemAttributes, delaySeconds, deduplicationId, groupId)
+	if err != nil || duplicate {
+		return msg, err
+	}
+	if q.manager != nil {
+		q.manager.fanOut(q.Name, msg)
+	}
+	return msg, nil
+}
+
+// send adds a message to the queue. For a FIFO send within the deduplication
+// window it returns the original message with duplicate set instead. That
+// message is only a record of the original, which may have been deleted since,
+// with no body or attributes, so it is good for answering the send and no more.
+func (q *Queue) send(body string, attributes map[string]interface{}, systemAttributes map[string]string, delaySeconds int, deduplicationId, groupId string) (msg *Message, duplicate bool, err error) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
+	return q.sendLocked(body, attributes, systemAttributes, delaySeconds, deduplicationId, groupId)
+}
+
+// sendLocked is send for a caller that holds the queue lock
+func (q *Queue) sendLocked(body string, attributes map[string]interface{}, systemAttributes map[string]string, delaySeconds int, deduplicationId, groupId string) (msg *Message, duplicate bool, err error) {
+	q.ensureInitialized()
+
+	if delaySeconds < 0 || delaySeconds > 900 {
+		return nil, false, &QueueError{"InvalidParameterValue", "DelaySeconds must be between 0 and 900 seconds"}
+	}
+	if c, invalid := invalidBodyCharacter(body); invalid {
+		return nil, false, &QueueError{"InvalidMessageContents",
+			fmt.Sprintf("Invalid binary character '#x%X' was found in the message body, the set of allowed characters is "+
+				"#x9 | #xA | #xD | #x20 to #xD7FF | #xE000 to #xFFFD | #x10000 to #x10FFFF", c)}
+	}
+	if err := checkMessageAttributes(attributes); err != nil {
+		return nil, false, err
+	}
+	if q.MaximumMessageSize > 0 && messageSize(body, attributes) > q.MaximumMessageSize {
+		return nil, false, &QueueError{"InvalidParameterValue",
+			fmt.Sprintf("One or more parameters are invalid. Reason: Message must be shorter than %d bytes.", q.MaximumMessageSize)}
+	}
+	retentionPeriod := 0
+	if activeConfig.Server.Lenient {
+		if retentionPeriod, err = messageRetentionPeriod(attributes); err != nil {
+			return nil, false, err
+		}
+	}
+	if delaySeconds == 0 {
+		// No per-message delay, so the queue's default applies
+		delaySeconds = q.DelaySeconds
+	} else if q.FifoQueue {
+		return nil, false, &QueueError{"InvalidParameterValue",
+			"FIFO queues don't support per-message DelaySeconds; set DelaySeconds on the queue instead"}
+	}
+
+	now := q.now()
 
 	// Handle FIFO deduplication
+	var cacheKey string
 	if q.FifoQueue {
 		// Determine deduplication ID
-		if deduplicationId == "" && q.ContentBasedDeduplication {
-			deduplicationId = calculateMD5(body)
-		}
-
-		// Check deduplication cache (5-minute window)
-		if deduplicationId != "" {
-			if lastSent, exists := q.deduplicationCache[deduplicationId]; exists {
-				if time.Since(lastSent) < 5*time.Minute {
-					// Find and return the existing message
-					for _, msg := range q.Messages {
-						if msg.MessageDeduplicationId == deduplicationId {
-							return msg
-						}
-					}
-				}
+		if deduplicationId == "" {
+			if !q.ContentBasedDeduplication {
+				return nil, false, &QueueError{"InvalidParameterValue",
+					"The queue should either have ContentBasedDeduplication enabled or MessageDeduplicationId provided explicitly"}
 			}
-			q.deduplicationCache[deduplicationId] = time.Now()
+			deduplicationId = contentDeduplicationId(body, attributes)
+		}
+
+		// Check deduplication cache. With the messageGroup scope of high
+		// throughput mode, an ID only deduplicates within its message group.
+		cacheKey = deduplicationId
+		perGroup := q.deduplicationScope() == "messageGroup"
+		if perGroup {
+			cacheKey = groupId + "\x00" + deduplicationId
+		}
+		if record, exists := q.deduplicationCache[cacheKey]; exists && now.Sub(record.sentAt) < q.deduplicationWindow() {
+			return record.original, true, nil
 		}
 	}
 
-	q.sequenceNumber++
-	sequenceNum := strconv.FormatInt(q.sequenceNumber, 10)
+	// An emulator extension: a full queue turns sends away, like a backed-up
+	// downstream. A deduplicated send above doesn't add a message, so it
+	// still succeeds.
+	if q.MaxMessages > 0 && len(q.Messages)-q.tombstones >= q.MaxMessages {
+		return nil, false, &QueueError{"OverLimit", fmt.Sprintf(
+			"The queue holds its maximum of %d messages", q.MaxMessages)}
+	}
 
-	msg := &Message{
-		MessageID:              uuid.New().String(),
-		Body:                   body,
-		MD5OfBody:              calculateMD5(body),
-		MessageAttributes:      attributes,
-		SentTimestamp:          time.Now(),
-		ReceiveCount:           0,
-		DelayUntil:             time.Now().Add(time.Duration(delaySeconds) * time.Second),
-		MessageDeduplicationId: deduplicationId,
-		MessageGroupId:         groupId,
-		SequenceNumber:         sequenceNum,
+	// Past every check, the message is certain to be enqueued. Only now does
+	// it take its deduplication ID and, for FIFO messages, the next sequence
+	// number, so that a rejected send leaves no gap in the sequence and
+	// doesn't suppress its own retry.
+	var sequenceNum string
+	if q.FifoQueue {
+		sequenceNum = q.nextSequenceNumberLocked()
 	}
 
-	q.Messages = append(q.Messages, msg)
-	return msg
+	msg = &Message{
+		MessageID:               uuid.New().String(),
+		Body:                    body,
+		MD5OfBody:               calculateMD5(body),
+		MessageAttributes:       attributes,
+		MessageSystemAttributes: systemAttributes,
+		SentTimestamp:           now,
+		ReceiveCount:            0,
+		DelayUntil:              now.Add(time.Duration(delaySeconds) * time.Second),
+		MessageDeduplicationId:  deduplicationId,
+		MessageGroupId:          groupId,
+		SequenceNumber:          sequenceNum,
+		RetentionPeriod:         retentionPeriod,
+	}
+	msg.compressBody()
+	if q.FifoQueue {
+		q.deduplicationCache[cacheKey] = newDeduplicationRecord(msg)
+	}
+
+	q.appendLocked(msg)
+	q.SentCount++
+	slog.Debug("message sent", "queue", q.Name, "message_id", msg.MessageID)
+	q.publishLocked(eventSent, msg, "")
+	return msg, false, nil
+}
+
+// RestoreInFlight sends a message that was in flight when it was exported
+// and keeps it in flight for hiddenFor, as though whoever received it before
+// still held it. It isn't fanned out to subscribers, since it isn't a new
+// message. Errors are the same as for SendMessage.
+func (q *Queue) RestoreInFlight(body string, attributes map[string]interface{}, systemAttributes map[string]string, deduplicationId, groupId string, hiddenFor time.Duration) (*Message, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msg, duplicate, err := q.sendLocked(body, attributes, systemAttributes, 0, deduplicationId, groupId)
+	if err != nil || duplicate {
+		return msg, err
+	}
+	now := q.now()
+	msg.VisibilityTimeout = now.Add(hiddenFor)
+	q.scheduleLocked(msg, now)
+	msg.recordEvent("restored_in_flight", now)
+	return msg, nil
 }
 
-// backgroundChecker runs every second to check for expired visibility timeouts and move messages to DLQ
+// backgroundChecker runs every second to check for expired visibility
+// timeouts, move messages to DLQ and delete messages past their retention
+// period
 func (q *Queue) backgroundChecker() {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
@@ -256,211 +1026,852 @@ func (q *Queue) backgroundChecker() {
 	for {
 		select {
 		case <-ticker.C:
-			q.checkVisibilityTimeoutsAndDLQ()
+			q.runBackgroundChecks()
 		case <-q.stopChan:
 			return
 		}
 	}
 }
 
-// checkVisibilityTimeoutsAndDLQ checks for messages with expired visibility timeouts that should move to DLQ
-func (q *Queue) checkVisibilityTimeoutsAndDLQ() {
+// runBackgroundChecks does one round of the background checker's work
+func (q *Queue) runBackgroundChecks() {
+	q.checkVisibilityTimeoutsAndDLQ()
 	q.mu.Lock()
 	defer q.mu.Unlock()
-
-	if q.RedrivePolicy == nil {
-		return // No DLQ configured
+	now := q.now()
+	q.expireMessagesLocked(now)
+	q.evictDeduplicationLocked(now)
+	q.compactLocked()
+
+	// Delays and visibility timeouts run out without any call to the queue,
+	// so waiting receives are woken to check for them
+	if q.longPollWaiters > 0 {
+		q.notifyLocked()
 	}
+}
 
-	now := time.Now()
-	messagesToMove := make([]*Message, 0)
+// deduplicationRecord is what a FIFO queue remembers about a deduplication ID
+// for the deduplication window: when the message that took it was sent, and
+// enough of that message to answer a duplicate send exactly as the original
+// send was answered, even after the message has been deleted.
+type deduplicationRecord struct {
+	sentAt   time.Time
+	original *Message // never modified, and without the body
+}
 
-	for _, msg := range q.Messages {
-		// Check if message is currently visible (visibility timeout has expired)
-		if now.After(msg.VisibilityTimeout) && now.After(msg.DelayUntil) {
-			// If message has been received MaxReceiveCount times or more, move to DLQ
-			if msg.ReceiveCount >= q.RedrivePolicy.MaxReceiveCount {
-				// Log for debugging
-				log.Printf("[DLQ] Queue %s: Moving message %s to DLQ (ReceiveCount=%d, MaxReceiveCount=%d, VisibilityTimeout=%v, Now=%v)",
-					q.Name, msg.MessageID, msg.ReceiveCount, q.RedrivePolicy.MaxReceiveCount, msg.VisibilityTimeout, now)
-				messagesToMove = append(messagesToMove, msg)
-			}
-		}
+// newDeduplicationRecord records msg as the message that took its
+// deduplication ID. Only the fields a send response is made from are kept, so
+// the cache doesn't hold on to message bodies.
+func newDeduplicationRecord(msg *Message) deduplicationRecord {
+	return deduplicationRecord{
+		sentAt: msg.SentTimestamp,
+		original: &Message{
+			MessageID:               msg.MessageID,
+			MD5OfBody:               msg.MD5OfBody,
+			MessageSystemAttributes: msg.MessageSystemAttributes,
+			SentTimestamp:           msg.SentTimestamp,
+			MessageDeduplicationId:  msg.MessageDeduplicationId,
+			MessageGroupId:          msg.MessageGroupId,
+			SequenceNumber:          msg.SequenceNumber,
+		},
 	}
+}
 
-	// Move messages to DLQ
-	for _, msg := range messagesToMove {
-		q.moveToDLQ(msg)
+// evictDeduplicationLocked forgets deduplication IDs whose window has passed,
+// so the cache doesn't grow without bound. The caller must hold the queue
+// lock.
+func (q *Queue) evictDeduplicationLocked(now time.Time) {
+	window := q.deduplicationWindow()
+	for id, record := range q.deduplicationCache {
+		if now.Sub(record.sentAt) >= window {
+			delete(q.deduplicationCache, id)
+		}
 	}
 }
 
-// ReceiveMessages retrieves messages from the queue
-func (q *Queue) ReceiveMessages(maxMessages int, visibilityTimeout int, waitTimeSeconds int) []*Message {
-	q.mu.Lock()
-	defer q.mu.Unlock()
+// deduplicationScope returns the DeduplicationScope of a FIFO queue. The
+// caller must hold the queue lock.
+func (q *Queue) deduplicationScope() string {
+	if q.DeduplicationScope == "" {
+		return "queue"
+	}
+	return q.DeduplicationScope
+}
 
-	now := time.Now()
+// fifoThroughputLimit returns the FifoThroughputLimit of a FIFO queue. The
+// caller must hold the queue lock.
+func (q *Queue) fifoThroughputLimit() string {
+	if q.FifoThroughputLimit == "" {
+		return "perQueue"
+	}
+	return q.FifoThroughputLimit
+}
+
+// deduplicationWindow returns how long the queue remembers a deduplication
+// ID. The caller must hold the queue lock.
+func (q *Queue) deduplicationWindow() time.Duration {
+	if q.DeduplicationWindow <= 0 {
+		return defaultDeduplicationWindow * time.Second
+	}
+	return time.Duration(q.DeduplicationWindow) * time.Second
+}
+
+// checkVisibilityTimeoutsAndDLQ checks for messages with expired visibility timeouts that should move to DLQ
+func (q *Queue) checkVisibilityTimeoutsAndDLQ() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+
+	// Record visibility expiry for messages whose last event was a receive
+	if activeConfig.Server.Debug {
+		for _, msg := range q.Messages {
+			if msg == nil {
+				continue
+			}
+			if n := len(msg.History); n > 0 && (msg.History[n-1].Event == "received" || msg.History[n-1].Event == "visibility_changed") &&
+				!now.Before(msg.VisibilityTimeout) {
+				msg.recordEvent("visibility_expired", msg.VisibilityTimeout)
+			}
+		}
+	}
+
+	policy := q.redrivePolicyLocked()
+	if policy == nil {
+		return // No DLQ configured
+	}
+
+	messagesToMove := make([]*Message, 0)
+
+	for _, msg := range q.Messages {
+		// Check if message is currently visible (visibility timeout has expired)
+		if msg != nil && msg.state(now) == messageVisible {
+			// If message has been received MaxReceiveCount times or more, move to DLQ
+			if msg.ReceiveCount >= policy.MaxReceiveCount {
+				slog.Info("moving message to DLQ", "queue", q.Name, "message_id", msg.MessageID,
+					"receive_count", msg.ReceiveCount, "max_receive_count", policy.MaxReceiveCount,
+					"visibility_timeout", msg.VisibilityTimeout)
+				messagesToMove = append(messagesToMove, msg)
+			}
+		}
+	}
+
+	// Move messages to DLQ
+	for _, msg := range messagesToMove {
+		q.moveToDLQ(msg)
+	}
+}
+
+// expireMessagesLocked deletes the messages that have outlived their
+// retention period, whatever their state, as SQS does. The caller must hold
+// the queue lock.
+func (q *Queue) expireMessagesLocked(now time.Time) {
+	for i, msg := range q.Messages {
+		if msg == nil {
+			continue
+		}
+		if expiresAt := msg.expiresAt(q.MessageRetentionPeriod); !expiresAt.IsZero() && !now.Before(expiresAt) {
+			slog.Debug("message expired", "queue", q.Name, "message_id", msg.MessageID,
+				"sent_timestamp", msg.SentTimestamp)
+			q.removeLocked(i)
+			q.publishLocked(eventExpired, msg, "")
+		}
+	}
+}
+
+// longPollInterval is how often a long-polling receive checks for messages
+// when nothing wakes it, so a delay or visibility timeout running out between
+// background checks is noticed promptly
+const longPollInterval = 50 * time.Millisecond
+
+// LongPoll calls receive until it returns messages or an error, or
+// waitTimeSeconds pass, the request is canceled, or the queue is deleted. It
+// tries again whenever notifyLocked signals that a message may have become
+// visible, and every longPollInterval. When the queue already has
+// MaxLongPollWaiters receives waiting, it gives up right away. A
+// waitTimeSeconds of 0 is a short poll: receive is called exactly once and
+// its result returned, without counting as a waiter or starting any timers,
+// so short polls cost no more than the receive itself.
+func (q *Queue) LongPoll(ctx context.Context, waitTimeSeconds int, receive func() ([]*Message, error)) ([]*Message, error) {
+	messages, err := receive()
+	if len(messages) > 0 || err != nil || waitTimeSeconds <= 0 {
+		return messages, err
+	}
+
+	q.mu.Lock()
+	if q.MaxLongPollWaiters > 0 && q.longPollWaiters >= q.MaxLongPollWaiters {
+		q.mu.Unlock()
+		slog.Warn("long poll waiter limit reached, returning immediately", "queue", q.Name,
+			"max_long_poll_waiters", q.MaxLongPollWaiters)
+		return messages, nil
+	}
+	q.ensureInitialized()
+	q.longPollWaiters++
+	stop := q.stopChan
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		q.longPollWaiters--
+		q.mu.Unlock()
+	}()
+
+	deadline := time.NewTimer(time.Duration(waitTimeSeconds) * time.Second)
+	defer deadline.Stop()
+	ticker := time.NewTicker(longPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Take the signal before receiving, so a message that becomes
+		// visible after the receive still wakes this one
+		q.mu.Lock()
+		signal := q.visibleSignalLocked()
+		q.mu.Unlock()
+		if messages, err = receive(); len(messages) > 0 || err != nil {
+			return messages, err
+		}
+
+		select {
+		case <-signal:
+		case <-ticker.C:
+		case <-deadline.C:
+			return receive()
+		case <-ctx.Done():
+			return messages, nil
+		case <-stop:
+			return messages, nil
+		}
+	}
+}
+
+// PeekMessages returns copies of up to maxMessages of the queue's messages in
+// queue order, whatever their state, or of all of them if maxMessages is 0.
+// Unlike ReceiveMessages it changes nothing, so looking at a queue doesn't
+// touch receive counts, receipt handles or visibility timeouts.
+func (q *Queue) PeekMessages(maxMessages int) []Message {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.peekMessagesLocked(maxMessages)
+}
+
+// peekMessagesLocked does the work of PeekMessages. The caller must hold the
+// queue lock.
+func (q *Queue) peekMessagesLocked(maxMessages int) []Message {
+	messages := make([]Message, 0)
+	for _, msg := range q.Messages {
+		if msg == nil {
+			continue
+		}
+		if maxMessages > 0 && len(messages) >= maxMessages {
+			break
+		}
+		// The attribute maps are never modified in place, but the history
+		// may be appended to
+		peeked := *msg
+		peeked.History = slices.Clone(msg.History)
+		messages = append(messages, peeked)
+	}
+	return messages
+}
+
+// ReceiveMessages retrieves messages from the queue. It fails with OverLimit
+// when the queue already has the most messages in flight it may have, and
+// otherwise returns no more than would bring it up to that limit.
+func (q *Queue) ReceiveMessages(maxMessages int, visibilityTimeout int) ([]*Message, error) {
+	return q.receive(maxMessages, visibilityTimeout, "")
+}
+
+// ReceiveFromGroup receives the oldest message of one message group of a
+// FIFO queue, instead of letting the queue pick the groups. It returns nil if
+// the group has no messages or its oldest message is in flight or delayed,
+// since a group's later messages can't be received before its head. This is
+// an emulator extension for reproducing ordering problems; SQS can't receive
+// from a chosen group.
+func (q *Queue) ReceiveFromGroup(groupId string, visibilityTimeout int) (*Message, error) {
+	if !q.FifoQueue {
+		return nil, &QueueError{"InvalidParameterValue", "Only FIFO queues have message groups"}
+	}
+	messages, err := q.receive(1, visibilityTimeout, groupId)
+	if err != nil || len(messages) == 0 {
+		return nil, err
+	}
+	return messages[0], nil
+}
+
+// receive implements ReceiveMessages and ReceiveFromGroup. An empty groupId
+// lets selectAvailable choose the messages.
+func (q *Queue) receive(maxMessages int, visibilityTimeout int, groupId string) ([]*Message, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// Cap the visibility timeout at the queue's ceiling
+	if q.MaxVisibilityTimeout > 0 && visibilityTimeout > q.MaxVisibilityTimeout {
+		slog.Debug("clamping visibility timeout", "queue", q.Name,
+			"visibility_timeout", visibilityTimeout, "max_visibility_timeout", q.MaxVisibilityTimeout)
+		visibilityTimeout = q.MaxVisibilityTimeout
+	}
+
+	now := q.now()
+	if limit := maxInFlightMessages(q.FifoQueue); limit > 0 {
+		// Counting means a scan, which can be skipped while the queue
+		// doesn't even hold that many messages
+		if live := len(q.Messages) - q.tombstones; live >= limit {
+			inFlight := q.countsLocked(now).NotVisible
+			if inFlight >= limit {
+				return nil, &QueueError{"OverLimit", fmt.Sprintf(
+					"The maximum number of in flight messages (%d) for this queue has been reached", limit)}
+			}
+			maxMessages = min(maxMessages, limit-inFlight)
+		}
+	}
+	var available []*Message
+	if groupId != "" {
+		available = q.groupHeadLocked(now, groupId)
+	} else {
+		available = q.selectAvailable(now, maxMessages)
+	}
+
+	// Mark messages as invisible and set receipt handles. A timeout of 0
+	// leaves a message visible: its timeout ends at this very instant, and
+	// a message is only in flight strictly before its timeout ends.
+	for _, msg := range available {
+		msg.VisibilityTimeout = now.Add(time.Duration(visibilityTimeout) * time.Second)
+		msg.ReceiptHandle = newReceiptHandle(q.Name, msg.MessageID, msg.VisibilityTimeout)
+		q.scheduleLocked(msg, now)
+		msg.ReceiveCount++
+		q.ReceivedCount++
+		if msg.ReceiveCount == 1 {
+			msg.FirstReceivedTime = now
+		}
+		msg.recordEvent("received", now)
+		slog.Debug("message received", "queue", q.Name, "message_id", msg.MessageID,
+			"receive_count", msg.ReceiveCount, "visible_at", msg.VisibilityTimeout)
+	}
+	for _, msg := range available {
+		q.publishLocked(eventReceived, msg, "")
+	}
+
+	return available, nil
+}
+
+// ReceiveAndDeleteMessages retrieves messages and removes them from the queue
+// in a single step. The messages are never put in flight, so they do not count
+// toward a redrive policy. This is an emulator extension for drain-style consumers.
+func (q *Queue) ReceiveAndDeleteMessages(maxMessages int) []*Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	available := q.selectAvailable(q.now(), maxMessages)
+	if len(available) == 0 {
+		return available
+	}
+
+	for _, msg := range available {
+		q.removeLocked(q.messageIndex[msg.MessageID])
+	}
+	q.ReceivedCount += int64(len(available))
+	q.DeletedCount += int64(len(available))
+
+	for _, msg := range available {
+		slog.Debug("message received and deleted", "queue", q.Name, "message_id", msg.MessageID)
+		q.publishLocked(eventReceived, msg, "")
+		q.publishLocked(eventDeleted, msg, "")
+	}
+
+	return available
+}
+
+// selectAvailable picks up to maxMessages messages that are currently visible.
+// The caller must hold the queue lock.
+func (q *Queue) selectAvailable(now time.Time, maxMessages int) []*Message {
 	available := make([]*Message, 0)
 
 	if q.FifoQueue {
-		// For FIFO queues, group messages by MessageGroupId and return in order
-		groupMap := make(map[string][]*Message)
+		// FIFO queues return at most one message per group, so a receive
+		// spreads across as many groups as possible. Groups are taken in the
+		// order of their oldest message, and a group whose oldest message is
+		// in flight or delayed is skipped entirely so later messages in it
+		// can't overtake the head.
+		seenGroups := make(map[string]bool)
 		for _, msg := range q.Messages {
-			if now.After(msg.DelayUntil) && now.After(msg.VisibilityTimeout) {
-				groupId := msg.MessageGroupId
-				if groupId == "" {
-					groupId = "default"
-				}
-				groupMap[groupId] = append(groupMap[groupId], msg)
+			if msg == nil {
+				continue
 			}
-		}
+			groupId := msg.MessageGroupId
+			if groupId == "" {
+				groupId = "default"
+			}
+			if seenGroups[groupId] {
+				continue
+			}
+			seenGroups[groupId] = true
 
-		// Return messages from each group in order, one message per group
-		for _, msgs := range groupMap {
-			if len(msgs) > 0 {
-				available = append(available, msgs[0])
+			if msg.state(now) == messageVisible {
+				available = append(available, msg)
 				if len(available) >= maxMessages {
 					break
 				}
 			}
 		}
-	} else {
-		// Standard queue: return messages in any order
+	} else if q.orderedBySentTime() {
+		// Standard queue kept in send order: scan for the oldest visible
+		// messages rather than taking them in the order they became visible
 		for _, msg := range q.Messages {
-			if now.After(msg.DelayUntil) && now.After(msg.VisibilityTimeout) {
+			if msg != nil && msg.state(now) == messageVisible {
 				available = append(available, msg)
 				if len(available) >= maxMessages {
 					break
 				}
 			}
 		}
+	} else {
+		// Standard queue: return messages in any order
+		available = q.takeReadyLocked(now, maxMessages)
 	}
 
-	// Mark messages as invisible and set receipt handles
-	for _, msg := range available {
-		msg.ReceiptHandle = uuid.New().String()
-		msg.VisibilityTimeout = now.Add(time.Duration(visibilityTimeout) * time.Second)
-		msg.ReceiveCount++
-		if msg.ReceiveCount == 1 {
-			msg.FirstReceivedTime = now
+	return available
+}
+
+// groupHeadLocked returns the oldest message of a FIFO message group if it is
+// visible. The caller must hold the queue lock.
+func (q *Queue) groupHeadLocked(now time.Time, groupId string) []*Message {
+	for _, msg := range q.Messages {
+		if msg == nil {
+			continue
 		}
-		log.Printf("[RECEIVE] Queue %s: Message %s received (ReceiveCount=%d, VisibilityTimeout set to %v, timeout param=%ds)",
-			q.Name, msg.MessageID, msg.ReceiveCount, msg.VisibilityTimeout, visibilityTimeout)
+		msgGroupId := msg.MessageGroupId
+		if msgGroupId == "" {
+			msgGroupId = "default"
+		}
+		if msgGroupId != groupId {
+			continue
+		}
+		if msg.state(now) != messageVisible {
+			return nil
+		}
+		return []*Message{msg}
 	}
+	return nil
+}
 
-	return available
+// DeleteMessage removes a message from the queue. The returned error is a
+// *QueueError telling apart a malformed handle, a handle issued by another
+// queue, an expired handle, and a message that is no longer in this queue.
+func (q *Queue) DeleteMessage(receiptHandle string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i, err := q.findInFlight(receiptHandle, q.now())
+	if err != nil {
+		return err
+	}
+	msg := q.Messages[i]
+	slog.Debug("message deleted", "queue", q.Name, "message_id", msg.MessageID,
+		"receive_count", msg.ReceiveCount)
+	q.removeLocked(i)
+	q.DeletedCount++
+	q.publishLocked(eventDeleted, msg, "")
+	return nil
 }
 
-// DeleteMessage removes a message from the queue
-func (q *Queue) DeleteMessage(receiptHandle string) bool {
+// maxTotalVisibility is how long after it was first received a message can
+// be kept in flight by extending its visibility timeout
+const maxTotalVisibility = 12 * time.Hour
+
+// ChangeMessageVisibility sets the visibility timeout of an in-flight message
+// to visibilityTimeout seconds from now. A timeout of 0 makes it visible
+// again immediately, and is accepted even past the cap. As in SQS, a timeout
+// that would keep the message in flight beyond 12 hours after it was first
+// received fails with InvalidParameterValue. Other errors are the same as
+// for DeleteMessage.
+func (q *Queue) ChangeMessageVisibility(receiptHandle string, visibilityTimeout int) error {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
-	for i, msg := range q.Messages {
-		if msg.ReceiptHandle == receiptHandle {
-			// Remove message
-			q.Messages = append(q.Messages[:i], q.Messages[i+1:]...)
-			return true
+	now := q.now()
+	i, err := q.findInFlight(receiptHandle, now)
+	if err != nil {
+		return err
+	}
+
+	if q.MaxVisibilityTimeout > 0 && visibilityTimeout > q.MaxVisibilityTimeout {
+		visibilityTimeout = q.MaxVisibilityTimeout
+	}
+	msg := q.Messages[i]
+	visibleAt := now.Add(time.Duration(visibilityTimeout) * time.Second)
+	if visibilityTimeout > 0 && visibleAt.After(msg.FirstReceivedTime.Add(maxTotalVisibility)) {
+		return &QueueError{"InvalidParameterValue", fmt.Sprintf(
+			"Value %d for parameter VisibilityTimeout is invalid. Reason: Total VisibilityTimeout for the message is beyond the limit [%d seconds]",
+			visibilityTimeout, int(maxTotalVisibility/time.Second))}
+	}
+	msg.VisibilityTimeout = visibleAt
+	q.scheduleLocked(msg, now)
+	msg.recordEvent("visibility_changed", now)
+	return nil
+}
+
+// ResetVisibility makes every in-flight message visible again immediately,
+// invalidating its receipt handle, and returns how many were reset. Delayed
+// messages keep their delay.
+func (q *Queue) ResetVisibility() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	reset := 0
+	for _, msg := range q.Messages {
+		if msg == nil {
+			continue
 		}
+		if msg.state(now) == messageInFlight {
+			reset++
+			msg.recordEvent("visibility_reset", now)
+		}
+		msg.VisibilityTimeout = time.Time{}
+		msg.ReceiptHandle = ""
 	}
-	return false
+	q.rescheduleAllLocked(now)
+	if reset > 0 {
+		slog.Debug("visibility reset", "queue", q.Name, "messages", reset)
+	}
+	return reset
+}
+
+// Reset returns the queue to the state it had when it was created, keeping
+// only its configuration: messages, in-flight or not, the deduplication
+// cache, the sequence number, the PurgeQueue cooldown, rate limits and the
+// cumulative counters are all cleared. Unlike PurgeQueue a FIFO queue will
+// take deduplication IDs it has already seen. It returns how many messages
+// the queue held.
+func (q *Queue) Reset() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	count := len(q.messageIndex)
+	q.Messages = make([]*Message, 0)
+	q.messageIndex = make(map[string]int)
+	q.tombstones = 0
+	q.deduplicationCache = make(map[string]deduplicationRecord)
+	// The next message picks a new base, so its sequence number is still
+	// above every number handed out before the reset
+	q.sequenceNumber = 0
+	q.sequenceBase = 0
+	q.lastPurge = time.Time{}
+	q.rateLimiters = nil
+	q.SentCount = 0
+	q.ReceivedCount = 0
+	q.DeletedCount = 0
+	q.DLQMovedCount = 0
+	q.EmptyReceiveCount = 0
+	q.rescheduleAllLocked(q.now())
+	slog.Info("queue reset", "queue", q.Name, "messages", count)
+	return count
+}
+
+// findInFlight returns the index of the in-flight message that receiptHandle
+// was issued for. The caller must hold the queue lock.
+func (q *Queue) findInFlight(receiptHandle string, now time.Time) (int, error) {
+	handle, ok := parseReceiptHandle(receiptHandle)
+	if !ok {
+		return 0, &QueueError{"ReceiptHandleIsInvalid", "The input receipt handle is invalid."}
+	}
+	if handle.QueueName != q.Name {
+		return 0, &QueueError{"ReceiptHandleIsInvalid",
+			fmt.Sprintf("The receipt handle was issued by queue %s, not %s.", handle.QueueName, q.Name)}
+	}
+
+	if i, ok := q.messageIndex[handle.MessageID]; ok {
+		msg := q.Messages[i]
+		if msg.ReceiptHandle != receiptHandle {
+			return 0, &QueueError{"ReceiptHandleIsInvalid",
+				"The receipt handle has expired; the message has been received again since it was issued."}
+		}
+		// The message's own timeout is authoritative, as it may have been
+		// changed since the handle was issued
+		if !now.Before(msg.VisibilityTimeout) {
+			return 0, &QueueError{"ReceiptHandleIsInvalid", "The receipt handle has expired."}
+		}
+		return i, nil
+	}
+
+	if !now.Before(handle.Expiry) {
+		return 0, &QueueError{"ReceiptHandleIsInvalid", "The receipt handle has expired."}
+	}
+	return 0, &QueueError{"ReceiptHandleIsInvalid",
+		fmt.Sprintf("Message %s is no longer in queue %s; it was already deleted or moved to a dead-letter queue.", handle.MessageID, q.Name)}
 }
 
-// PurgeQueue removes all messages
-func (q *Queue) PurgeQueue() {
+// PurgeQueue removes all messages. Like SQS, it refuses to purge a queue
+// again within the purge cooldown.
+func (q *Queue) PurgeQueue() error {
 	q.mu.Lock()
 	defer q.mu.Unlock()
+
+	now := q.now()
+	cooldown := purgeCooldown()
+	if !q.lastPurge.IsZero() && now.Sub(q.lastPurge) < cooldown {
+		return &QueueError{"AWS.SimpleQueueService.PurgeQueueInProgress",
+			fmt.Sprintf("Only one PurgeQueue operation on %s is allowed every %v.", q.Name, cooldown)}
+	}
+
 	q.Messages = make([]*Message, 0)
+	q.messageIndex = make(map[string]int)
+	q.tombstones = 0
+	q.rescheduleAllLocked(now)
+	q.lastPurge = now
+	return nil
 }
 
+// counterAttributePrefix prefixes the cumulative counters returned by
+// GetQueueAttributes
+const counterAttributePrefix = "EssQueueEss."
+
 // GetAttributes returns queue attributes
 func (q *Queue) GetAttributes() map[string]string {
 	q.mu.RLock()
 	defer q.mu.RUnlock()
 
-	now := time.Now()
-	visibleCount := 0
-	notVisibleCount := 0
-	delayedCount := 0
+	now := q.now()
+	counts := q.countsLocked(now)
 
-	for _, msg := range q.Messages {
-		if now.Before(msg.DelayUntil) {
-			delayedCount++
-		} else if now.Before(msg.VisibilityTimeout) {
-			notVisibleCount++
-		} else {
-			visibleCount++
+	attrs := make(map[string]string)
+	attrs["ApproximateNumberOfMessages"] = strconv.Itoa(counts.Visible)
+	attrs["ApproximateNumberOfMessagesNotVisible"] = strconv.Itoa(counts.NotVisible)
+	attrs["ApproximateNumberOfMessagesDelayed"] = strconv.Itoa(counts.Delayed)
+	attrs["ApproximateAgeOfOldestMessage"] = strconv.Itoa(q.oldestVisibleAgeLocked(now))
+	attrs["QueueArn"] = queueArn(q.Name)
+	if q.FifoQueue {
+		attrs["DeduplicationScope"] = q.deduplicationScope()
+		attrs["FifoThroughputLimit"] = q.fifoThroughputLimit()
+	}
+	if q.Policy != "" {
+		attrs["Policy"] = q.Policy
+	}
+	for _, name := range encryptionAttributes {
+		if value, ok := q.Attributes[name]; ok {
+			attrs[name] = value
 		}
 	}
 
-	attrs := make(map[string]string)
-	attrs["ApproximateNumberOfMessages"] = strconv.Itoa(visibleCount)
-	attrs["ApproximateNumberOfMessagesNotVisible"] = strconv.Itoa(notVisibleCount)
-	attrs["ApproximateNumberOfMessagesDelayed"] = strconv.Itoa(delayedCount)
-	attrs["QueueArn"] = "arn:aws:sqs:us-east-1:000000000000:" + q.Name
+	// Emulator extensions, prefixed so they can't clash with SQS attribute names
+	attrs[counterAttributePrefix+"NumberOfMessagesSent"] = strconv.FormatInt(q.SentCount, 10)
+	attrs[counterAttributePrefix+"NumberOfMessagesReceived"] = strconv.FormatInt(q.ReceivedCount, 10)
+	attrs[counterAttributePrefix+"NumberOfMessagesDeleted"] = strconv.FormatInt(q.DeletedCount, 10)
+	attrs[counterAttributePrefix+"NumberOfMessagesMovedToDLQ"] = strconv.FormatInt(q.DLQMovedCount, 10)
+	attrs[counterAttributePrefix+"NumberOfEmptyReceives"] = strconv.FormatInt(q.EmptyReceiveCount, 10)
 
 	return attrs
 }
 
-// moveToDLQ moves a message to the dead letter queue
-func (q *Queue) moveToDLQ(msg *Message) {
-	if q.RedrivePolicy == nil {
+// RecordEmptyReceive counts a ReceiveMessage call that returned no messages
+func (q *Queue) RecordEmptyReceive() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.EmptyReceiveCount++
+}
+
+// QueueMetrics is a snapshot of a queue's activity, named after the SQS
+// metrics CloudWatch publishes. The NumberOf counters are cumulative since the
+// queue was created or reset rather than per period.
+type QueueMetrics struct {
+	NumberOfMessagesSent                  int64 `json:"NumberOfMessagesSent"`
+	NumberOfMessagesReceived              int64 `json:"NumberOfMessagesReceived"`
+	NumberOfMessagesDeleted               int64 `json:"NumberOfMessagesDeleted"`
+	NumberOfEmptyReceives                 int64 `json:"NumberOfEmptyReceives"`
+	ApproximateNumberOfMessagesVisible    int   `json:"ApproximateNumberOfMessagesVisible"`
+	ApproximateNumberOfMessagesNotVisible int   `json:"ApproximateNumberOfMessagesNotVisible"`
+	ApproximateNumberOfMessagesDelayed    int   `json:"ApproximateNumberOfMessagesDelayed"`
+	ApproximateAgeOfOldestMessage         int   `json:"ApproximateAgeOfOldestMessage"` // seconds
+}
+
+// Metrics returns the queue's metrics, all taken at the same instant
+func (q *Queue) Metrics() QueueMetrics {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	now := q.now()
+	counts := q.countsLocked(now)
+	return QueueMetrics{
+		NumberOfMessagesSent:                  q.SentCount,
+		NumberOfMessagesReceived:              q.ReceivedCount,
+		NumberOfMessagesDeleted:               q.DeletedCount,
+		NumberOfEmptyReceives:                 q.EmptyReceiveCount,
+		ApproximateNumberOfMessagesVisible:    counts.Visible,
+		ApproximateNumberOfMessagesNotVisible: counts.NotVisible,
+		ApproximateNumberOfMessagesDelayed:    counts.Delayed,
+		ApproximateAgeOfOldestMessage:         q.oldestVisibleAgeLocked(now),
+	}
+}
+
+// Counts returns how many of the queue's messages are visible, in flight and
+// delayed. Every message is classified against the same instant while the
+// queue is locked, so the three always add up to the number of messages the
+// queue held at that instant. GetQueueAttributes and the admin API both
+// count this way so that they agree.
+func (q *Queue) Counts() (visible, notVisible, delayed int) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	counts := q.countsLocked(q.now())
+	return counts.Visible, counts.NotVisible, counts.Delayed
+}
+
+// countsLocked tallies the queue's messages by state. The caller must hold
+// the queue lock.
+func (q *Queue) countsLocked(now time.Time) QueueCounts {
+	var counts QueueCounts
+	for _, msg := range q.Messages {
+		if msg == nil {
+			continue
+		}
+		switch msg.state(now) {
+		case messageDelayed:
+			counts.Delayed++
+		case messageInFlight:
+			counts.NotVisible++
+		default:
+			counts.Visible++
+		}
+	}
+	return counts
+}
+
+// oldestVisibleAgeLocked returns the age in whole seconds of the oldest
+// visible message, or 0 when none is visible. Like the CloudWatch metric it
+// ignores in-flight and delayed messages. The caller must hold the queue lock.
+func (q *Queue) oldestVisibleAgeLocked(now time.Time) int {
+	var oldest time.Time
+	for _, msg := range q.Messages {
+		if msg != nil && msg.state(now) == messageVisible && (oldest.IsZero() || msg.SentTimestamp.Before(oldest)) {
+			oldest = msg.SentTimestamp
+		}
+	}
+	if oldest.IsZero() {
+		return 0
+	}
+	return int(now.Sub(oldest) / time.Second)
+}
+
+// publishLocked publishes an event about a message in this queue. The caller
+// must hold the queue lock.
+func (q *Queue) publishLocked(eventType string, msg *Message, dlq string) {
+	if !queueEvents.HasSubscribers() {
 		return
 	}
+	now := q.now()
+	queueEvents.Publish(QueueEvent{
+		Type:      eventType,
+		Queue:     q.Name,
+		MessageID: msg.MessageID,
+		DLQ:       dlq,
+		Time:      now,
+		Counts:    q.countsLocked(now),
+	})
+}
+
+// MoveToDLQ moves the message with the given ID to the queue's dead-letter
+// queue right away, whatever its state or receive count, as if it had been
+// received too often. It reports false if the queue has no such message, and
+// fails if the queue has no redrive policy or its DLQ won't take the message.
+func (q *Queue) MoveToDLQ(messageID string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i, ok := q.messageIndex[messageID]
+	if !ok {
+		return false, nil
+	}
+	if q.RedrivePolicy == nil {
+		return true, fmt.Errorf("queue %s has no RedrivePolicy", q.Name)
+	}
+	return true, q.moveToDLQ(q.Messages[i])
+}
 
-	// Extract DLQ name from ARN
-	dlqName := extractQueueNameFromArn(q.RedrivePolicy.DeadLetterTargetArn)
+// moveToDLQ moves a message to the dead letter queue. The caller must hold
+// the queue lock.
+func (q *Queue) moveToDLQ(msg *Message) error {
+	if q.RedrivePolicy == nil {
+		return nil
+	}
 
-	dlq, exists := queueManager.GetQueue(dlqName)
+	dlq, exists := q.manager.GetQueueByArn(q.RedrivePolicy.DeadLetterTargetArn)
 	if !exists {
-		return
+		return fmt.Errorf("dead-letter queue %s does not exist", q.RedrivePolicy.DeadLetterTargetArn)
+	}
+	dlqName := dlq.Name
+	if dlq == q {
+		// Locking the dead-letter queue would deadlock on our own lock
+		slog.Warn("queue is its own dead-letter queue, not moving message",
+			"queue", q.Name, "message_id", msg.MessageID)
+		return fmt.Errorf("queue %s is its own dead-letter queue", q.Name)
+	}
+
+	dlq.mu.RLock()
+	allowed := dlq.RedriveAllowPolicy.allowsSource(queueArn(q.Name))
+	dlq.mu.RUnlock()
+	if !allowed {
+		slog.Warn("DLQ RedriveAllowPolicy does not permit this source queue, not moving message",
+			"queue", q.Name, "message_id", msg.MessageID, "dlq", dlqName)
+		return fmt.Errorf("the RedriveAllowPolicy of %s does not permit messages from %s", dlqName, q.Name)
 	}
 
 	// Remove from current queue
-	for i, m := range q.Messages {
-		if m.MessageID == msg.MessageID {
-			q.Messages = append(q.Messages[:i], q.Messages[i+1:]...)
-			break
-		}
+	if i, ok := q.messageIndex[msg.MessageID]; ok {
+		q.removeLocked(i)
 	}
 
-	// Reset message state for DLQ
+	// Reset message state for DLQ. The receive count and sent timestamp
+	// carry over, and the source queue is recorded as SQS does.
 	msg.ReceiptHandle = ""
 	msg.VisibilityTimeout = time.Time{}
-	msg.DelayUntil = time.Now()
+	msg.DelayUntil = q.now()
+	msg.setSystemAttribute("DeadLetterQueueSourceArn", queueArn(q.Name))
+	msg.recordEvent("moved_to_dlq", msg.DelayUntil)
 
 	// Add to DLQ
 	dlq.mu.Lock()
-	dlq.Messages = append(dlq.Messages, msg)
+	dlq.insertLocked(msg)
 	dlq.mu.Unlock()
+
+	q.DLQMovedCount++
+	q.publishLocked(eventMovedToDLQ, msg, dlqName)
+	return nil
 }
 
 // RedriveMessages moves messages from this DLQ back to the source queue
-func (qm *QueueManager) RedriveMessages(dlqName, sourceQueueArn string, maxMessages int) int {
+func (qm *QueueManager) RedriveMessages(dlqName, sourceQueueArn string, maxMessages int) (int, error) {
 	dlq, exists := qm.GetQueue(dlqName)
 	if !exists {
-		return 0
+		return 0, &QueueError{"ResourceNotFoundException", "Source queue " + dlqName + " does not exist"}
 	}
 
-	sourceQueueName := extractQueueNameFromArn(sourceQueueArn)
-	sourceQueue, exists := qm.GetQueue(sourceQueueName)
+	sourceQueue, exists := qm.GetQueueByArn(sourceQueueArn)
 	if !exists {
-		return 0
+		return 0, &QueueError{"ResourceNotFoundException", "Destination queue " + sourceQueueArn + " does not exist"}
+	}
+	sourceQueueName := sourceQueue.Name
+	if sourceQueue == dlq {
+		// Both locks below would be the same mutex
+		return 0, &QueueError{"InvalidParameterValue", "Can't redrive " + dlqName + " into itself"}
 	}
 
 	dlq.mu.Lock()
 	defer dlq.mu.Unlock()
 
-	movedCount := 0
-	messagesToMove := make([]*Message, 0)
+	if !dlq.RedriveAllowPolicy.allowsSource(sourceQueueArn) {
+		slog.Warn("redrive not permitted by RedriveAllowPolicy, no messages moved", "dlq", dlqName,
+			"destination", sourceQueueName, "messages_not_moved", len(dlq.messageIndex))
+		return 0, fmt.Errorf("the RedriveAllowPolicy of %s does not permit redrive to %s", dlqName, sourceQueueName)
+	}
 
-	for i, msg := range dlq.Messages {
-		if maxMessages > 0 && movedCount >= maxMessages {
-			break
-		}
-		messagesToMove = append(messagesToMove, msg)
-		dlq.Messages = append(dlq.Messages[:i], dlq.Messages[i+1:]...)
-		movedCount++
+	dlq.compactLocked()
+	movedCount := len(dlq.Messages)
+	if maxMessages > 0 && movedCount > maxMessages {
+		movedCount = maxMessages
+	}
+	messagesToMove := append([]*Message(nil), dlq.Messages[:movedCount]...)
+	for i := range messagesToMove {
+		dlq.removeLocked(i)
 	}
 
 	// Move messages to source queue
@@ -468,13 +1879,87 @@ func (qm *QueueManager) RedriveMessages(dlqName, sourceQueueArn string, maxMessa
 	for _, msg := range messagesToMove {
 		msg.ReceiptHandle = ""
 		msg.VisibilityTimeout = time.Time{}
+		// A redriven message starts over as if it were new, so its next
+		// receive stamps ApproximateFirstReceiveTimestamp again
 		msg.ReceiveCount = 0
-		msg.DelayUntil = time.Now()
-		sourceQueue.Messages = append(sourceQueue.Messages, msg)
+		msg.FirstReceivedTime = time.Time{}
+		msg.DelayUntil = sourceQueue.now()
+		msg.setSystemAttribute("DeadLetterQueueSourceArn", "")
+		sourceQueue.insertLocked(msg)
 	}
 	sourceQueue.mu.Unlock()
 
-	return movedCount
+	// Messages beyond maxMessages stay in the DLQ for another task
+	slog.Info("messages redriven", "dlq", dlqName, "destination", sourceQueueName,
+		"moved", movedCount, "remaining", len(dlq.messageIndex))
+	return movedCount, nil
+}
+
+// allowsSource reports whether the queue with the given ARN may use the queue
+// that owns this policy as its dead-letter queue. A nil policy allows all sources.
+func (p *RedriveAllowPolicy) allowsSource(sourceArn string) bool {
+	if p == nil {
+		return true
+	}
+
+	switch p.RedrivePermission {
+	case "denyAll":
+		return false
+	case "byQueue":
+		for _, arn := range p.SourceQueueArns {
+			if arn == sourceArn {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// QueueError is an error that corresponds to an SQS error code
+type QueueError struct {
+	Code    string
+	Message string
+}
+
+func (e *QueueError) Error() string {
+	return e.Message
+}
+
+// receiptHandle is the decoded form of a receipt handle
+type receiptHandle struct {
+	QueueName string
+	MessageID string
+	Expiry    time.Time // when the visibility timeout set by the receive ends
+}
+
+// newReceiptHandle returns an opaque receipt handle identifying one receive of
+// a message: base64 of "queueName:messageID:expiryUnixNano:nonce"
+func newReceiptHandle(queueName, messageID string, expiry time.Time) string {
+	raw := fmt.Sprintf("%s:%s:%d:%s", queueName, messageID, expiry.UnixNano(), uuid.New().String())
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// parseReceiptHandle decodes a receipt handle created by newReceiptHandle
+func parseReceiptHandle(handle string) (receiptHandle, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(handle)
+	if err != nil {
+		return receiptHandle{}, false
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 4 || parts[0] == "" || parts[1] == "" {
+		return receiptHandle{}, false
+	}
+	expiry, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return receiptHandle{}, false
+	}
+	return receiptHandle{
+		QueueName: parts[0],
+		MessageID: parts[1],
+		Expiry:    time.Unix(0, expiry),
+	}, true
 }
 
 // Helper functions
@@ -483,101 +1968,289 @@ func calculateMD5(s string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-func parseRedrivePolicy(policyJSON string) *RedrivePolicy {
-	// Simple JSON parsing for RedrivePolicy
-	// Format: {"deadLetterTargetArn":"arn:aws:sqs:us-east-1:000000000000:my-dlq","maxReceiveCount":3}
-	policy := &RedrivePolicy{}
-
-	// Extract deadLetterTargetArn
-	if start := findJSONValue(policyJSON, "deadLetterTargetArn"); start != "" {
-		policy.DeadLetterTargetArn = start
+// attributesMD5 returns the digest SQS computes over message attributes, as
+// in MD5OfMessageAttributes: for each attribute, sorted by name, the name,
+// data type, a transport type byte (1 for String and Number, 2 for Binary)
+// and value, with the strings and value prefixed by their 4-byte big-endian
+// length. It returns "" when there are no attributes.
+func attributesMD5(attributes map[string]interface{}) string {
+	if len(attributes) == 0 {
+		return ""
 	}
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	// Extract maxReceiveCount
-	if countStr := findJSONValue(policyJSON, "maxReceiveCount"); countStr != "" {
-		if count, err := strconv.Atoi(countStr); err == nil {
-			policy.MaxReceiveCount = count
+	var buf bytes.Buffer
+	writeField := func(b []byte) {
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(len(b))))
+		buf.Write(b)
+	}
+	for _, name := range names {
+		value, _ := attributes[name].(map[string]interface{})
+		dataType, _ := value["DataType"].(string)
+		writeField([]byte(name))
+		writeField([]byte(dataType))
+		if b, ok := value["BinaryValue"].(string); ok {
+			decoded, err := base64.StdEncoding.DecodeString(b)
+			if err != nil {
+				decoded = []byte(b)
+			}
+			buf.WriteByte(2)
+			writeField(decoded)
+		} else {
+			s, _ := value["StringValue"].(string)
+			buf.WriteByte(1)
+			writeField([]byte(s))
 		}
 	}
+	return calculateMD5(buf.String())
+}
 
-	return policy
+// systemAttributesMD5 returns MD5OfMessageSystemAttributes for the string
+// system attributes a sender set, or "" when there are none
+func systemAttributesMD5(attrs map[string]string) string {
+	attributes := make(map[string]interface{}, len(attrs))
+	for name, value := range attrs {
+		attributes[name] = map[string]interface{}{"DataType": "String", "StringValue": value}
+	}
+	return attributesMD5(attributes)
 }
 
-func parseRedriveAllowPolicy(policyJSON string) *RedriveAllowPolicy {
-	policy := &RedriveAllowPolicy{}
+// invalidBodyCharacter returns the first character of a message body that
+// SQS doesn't allow, and whether there is one. Only #x9, #xA, #xD and #x20 to
+// #xD7FF, #xE000 to #xFFFD and #x10000 to #x10FFFF are allowed, so that the
+// body can always be returned in XML. Bytes that aren't valid UTF-8 are
+// reported as U+FFFD. An empty body is allowed.
+func invalidBodyCharacter(body string) (rune, bool) {
+	for i, c := range body {
+		switch {
+		case c == utf8.RuneError:
+			if _, size := utf8.DecodeRuneInString(body[i:]); size == 1 {
+				return c, true
+			}
+		case c == 0x9 || c == 0xA || c == 0xD:
+		case c < 0x20 || (c > 0xD7FF && c < 0xE000) || c == 0xFFFE || c == 0xFFFF:
+			return c, true
+		}
+	}
+	return 0, false
+}
 
-	if permission := findJSONValue(policyJSON, "redrivePermission"); permission != "" {
-		policy.RedrivePermission = permission
+// maxMessageAttributes is the most message attributes a message may have
+const maxMessageAttributes = 10
+
+// numberPattern matches the values SQS accepts for a Number attribute:
+// integers and decimals, optionally signed and with an exponent
+var numberPattern = regexp.MustCompile(`^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+
+// checkMessageAttributes rejects message attributes SQS wouldn't accept: more
+// than maxMessageAttributes of them, a DataType that isn't String, Number or
+// Binary, optionally followed by a custom type such as Number.float, a value
+// missing from the field its type uses, or a Number that isn't a number.
+// Errors are *QueueError.
+func checkMessageAttributes(attributes map[string]interface{}) error {
+	if len(attributes) > maxMessageAttributes {
+		return &QueueError{"InvalidParameterValue", fmt.Sprintf(
+			"Number of message attributes [%d] exceeds the allowed maximum [%d].", len(attributes), maxMessageAttributes)}
 	}
+	for name, v := range attributes {
+		value, ok := v.(map[string]interface{})
+		if !ok {
+			return &QueueError{"InvalidParameterValue", fmt.Sprintf("The message attribute '%s' is malformed.", name)}
+		}
+		dataType, _ := value["DataType"].(string)
+		if dataType == "" {
+			return &QueueError{"InvalidParameterValue", fmt.Sprintf(
+				"The message attribute '%s' must contain non-empty message attribute type.", name)}
+		}
+		base, custom, hasCustom := strings.Cut(dataType, ".")
+		if (base != "String" && base != "Number" && base != "Binary") || (hasCustom && custom == "") || len(dataType) > 256 {
+			return &QueueError{"InvalidParameterValue", fmt.Sprintf(
+				"The type of message (user) attribute '%s' is invalid. You must use only the following supported type prefixes: Binary, Number, String.", name)}
+		}
 
-	return policy
+		// String and Number values go in StringValue, Binary ones in
+		// BinaryValue, and the other field must be left out
+		field, other := "StringValue", "BinaryValue"
+		if base == "Binary" {
+			field, other = other, field
+		}
+		if _, ok := value[other]; ok {
+			return &QueueError{"InvalidParameterValue", fmt.Sprintf(
+				"The message attribute '%s' with type '%s' must use field '%s'.", name, base, strings.TrimSuffix(field, "Value"))}
+		}
+		s, _ := value[field].(string)
+		if s == "" {
+			return &QueueError{"InvalidParameterValue", fmt.Sprintf(
+				"The message (user) attribute '%s' must contain a non-empty value of message attribute type '%s'.", name, base)}
+		}
+		if base == "Number" && !numberPattern.MatchString(s) {
+			return &QueueError{"InvalidParameterValue", fmt.Sprintf(
+				"Can't cast the value of message (user) attribute '%s' to a number.", name)}
+		}
+	}
+	return nil
 }
 
-func findJSONValue(jsonStr, key string) string {
-	// Simple JSON value extraction (not a full parser)
-	keyPattern := "\"" + key + "\""
-	keyIndex := -1
-	for i := 0; i < len(jsonStr)-len(keyPattern); i++ {
-		if jsonStr[i:i+len(keyPattern)] == keyPattern {
-			keyIndex = i + len(keyPattern)
-			break
+// messageSize returns the size of a message as SQS counts it toward
+// MaximumMessageSize: the body plus, for each message attribute, the bytes of
+// its name, data type and value. Binary values count their decoded length.
+func messageSize(body string, attributes map[string]interface{}) int {
+	size := len(body)
+	for name, v := range attributes {
+		size += len(name)
+		value, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		dataType, _ := value["DataType"].(string)
+		size += len(dataType)
+		if s, ok := value["StringValue"].(string); ok {
+			size += len(s)
+		}
+		if b, ok := value["BinaryValue"].(string); ok {
+			if decoded, err := base64.StdEncoding.DecodeString(b); err == nil {
+				size += len(decoded)
+			} else {
+				size += len(b)
+			}
 		}
 	}
+	return size
+}
 
-	if keyIndex == -1 {
-		return ""
+// contentDeduplicationId derives the deduplication ID of a FIFO message on a
+// queue with ContentBasedDeduplication. Like SQS it hashes only the body,
+// unless server.dedup_include_attributes is set, in which case the message
+// attributes are hashed too, sorted by name, so identical bodies with
+// different attributes are not treated as duplicates.
+func contentDeduplicationId(body string, attributes map[string]interface{}) string {
+	if !activeConfig.Server.DedupIncludeAttributes || len(attributes) == 0 {
+		return calculateMD5(body)
 	}
 
-	// Find the colon
-	colonIndex := -1
-	for i := keyIndex; i < len(jsonStr); i++ {
-		if jsonStr[i] == ':' {
-			colonIndex = i
-			break
-		}
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+
+	var content strings.Builder
+	content.WriteString(body)
+	for _, name := range names {
+		// json.Marshal sorts map keys, so equal values encode identically
+		value, _ := json.Marshal(attributes[name])
+		content.WriteString("\x00" + name + "\x00")
+		content.Write(value)
+	}
+	return calculateMD5(content.String())
+}
 
-	if colonIndex == -1 {
-		return ""
+// parseRedrivePolicy parses a RedrivePolicy attribute such as
+// {"deadLetterTargetArn":"arn:aws:sqs:us-east-1:000000000000:my-dlq","maxReceiveCount":3}.
+// maxReceiveCount may be given as either a JSON number or a string.
+func parseRedrivePolicy(policyJSON string) (*RedrivePolicy, error) {
+	var raw struct {
+		DeadLetterTargetArn string      `json:"deadLetterTargetArn"`
+		MaxReceiveCount     json.Number `json:"maxReceiveCount"`
+	}
+	if err := json.Unmarshal([]byte(policyJSON), &raw); err != nil {
+		return nil, fmt.Errorf("invalid RedrivePolicy: %w", err)
 	}
 
-	// Find the value start
-	valueStart := -1
-	isString := false
-	for i := colonIndex + 1; i < len(jsonStr); i++ {
-		if jsonStr[i] == '"' {
-			valueStart = i + 1
-			isString = true
-			break
-		} else if jsonStr[i] >= '0' && jsonStr[i] <= '9' {
-			valueStart = i
-			break
+	if raw.DeadLetterTargetArn == "" {
+		return nil, fmt.Errorf("invalid RedrivePolicy: deadLetterTargetArn is required")
+	}
+	// Unlike SQS, maxReceiveCount may be left out to use the queue's own
+	maxReceiveCount := 0
+	if raw.MaxReceiveCount != "" {
+		var err error
+		maxReceiveCount, err = strconv.Atoi(raw.MaxReceiveCount.String())
+		if err != nil || maxReceiveCount < 1 {
+			return nil, fmt.Errorf("invalid RedrivePolicy: maxReceiveCount must be a positive integer")
 		}
 	}
 
-	if valueStart == -1 {
-		return ""
+	return &RedrivePolicy{
+		DeadLetterTargetArn: raw.DeadLetterTargetArn,
+		MaxReceiveCount:     maxReceiveCount,
+	}, nil
+}
+
+// checkDeadLetterTarget verifies that the policy's dead-letter target exists,
+// isn't the source queue itself and is the same type (standard or FIFO) as
+// the source queue, as AWS requires
+func checkDeadLetterTarget(policy *RedrivePolicy, source string, fifo bool, lookup func(string) (*Queue, bool)) error {
+	region, account, name, ok := parseQueueArn(policy.DeadLetterTargetArn)
+	if !ok || region != awsRegion || account != activeConfig.Server.AccountID {
+		return fmt.Errorf("invalid RedrivePolicy: dead-letter target %s is not a queue ARN of this server", policy.DeadLetterTargetArn)
 	}
+	if name == source {
+		return &QueueError{"InvalidParameterValue", "invalid RedrivePolicy: a queue can't be its own dead-letter queue"}
+	}
+	dlq, exists := lookup(name)
+	if !exists {
+		return fmt.Errorf("invalid RedrivePolicy: dead-letter target %s does not exist", policy.DeadLetterTargetArn)
+	}
+	if dlq.FifoQueue != fifo {
+		return fmt.Errorf("invalid RedrivePolicy: dead-letter queue must be the same type of queue as the source")
+	}
+	return nil
+}
 
-	// Find the value end
-	valueEnd := valueStart
-	if isString {
-		for i := valueStart; i < len(jsonStr); i++ {
-			if jsonStr[i] == '"' && (i == 0 || jsonStr[i-1] != '\\') {
-				valueEnd = i
-				break
-			}
+// parseRedriveAllowPolicy parses a RedriveAllowPolicy attribute such as
+// {"redrivePermission":"byQueue","sourceQueueArns":["arn:aws:sqs:us-east-1:000000000000:my-queue"]}
+func parseRedriveAllowPolicy(policyJSON string) (*RedriveAllowPolicy, error) {
+	policy := &RedriveAllowPolicy{}
+	if err := json.Unmarshal([]byte(policyJSON), policy); err != nil {
+		return nil, fmt.Errorf("invalid RedriveAllowPolicy: %w", err)
+	}
+
+	switch policy.RedrivePermission {
+	case "allowAll", "denyAll":
+		if len(policy.SourceQueueArns) > 0 {
+			return nil, fmt.Errorf("invalid RedriveAllowPolicy: sourceQueueArns is only allowed with byQueue")
 		}
-	} else {
-		for i := valueStart; i < len(jsonStr); i++ {
-			if jsonStr[i] == ',' || jsonStr[i] == '}' {
-				valueEnd = i
-				break
-			}
+	case "byQueue":
+		if len(policy.SourceQueueArns) == 0 || len(policy.SourceQueueArns) > 10 {
+			return nil, fmt.Errorf("invalid RedriveAllowPolicy: byQueue requires between 1 and 10 sourceQueueArns")
 		}
+	default:
+		return nil, fmt.Errorf("invalid RedriveAllowPolicy: unknown redrivePermission %q", policy.RedrivePermission)
+	}
+
+	return policy, nil
+}
+
+// awsRegion is the region reported in queue ARNs and trigger events
+const awsRegion = "us-east-1"
+
+// queueArn builds the ARN for the named queue
+func queueArn(name string) string {
+	return "arn:aws:sqs:" + awsRegion + ":" + activeConfig.Server.AccountID + ":" + name
+}
+
+// parseQueueArn splits a queue ARN of the form
+// arn:aws:sqs:region:account-id:queue-name into its region, account and name
+func parseQueueArn(arn string) (region, account, name string, ok bool) {
+	parts := strings.Split(arn, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[1] != "aws" || parts[2] != "sqs" || parts[5] == "" {
+		return "", "", "", false
 	}
+	return parts[3], parts[4], parts[5], true
+}
 
-	return jsonStr[valueStart:valueEnd]
+// GetQueueByArn looks up a queue by its ARN. It returns false if the ARN is
+// malformed or belongs to another region or account.
+func (qm *QueueManager) GetQueueByArn(arn string) (*Queue, bool) {
+	region, account, name, ok := parseQueueArn(arn)
+	if !ok || region != awsRegion || account != activeConfig.Server.AccountID {
+		return nil, false
+	}
+	return qm.GetQueue(name)
 }
 
 func extractQueueNameFromArn(arn string) string {
@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Queues log every create and delete, which would drown the results
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// newBenchmarkQueue creates a standard queue holding n messages, all received
+// and so in flight, and returns it with their receipt handles in random order
+func newBenchmarkQueue(b *testing.B, n int) (*Queue, []string) {
+	b.Helper()
+	manager := NewQueueManager("")
+	queue, err := manager.CreateQueue(fmt.Sprintf("bench-%d", n), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { manager.DeleteQueue(queue.Name) })
+
+	for i := 0; i < n; i++ {
+		if _, err := queue.SendMessage(fmt.Sprintf("message %d", i), map[string]interface{}{}, nil, 0, "", ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+	handles := make([]string, 0, n)
+	for len(handles) < n {
+		messages, err := queue.ReceiveMessages(10, 43200)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(messages) == 0 {
+			b.Fatalf("received %d of %d messages", len(handles), n)
+		}
+		for _, msg := range messages {
+			handles = append(handles, msg.ReceiptHandle)
+		}
+	}
+	rand.Shuffle(len(handles), func(i, j int) { handles[i], handles[j] = handles[j], handles[i] })
+	return queue, handles
+}
+
+// BenchmarkDeleteMessage deletes messages in random order from a queue of
+// 100k, refilling it whenever it runs out
+func BenchmarkDeleteMessage(b *testing.B) {
+	const depth = 100_000
+	queue, handles := newBenchmarkQueue(b, depth)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i > 0 && i%depth == 0 {
+			b.StopTimer()
+			queue, handles = newBenchmarkQueue(b, depth)
+			b.StartTimer()
+		}
+		if err := queue.DeleteMessage(handles[i%depth]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tokenBucket allows requests at a steady rate with bursts of up to one
+// second's worth
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens added per second
+	tokens float64
+	last   time.Time
+}
+
+// burst is how many tokens the bucket holds when full
+func (b *tokenBucket) burst() float64 {
+	return math.Max(1, math.Ceil(b.rate))
+}
+
+// allow takes a token if one is available
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = math.Min(b.burst(), b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimitedActions maps the actions that can be rate limited to the kind
+// of limit that applies to them
+var rateLimitedActions = map[string]string{
+	"SendMessage":      "send",
+	"SendMessageBatch": "send",
+	"ReceiveMessage":   "receive",
+}
+
+// rateFor returns the requests per second the limit allows for one kind of
+// action, or 0 for unlimited
+func (c RateLimitConfig) rateFor(kind string) float64 {
+	if kind == "send" {
+		return c.SendPerSecond
+	}
+	return c.ReceivePerSecond
+}
+
+// allowRequest reports whether a request of the given kind fits within the
+// queue's rate limit, falling back to the global rate_limit for kinds the
+// queue doesn't limit itself. Each queue has its own bucket per kind.
+func (q *Queue) allowRequest(kind string, now time.Time) bool {
+	q.mu.Lock()
+	rate := q.RateLimit.rateFor(kind)
+	if rate == 0 {
+		rate = activeConfig.RateLimit.rateFor(kind)
+	}
+	if rate == 0 {
+		q.mu.Unlock()
+		return true
+	}
+	bucket := q.rateLimiters[kind]
+	if bucket == nil || bucket.rate != rate {
+		// A new or changed limit starts with a full bucket
+		bucket = &tokenBucket{rate: rate, last: now}
+		bucket.tokens = bucket.burst()
+		if q.rateLimiters == nil {
+			q.rateLimiters = make(map[string]*tokenBucket)
+		}
+		q.rateLimiters[kind] = bucket
+	}
+	q.mu.Unlock()
+
+	return bucket.allow(now)
+}
+
+// limitRate is middleware that throttles sends and receives exceeding the
+// configured rate_limit of their queue with RequestThrottled, as SQS does
+// when a client calls it too often. Requests to other actions or unknown
+// queues pass through.
+func limitRate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Header.Get("X-Amz-Target") == "" {
+			// sqsHandler can't see a parse error once the form is parsed
+			if err := r.ParseForm(); err != nil {
+				sendError(w, r, "InvalidParameterValue", "Failed to parse request", http.StatusBadRequest)
+				return
+			}
+		}
+
+		kind, ok := rateLimitedActions[requestAction(r)]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		queueName, err := extractQueueName(getRequestParam(r, "QueueUrl"))
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		queue, exists := getQueueManager(r).GetQueue(queueName)
+		if exists && !queue.allowRequest(kind, time.Now()) {
+			sendError(w, r, "RequestThrottled", "Rate exceeded", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Standard queues keep track of which messages can be received, so a receive
+// on a deep queue with few visible messages doesn't scan all of it. Messages
+// that are visible wait in the ready list in the order they became visible;
+// in-flight and delayed ones wait in the wakeup heap until they may become
+// visible. Both are lazy: an entry for a message that has since been deleted,
+// received or rescheduled is simply dropped when it's reached, so every change
+// to when a message becomes visible only needs to schedule it again.
+//
+// FIFO queues don't use either, since their receives go group by group.
+
+// wakeup is a message to move to the ready list at a given time
+type wakeup struct {
+	at  time.Time
+	msg *Message
+}
+
+// wakeupHeap is a min-heap of wakeups, earliest first
+type wakeupHeap []wakeup
+
+func (h wakeupHeap) Len() int           { return len(h) }
+func (h wakeupHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h wakeupHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *wakeupHeap) Push(x any)        { *h = append(*h, x.(wakeup)) }
+func (h *wakeupHeap) Pop() any {
+	old := *h
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = wakeup{}
+	*h = old[:n-1]
+	return w
+}
+
+// visibleAt returns when the message can next be received
+func (m *Message) visibleAt() time.Time {
+	if m.DelayUntil.After(m.VisibilityTimeout) {
+		return m.DelayUntil
+	}
+	return m.VisibilityTimeout
+}
+
+// scheduleLocked records when the message can next be received. It must be
+// called whenever a message is added or its visibility changes. The caller
+// must hold the queue lock.
+func (q *Queue) scheduleLocked(msg *Message, now time.Time) {
+	at := msg.visibleAt()
+	if !at.After(now) {
+		q.notifyLocked()
+	}
+	if q.FifoQueue {
+		return
+	}
+	if at.After(now) {
+		heap.Push(&q.wakeups, wakeup{at: at, msg: msg})
+	} else {
+		q.ready = append(q.ready, msg)
+	}
+}
+
+// takeReadyLocked removes and returns up to maxMessages visible messages from
+// the ready list of a standard queue, after moving in any whose wakeup is
+// due. The caller must hold the queue lock.
+func (q *Queue) takeReadyLocked(now time.Time, maxMessages int) []*Message {
+	for len(q.wakeups) > 0 && !q.wakeups[0].at.After(now) {
+		q.ready = append(q.ready, heap.Pop(&q.wakeups).(wakeup).msg)
+	}
+
+	available := make([]*Message, 0)
+	taken := make(map[*Message]bool)
+	for q.readyHead < len(q.ready) && len(available) < maxMessages {
+		msg := q.ready[q.readyHead]
+		q.ready[q.readyHead] = nil
+		q.readyHead++
+		// A message can be listed more than once if it was rescheduled, so
+		// skip ones already taken by this receive as well as stale entries
+		if !q.holdsLocked(msg) || msg.state(now) != messageVisible || taken[msg] {
+			continue
+		}
+		taken[msg] = true
+		available = append(available, msg)
+	}
+
+	// Drop the consumed front of the list once it's most of it
+	if q.readyHead > len(q.ready)/2 {
+		q.ready = append(make([]*Message, 0, len(q.ready)-q.readyHead), q.ready[q.readyHead:]...)
+		q.readyHead = 0
+	}
+	return available
+}
+
+// rescheduleAllLocked rebuilds the ready list and wakeup heap from the
+// queue's messages, discarding stale entries. The caller must hold the queue
+// lock.
+func (q *Queue) rescheduleAllLocked(now time.Time) {
+	q.ready, q.readyHead, q.wakeups = nil, 0, nil
+	if q.FifoQueue {
+		return
+	}
+	for _, msg := range q.Messages {
+		if msg == nil {
+			continue
+		}
+		if at := msg.visibleAt(); at.After(now) {
+			q.wakeups = append(q.wakeups, wakeup{at: at, msg: msg})
+		} else {
+			q.ready = append(q.ready, msg)
+		}
+	}
+	heap.Init(&q.wakeups)
+	q.notifyLocked()
+}
+
+// notifyLocked wakes every receive long polling the queue, to look for
+// messages again. Whatever makes a message visible calls it: scheduleLocked
+// covers sends, redrives and visibility changes, and the background checker
+// covers messages whose delay or visibility timeout runs out. The caller must
+// hold the queue lock.
+func (q *Queue) notifyLocked() {
+	if q.visibleSignal != nil {
+		close(q.visibleSignal)
+		q.visibleSignal = nil
+	}
+}
+
+// visibleSignalLocked returns a channel that is closed by the next
+// notifyLocked. The caller must hold the queue lock.
+func (q *Queue) visibleSignalLocked() <-chan struct{} {
+	if q.visibleSignal == nil {
+		q.visibleSignal = make(chan struct{})
+	}
+	return q.visibleSignal
+}
+
+// scheduledLocked returns the number of entries in the ready list and wakeup
+// heap, stale ones included. The caller must hold the queue lock.
+func (q *Queue) scheduledLocked() int {
+	return len(q.ready) - q.readyHead + len(q.wakeups)
+}
+
+// holdsLocked reports whether msg is currently in the queue. The caller must
+// hold the queue lock.
+func (q *Queue) holdsLocked(msg *Message) bool {
+	i, ok := q.messageIndex[msg.MessageID]
+	return ok && q.Messages[i] == msg
+}
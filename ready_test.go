@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestReceiveOutOfSliceOrder checks that messages are received as they
+// become visible, whatever their place in the queue
+func TestReceiveOutOfSliceOrder(t *testing.T) {
+	manager := NewQueueManager("")
+	queue, err := manager.CreateQueue("ready-order", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.DeleteQueue(queue.Name)
+
+	for _, body := range []string{"first", "second", "third", "fourth"} {
+		if _, err := queue.SendMessage(body, map[string]interface{}{}, nil, 0, "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := queue.SendMessage("delayed", map[string]interface{}{}, nil, 50, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// In flight for 40, 10, 30 and 20 seconds, in queue order
+	handles := make(map[string]string)
+	for _, timeout := range []int{40, 10, 30, 20} {
+		messages, err := queue.ReceiveMessages(1, timeout)
+		if err != nil || len(messages) != 1 {
+			t.Fatalf("receive: %d messages, error %v", len(messages), err)
+		}
+		handles[messages[0].Body] = messages[0].ReceiptHandle
+	}
+	// Ending the timeout of a message early makes it visible before the rest
+	if err := queue.ChangeMessageVisibility(handles["third"], 0); err != nil {
+		t.Fatal(err)
+	}
+
+	receive := func() []string {
+		t.Helper()
+		messages, err := queue.ReceiveMessages(10, 300)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies := make([]string, 0, len(messages))
+		for _, msg := range messages {
+			bodies = append(bodies, msg.Body)
+		}
+		return bodies
+	}
+	for _, step := range []struct {
+		advance time.Duration
+		want    string
+	}{
+		{0, "third"},
+		{11 * time.Second, "second"},
+		{10 * time.Second, "fourth"},
+		{20 * time.Second, "first"},
+		{10 * time.Second, "delayed"},
+	} {
+		if step.advance > 0 {
+			manager.AdvanceClock(step.advance)
+		}
+		if got := receive(); len(got) != 1 || got[0] != step.want {
+			t.Fatalf("after advancing %v: received %v, want [%s]", step.advance, got, step.want)
+		}
+	}
+	if got := receive(); len(got) != 0 {
+		t.Fatalf("received %v after every message was taken", got)
+	}
+}
+
+// BenchmarkReceive receives from a queue of 1M messages of which only 5 are
+// visible. A visibility timeout of 0 leaves them visible for the next receive.
+func BenchmarkReceive(b *testing.B) {
+	const depth, visible = 1_000_000, 5
+	// Lift the SQS limit of 120,000 in-flight messages for the setup
+	maxInFlight, unlimited := activeConfig.Server.MaxInFlightMessages, 0
+	activeConfig.Server.MaxInFlightMessages = &unlimited
+	b.Cleanup(func() { activeConfig.Server.MaxInFlightMessages = maxInFlight })
+
+	queue, handles := newBenchmarkQueue(b, depth)
+	for _, handle := range handles[:visible] {
+		if err := queue.ChangeMessageVisibility(handle, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		messages, err := queue.ReceiveMessages(10, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(messages) != visible {
+			b.Fatalf("received %d messages, want %d", len(messages), visible)
+		}
+	}
+}
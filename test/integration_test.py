@@ -4,11 +4,25 @@ Integration tests for Ess-Queue-Ess SQS emulator.
 Tests core SQS operations and admin UI functionality.
 """
 
+import base64
+import http.server
+import datetime
+import hashlib
+import hmac
 import json
+import os
+import re
 import requests
+import signal
+import socket
+import struct
+import subprocess
 import sys
+import tempfile
+import threading
 import time
-from urllib.parse import urlencode
+from urllib.parse import urlencode, urlparse
+import xml.etree.ElementTree as ET
 
 BASE_URL = "http://localhost:9324"
 ADMIN_URL = f"{BASE_URL}/admin"
@@ -42,6 +56,27 @@ def sqs_request(action, params=None):
     response = requests.post(BASE_URL, data=params)
     return response
 
+def sqs_json_request(action, params=None):
+    """Make an SQS API request using the JSON protocol"""
+    headers = {
+        'X-Amz-Target': f"AmazonSQS.{action}",
+        'Content-Type': 'application/x-amz-json-1.0'
+    }
+    return requests.post(BASE_URL, data=json.dumps(params or {}), headers=headers)
+
+def get_admin_queue(queue_name):
+    """Fetch a single queue's details from the admin API"""
+    response = requests.get(API_URL)
+    for q in response.json()['queues']:
+        if q['name'] == queue_name:
+            return q
+    return None
+
+def queue_arn(queue_name):
+    """Build a queue's ARN using the server's configured account ID"""
+    account_id = requests.get(API_URL).json()['account_id']
+    return f"arn:aws:sqs:us-east-1:{account_id}:{queue_name}"
+
 def test_health_check():
     print_test("Health Check")
     response = requests.get(f"{BASE_URL}/health")
@@ -50,6 +85,17 @@ def test_health_check():
     assert data.get('status') == 'healthy', f"Unexpected health status: {data}"
     print_success("Health endpoint returns healthy status")
 
+    response = requests.get(f"{BASE_URL}/livez")
+    assert response.status_code == 200, f"Liveness check failed: {response.status_code}"
+    print_success("Liveness endpoint returns 200")
+
+    response = requests.get(f"{BASE_URL}/readyz")
+    assert response.status_code == 200, f"Readiness check failed: {response.status_code}"
+    data = response.json()
+    assert data.get('status') == 'ready', f"Unexpected readiness status: {data}"
+    assert isinstance(data.get('queues'), int), f"Readiness doesn't report the queue count: {data}"
+    print_success(f"Readiness endpoint reports ready with {data['queues']} bootstrapped queues")
+
 def test_admin_ui():
     print_test("Admin UI")
     response = requests.get(ADMIN_URL)
@@ -85,6 +131,239 @@ def test_list_queues(expected_count=None):
     print_success(f"Listed {queue_count} queues")
     return queue_count
 
+def test_request_id():
+    print_test("Request IDs")
+    response = sqs_request('ListQueues')
+    request_id = response.headers.get('x-amzn-RequestId')
+    assert request_id, "Missing x-amzn-RequestId header"
+    assert f"<RequestId>{request_id}</RequestId>" in response.text, "RequestId missing from ResponseMetadata"
+    print_success(f"Response carries request ID {request_id}")
+
+def test_json_content_types():
+    print_test("JSON Protocol Content Types")
+    for content_type in ['application/x-amz-json-1.0', 'application/x-amz-json-1.1', 'application/json']:
+        headers = {'X-Amz-Target': 'AmazonSQS.ListQueues', 'Content-Type': content_type}
+        response = requests.post(BASE_URL, data='{}', headers=headers)
+        assert response.status_code == 200, f"{content_type}: {response.text}"
+        assert response.headers['Content-Type'] == content_type, \
+            f"{content_type}: response content type {response.headers['Content-Type']}"
+        assert 'ResponseMetadata' in response.json()
+
+        headers['X-Amz-Target'] = 'AmazonSQS.GetQueueUrl'
+        response = requests.post(BASE_URL, data=json.dumps({'QueueName': 'test-no-such-queue'}), headers=headers)
+        assert response.status_code == 400 and response.headers['Content-Type'] == content_type, \
+            f"{content_type}: error response {response.status_code} {response.headers['Content-Type']}"
+    print_success("JSON 1.0, JSON 1.1 and plain JSON requests get responses in their own content type")
+
+    headers = {'X-Amz-Target': 'AmazonSQS.ListQueues', 'Content-Type': 'text/plain'}
+    response = requests.post(BASE_URL, data='{}', headers=headers)
+    assert response.headers['Content-Type'] == 'application/x-amz-json-1.0', \
+        f"Unexpected fallback content type: {response.headers['Content-Type']}"
+    print_success("Other content types get application/x-amz-json-1.0")
+
+def test_json_parameters():
+    print_test("JSON Protocol Parameters")
+    queue_name = "test-json-parameters"
+    response = sqs_json_request('CreateQueue', {
+        'QueueName': queue_name,
+        'Attributes': {'VisibilityTimeout': '45'}
+    })
+    assert response.status_code == 200, f"CreateQueue failed: {response.text}"
+    queue_url = response.json()['QueueUrl']
+
+    try:
+        response = sqs_json_request('GetQueueUrl', {'QueueName': queue_name})
+        assert response.json()['QueueUrl'] == queue_url, f"GetQueueUrl failed: {response.text}"
+
+        for i in range(2):
+            response = sqs_json_request('SendMessage', {
+                'QueueUrl': queue_url,
+                'MessageBody': f"json {i}",
+                'DelaySeconds': 0,
+                'MessageAttributes': {'color': {'DataType': 'String', 'StringValue': 'red'}},
+                'MessageSystemAttributes': {'AWSTraceHeader': {'DataType': 'String', 'StringValue': 'Root=1-json'}}
+            })
+            assert response.status_code == 200, f"SendMessage failed: {response.text}"
+            assert 'MD5OfMessageSystemAttributes' in response.json(), "System attributes were not parsed"
+
+        response = sqs_json_request('ReceiveMessage', {
+            'QueueUrl': queue_url,
+            'MaxNumberOfMessages': 2,
+            'VisibilityTimeout': 40,
+            'WaitTimeSeconds': 1,
+            'AttributeNames': ['All']
+        })
+        messages = response.json().get('Messages') or []
+        assert len(messages) == 2, f"Expected 2 messages: {response.text}"
+        for msg in messages:
+            assert msg['Attributes'].get('AWSTraceHeader') == 'Root=1-json', f"System attributes missing: {msg}"
+        for msg in get_admin_queue(queue_name)['messages']:
+            assert msg['message_attributes']['color']['StringValue'] == 'red', f"Message attributes missing: {msg}"
+            assert msg['state'] == 'in_flight', f"Message not received: {msg}"
+        print_success("Every SendMessage and ReceiveMessage parameter is parsed")
+
+        response = sqs_json_request('ListMessageMoveTasks', {'SourceArn': queue_arn(queue_name)})
+        assert response.status_code == 200, f"SourceArn was not parsed: {response.text}"
+        print_success("Parameters looked up one at a time are parsed")
+    finally:
+        sqs_json_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_xml_namespace():
+    print_test("XML Response Namespace")
+    namespace = "{http://queue.amazonaws.com/doc/2012-11-05/}"
+    response = sqs_request('ListQueues', {'Version': '2012-11-05'})
+    root = ET.fromstring(response.content)
+    assert root.tag == namespace + "ListQueuesResponse", f"Unexpected root element: {root.tag}"
+    assert root.find(f"{namespace}ResponseMetadata/{namespace}RequestId") is not None, \
+        "ResponseMetadata is not in the response namespace"
+    print_success("Responses are in the SQS 2012-11-05 namespace")
+
+    response = sqs_request('GetQueueUrl', {'QueueName': 'test-no-such-queue', 'Version': '2012-11-05'})
+    assert response.status_code == 400, f"Expected 400, got {response.status_code}"
+    root = ET.fromstring(response.content)
+    assert root.tag == namespace + "ErrorResponse", f"Unexpected error root element: {root.tag}"
+    print_success("Error responses are in the same namespace")
+
+    response = sqs_request('ListQueues', {'Version': '2011-10-01'})
+    root = ET.fromstring(response.content)
+    if root.tag == namespace + "ListQueuesResponse":
+        print_info("Server pins server.api_version, skipping the echo check")
+        return
+    assert root.tag == "{http://queue.amazonaws.com/doc/2011-10-01/}ListQueuesResponse", \
+        f"Requested API version not echoed: {root.tag}"
+    print_success("The namespace names the API version the request gave")
+
+    response = sqs_request('ListQueues', {'Version': '"><x/>'})
+    root = ET.fromstring(response.content)
+    assert root.tag == namespace + "ListQueuesResponse", f"Invalid Version not ignored: {root.tag}"
+    print_success("An invalid Version falls back to 2012-11-05")
+
+def test_nonexistent_queue_errors():
+    print_test("NonExistentQueue Error Code")
+    code = "AWS.SimpleQueueService.NonExistentQueue"
+    namespace = "{http://queue.amazonaws.com/doc/2012-11-05/}"
+    queue_url = f"{BASE_URL}/test-no-such-queue"
+    requests_by_action = {
+        'SendMessage': {'QueueUrl': queue_url, 'MessageBody': "lost"},
+        'ReceiveMessage': {'QueueUrl': queue_url},
+        'DeleteMessage': {'QueueUrl': queue_url, 'ReceiptHandle': "handle"},
+        'ChangeMessageVisibility': {'QueueUrl': queue_url, 'ReceiptHandle': "handle", 'VisibilityTimeout': 0},
+        'GetQueueAttributes': {'QueueUrl': queue_url},
+        'PurgeQueue': {'QueueUrl': queue_url},
+        'DeleteQueue': {'QueueUrl': queue_url},
+        'GetQueueUrl': {'QueueName': "test-no-such-queue"},
+    }
+
+    for action, params in requests_by_action.items():
+        response = sqs_request(action, {k: str(v) for k, v in params.items()})
+        assert response.status_code == 400, f"{action}: expected 400, got {response.status_code}"
+        error = ET.fromstring(response.content).find(f"{namespace}Error")
+        assert error.findtext(f"{namespace}Code") == code, f"{action}: unexpected XML error: {response.text}"
+        assert error.findtext(f"{namespace}Type") == "Sender", f"{action}: unexpected XML error type: {response.text}"
+
+        response = sqs_json_request(action, params)
+        assert response.status_code == 400, f"{action} (JSON): expected 400, got {response.status_code}"
+        assert response.headers.get('x-amzn-query-error') == f"{code};Sender", \
+            f"{action} (JSON): unexpected x-amzn-query-error: {response.headers.get('x-amzn-query-error')}"
+        assert response.json()['__type'] == "com.amazonaws.sqs#QueueDoesNotExist", \
+            f"{action} (JSON): unexpected error: {response.text}"
+    print_success(f"Every queue action reports {code} in both protocols")
+
+    missing_arn = queue_arn("test-no-such-queue")
+    for action in ['StartMessageMoveTask', 'ListMessageMoveTasks']:
+        response = sqs_request(action, {'SourceArn': missing_arn})
+        assert response.status_code == 400, f"{action}: expected 400, got {response.status_code}"
+        error = ET.fromstring(response.content).find(f"{namespace}Error")
+        assert error.findtext(f"{namespace}Code") == "ResourceNotFoundException", f"{action}: unexpected error: {response.text}"
+
+        response = sqs_json_request(action, {'SourceArn': missing_arn})
+        assert response.status_code == 400, f"{action} (JSON): expected 400, got {response.status_code}"
+        assert response.headers.get('x-amzn-query-error') == "ResourceNotFoundException;Sender", \
+            f"{action} (JSON): unexpected error: {response.text}"
+    print_success("Move task actions report ResourceNotFoundException for a missing source queue, as in AWS")
+
+def test_correlation_id():
+    print_test("Correlation IDs")
+    correlation_id = f"trace-{int(time.time() * 1000)}"
+    response = requests.post(BASE_URL, data={'Action': 'ListQueues'},
+                             headers={'X-Correlation-Id': correlation_id})
+    assert response.headers.get('X-Correlation-Id') == correlation_id, "Correlation ID not echoed"
+    print_success("X-Correlation-Id is echoed on the response")
+
+    response = sqs_request('ListQueues')
+    assert response.headers.get('X-Correlation-Id') == response.headers.get('x-amzn-RequestId'), \
+        "Correlation ID doesn't fall back to the request ID"
+    print_success("Without the header, the request ID is used")
+
+    # Set SERVER_LOG to the server's log file to also check the log output
+    server_log = os.environ.get('SERVER_LOG')
+    if server_log:
+        time.sleep(0.2)
+        with open(server_log) as f:
+            assert f"correlation_id={correlation_id}" in f.read(), "Correlation ID missing from server log"
+        print_success("Correlation ID appears in the server log")
+
+def test_account_queue_urls():
+    print_test("Account-Prefixed Queue URLs")
+    queue_name = "test-account-url-queue"
+    try:
+        response = sqs_request('CreateQueue', {'QueueName': queue_name})
+        account_path = re.search(r'<QueueUrl>http://[^/]+/(\d{12})/' + queue_name + '</QueueUrl>', response.text)
+        assert account_path, f"CreateQueue URL lacks an account ID: {response.text}"
+        queue_url = f"{BASE_URL}/{account_path.group(1)}/{queue_name}"
+        print_success(f"CreateQueue returns {queue_url}")
+
+        response = sqs_request('GetQueueUrl', {'QueueName': queue_name})
+        assert f"<QueueUrl>{queue_url}</QueueUrl>" in response.text, f"Unexpected GetQueueUrl response: {response.text}"
+        response = sqs_json_request('GetQueueUrl', {'QueueName': queue_name})
+        assert response.json()['QueueUrl'] == queue_url, f"Unexpected JSON GetQueueUrl response: {response.text}"
+        response = sqs_request('ListQueues', {'QueueNamePrefix': queue_name})
+        assert f"<QueueUrl>{queue_url}</QueueUrl>" in response.text, f"Unexpected ListQueues response: {response.text}"
+        print_success("GetQueueUrl and ListQueues return the same URL")
+
+        response = sqs_request('GetQueueUrl', {'QueueName': 'test-no-such-queue'})
+        assert response.status_code == 400 and 'NonExistentQueue' in response.text, \
+            f"Unexpected response for a missing queue: {response.text}"
+        print_success("GetQueueUrl for a missing queue returns NonExistentQueue")
+
+        response = sqs_request('SendMessage', {'QueueUrl': f"{BASE_URL}/{queue_name}", 'MessageBody': "bare"})
+        assert response.status_code == 200, f"Bare queue URL no longer resolves: {response.text}"
+        print_success("Bare /name URLs still resolve")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{queue_name}"})
+
+def test_list_queues_pagination():
+    print_test("List Queues Pagination")
+    queue_names = [f"test-page-{i}" for i in range(3)]
+    for name in queue_names:
+        sqs_request('CreateQueue', {'QueueName': name})
+
+    try:
+        response = sqs_request('ListQueues', {
+            'QueueNamePrefix': 'test-page-',
+            'MaxResults': '2'
+        })
+        assert response.status_code == 200, f"List queues failed: {response.status_code}"
+        assert response.text.count('<QueueUrl>') == 2, f"Expected 2 queues on first page: {response.text}"
+        assert '/test-page-0<' in response.text and '/test-page-1<' in response.text, "First page not sorted by name"
+        assert '<NextToken>' in response.text, "Expected a NextToken when more queues remain"
+
+        start = response.text.find('<NextToken>') + len('<NextToken>')
+        next_token = response.text[start:response.text.find('</NextToken>')]
+
+        response = sqs_request('ListQueues', {
+            'QueueNamePrefix': 'test-page-',
+            'MaxResults': '2',
+            'NextToken': next_token
+        })
+        assert response.text.count('<QueueUrl>') == 1, f"Expected 1 queue on last page: {response.text}"
+        assert '/test-page-2<' in response.text, "Last page missing final queue"
+        assert '<NextToken>' not in response.text, "Unexpected NextToken on last page"
+        print_success("Paginated through 3 queues two at a time")
+    finally:
+        for name in queue_names:
+            sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{name}"})
+
 def test_send_message(queue_name):
     print_test("Send Message")
     queue_url = f"{BASE_URL}/{queue_name}"
@@ -155,176 +434,3461 @@ def test_delete_message(queue_name):
     assert response.status_code == 200, f"Delete message failed: {response.status_code}"
     print_success(f"Message deleted from '{queue_name}'")
 
-def test_get_queue_attributes(queue_name):
-    print_test("Get Queue Attributes")
+def test_delete_message_errors():
+    print_test("Delete Message Receipt Handle Errors")
+    queue_a, queue_b = "test-handle-queue-a", "test-handle-queue-b"
+    for name in [queue_a, queue_b]:
+        sqs_request('CreateQueue', {'QueueName': name})
+
+    def delete(queue_name, receipt_handle):
+        return sqs_request('DeleteMessage', {
+            'QueueUrl': f"{BASE_URL}/{queue_name}",
+            'ReceiptHandle': receipt_handle
+        })
+
+    try:
+        sqs_request('SendMessage', {'QueueUrl': f"{BASE_URL}/{queue_a}", 'MessageBody': "handle test"})
+        response = sqs_request('ReceiveMessage', {'QueueUrl': f"{BASE_URL}/{queue_a}"})
+        start = response.text.find('<ReceiptHandle>') + len('<ReceiptHandle>')
+        receipt_handle = response.text[start:response.text.find('</ReceiptHandle>')]
+
+        response = delete(queue_a, "not-a-handle")
+        assert response.status_code == 400, f"Expected 400, got {response.status_code}"
+        assert 'ReceiptHandleIsInvalid' in response.text and 'is invalid' in response.text
+        print_success("Malformed handle is rejected")
+
+        response = delete(queue_b, receipt_handle)
+        assert response.status_code == 400, f"Expected 400, got {response.status_code}"
+        assert f"issued by queue {queue_a}" in response.text, f"Unexpected error: {response.text}"
+        print_success("Handle from another queue is rejected as belonging to that queue")
+
+        response = delete(queue_a, receipt_handle)
+        assert response.status_code == 200, f"Delete failed: {response.text}"
+        response = delete(queue_a, receipt_handle)
+        assert response.status_code == 400, f"Expected 400, got {response.status_code}"
+        assert 'no longer in queue' in response.text, f"Unexpected error: {response.text}"
+        print_success("Reused handle reports the message is gone")
+    finally:
+        for name in [queue_a, queue_b]:
+            sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{name}"})
+
+def test_change_message_visibility():
+    print_test("Change Message Visibility")
+    queue_name = "test-visibility-queue"
     queue_url = f"{BASE_URL}/{queue_name}"
-    
-    response = sqs_request('GetQueueAttributes', {
-        'QueueUrl': queue_url,
-        'AttributeName.1': 'All'
-    })
-    
-    assert response.status_code == 200, f"Get attributes failed: {response.status_code}"
-    assert 'ApproximateNumberOfMessages' in response.text, "Attributes not in response"
-    print_success(f"Retrieved attributes for '{queue_name}'")
+    sqs_request('CreateQueue', {'QueueName': queue_name})
 
-def test_purge_queue(queue_name):
-    print_test("Purge Queue")
+    def receive_handle(visibility_timeout):
+        response = sqs_request('ReceiveMessage', {
+            'QueueUrl': queue_url,
+            'VisibilityTimeout': str(visibility_timeout)
+        })
+        if '<ReceiptHandle>' not in response.text:
+            return None
+        start = response.text.find('<ReceiptHandle>') + len('<ReceiptHandle>')
+        return response.text[start:response.text.find('</ReceiptHandle>')]
+
+    try:
+        sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "visibility test"})
+        receipt_handle = receive_handle(30)
+
+        response = sqs_request('ChangeMessageVisibility', {
+            'QueueUrl': queue_url,
+            'ReceiptHandle': receipt_handle,
+            'VisibilityTimeout': '0'
+        })
+        assert response.status_code == 200, f"ChangeMessageVisibility failed: {response.text}"
+        assert receive_handle(1) is not None, "Message not visible after setting timeout to 0"
+        print_success("Setting visibility timeout to 0 releases the message")
+
+        response = sqs_request('DeleteMessage', {'QueueUrl': queue_url, 'ReceiptHandle': receipt_handle})
+        assert response.status_code == 400, "Superseded handle was accepted"
+        print_success("Handle from an earlier receive is rejected")
+
+        time.sleep(1.5)
+        response = sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "expiry test"})
+        handle = receive_handle(1)
+        time.sleep(1.5)
+        for action in ['ChangeMessageVisibility', 'DeleteMessage']:
+            response = sqs_request(action, {
+                'QueueUrl': queue_url,
+                'ReceiptHandle': handle,
+                'VisibilityTimeout': '30'
+            })
+            assert response.status_code == 400, f"{action} accepted a lapsed handle"
+            assert 'has expired' in response.text, f"Unexpected error: {response.text}"
+        print_success("Handles whose visibility window lapsed are rejected as expired")
+
+        # Start from a message that hasn't been received before
+        sqs_request('PurgeQueue', {'QueueUrl': queue_url})
+        sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "extension test"})
+        handle = receive_handle(30)
+        extensions = 0
+        for _ in range(10):
+            response = sqs_request('ChangeMessageVisibility', {
+                'QueueUrl': queue_url,
+                'ReceiptHandle': handle,
+                'VisibilityTimeout': '43198'
+            })
+            if response.status_code != 200:
+                break
+            extensions += 1
+            time.sleep(0.5)
+        assert extensions > 0, f"The first extension should fit within 12 hours: {response.text}"
+        assert response.status_code == 400 and 'InvalidParameterValue' in response.text, \
+            f"Extending past 12 hours after the first receive should fail: {response.text}"
+        response = sqs_request('ChangeMessageVisibility', {
+            'QueueUrl': queue_url,
+            'ReceiptHandle': handle,
+            'VisibilityTimeout': '43000'
+        })
+        assert response.status_code == 200, f"A shorter extension should still fit: {response.text}"
+        print_success("Visibility can't be extended beyond 12 hours after the first receive")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_batch_entry_validation():
+    print_test("Batch Entry Validation")
+    queue_name = "test-batch-queue"
     queue_url = f"{BASE_URL}/{queue_name}"
-    
-    response = sqs_request('PurgeQueue', {
-        'QueueUrl': queue_url
-    })
-    
-    assert response.status_code == 200, f"Purge queue failed: {response.status_code}"
-    print_success(f"Queue '{queue_name}' purged")
-    
-    # Verify queue is empty
-    time.sleep(0.5)  # Brief pause
-    response = sqs_request('ReceiveMessage', {
-        'QueueUrl': queue_url,
-        'MaxNumberOfMessages': '10'
-    })
-    message_count = response.text.count('<MessageId>')
-    assert message_count == 0, f"Queue not empty after purge: {message_count} messages"
-    print_success("Verified queue is empty after purge")
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+    try:
+        for action in ['SendMessageBatch', 'DeleteMessageBatch', 'ChangeMessageVisibilityBatch']:
+            response = sqs_json_request(action, {'QueueUrl': queue_url, 'Entries': []})
+            assert response.status_code == 400, f"{action}: expected 400, got {response.status_code}"
+            assert 'EmptyBatchRequest' in response.text, f"{action}: unexpected error: {response.text}"
+        print_success("Empty batches are rejected with EmptyBatchRequest")
 
-def test_delete_queue(queue_name):
-    print_test("Delete Queue")
+        response = sqs_request('SendMessageBatch', {
+            'QueueUrl': queue_url,
+            'SendMessageBatchRequestEntry.1.Id': 'same',
+            'SendMessageBatchRequestEntry.1.MessageBody': 'first',
+            'SendMessageBatchRequestEntry.2.Id': 'same',
+            'SendMessageBatchRequestEntry.2.MessageBody': 'second'
+        })
+        assert response.status_code == 400, f"Expected 400, got {response.status_code}"
+        assert 'BatchEntryIdsNotDistinct' in response.text, f"Unexpected error: {response.text}"
+        print_success("Duplicate entry ids are rejected with BatchEntryIdsNotDistinct")
+
+        response = sqs_json_request('SendMessageBatch', {
+            'QueueUrl': queue_url,
+            'Entries': [{'Id': 'x' * 81, 'MessageBody': 'oversized id'}]
+        })
+        assert response.status_code == 400, f"Expected 400, got {response.status_code}"
+        assert 'InvalidBatchEntryId' in response.text, f"Unexpected error: {response.text}"
+        response = sqs_json_request('DeleteMessageBatch', {
+            'QueueUrl': queue_url,
+            'Entries': [{'Id': 'bad id!', 'ReceiptHandle': 'handle'}]
+        })
+        assert 'InvalidBatchEntryId' in response.text, f"Unexpected error: {response.text}"
+        print_success("Oversized and malformed entry ids are rejected with InvalidBatchEntryId")
+
+        messages = sqs_request('ReceiveMessage', {'QueueUrl': queue_url, 'MaxNumberOfMessages': '10'})
+        assert '<Message>' not in messages.text, "A rejected batch still sent messages"
+        print_success("Rejected batches are not processed")
+
+        response = sqs_json_request('SendMessageBatch', {
+            'QueueUrl': queue_url,
+            'Entries': [{'Id': f"msg-{i}", 'MessageBody': f"batch {i}"} for i in range(3)]
+        })
+        assert response.status_code == 200, f"SendMessageBatch failed: {response.text}"
+        data = response.json()
+        assert sorted(e['Id'] for e in data['Successful']) == ['msg-0', 'msg-1', 'msg-2'], f"Unexpected result: {data}"
+        assert data['Failed'] == [], f"Unexpected failures: {data}"
+
+        response = sqs_json_request('ReceiveMessage', {'QueueUrl': queue_url, 'MaxNumberOfMessages': 10})
+        handles = [m['ReceiptHandle'] for m in response.json()['Messages']]
+        response = sqs_json_request('DeleteMessageBatch', {
+            'QueueUrl': queue_url,
+            'Entries': [{'Id': f"del_{i}", 'ReceiptHandle': h} for i, h in enumerate(handles)]
+                       + [{'Id': 'stale', 'ReceiptHandle': 'not-a-handle'}]
+        })
+        assert response.status_code == 200, f"DeleteMessageBatch failed: {response.text}"
+        data = response.json()
+        assert len(data['Successful']) == 3, f"Unexpected result: {data}"
+        assert [e['Id'] for e in data['Failed']] == ['stale'], f"Unexpected failures: {data}"
+        print_success("Valid batches report per-entry successes and failures")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_zero_visibility_timeout():
+    print_test("ReceiveMessage VisibilityTimeout=0")
+    for queue_name, extra in [("test-zero-visibility", {}),
+                              ("test-zero-visibility.fifo", {'MessageGroupId': "g", 'MessageDeduplicationId': "d"})]:
+        queue_url = f"{BASE_URL}/{queue_name}"
+        attributes = {'FifoQueue': 'true'} if queue_name.endswith('.fifo') else {}
+        sqs_json_request('CreateQueue', {'QueueName': queue_name, 'Attributes': attributes})
+        try:
+            sqs_json_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "peek", **extra})
+            for i in range(1, 21):
+                response = sqs_json_request('ReceiveMessage', {'QueueUrl': queue_url, 'VisibilityTimeout': 0})
+                messages = response.json().get('Messages') or []
+                assert [m['Body'] for m in messages] == ["peek"], f"{queue_name}: receive {i} missed the message: {response.text}"
+            count = get_admin_queue(queue_name)['messages'][0]['receive_count']
+            assert count == 20, f"{queue_name}: expected receive count 20, got {count}"
+            print_success(f"{queue_name}: back-to-back receives with timeout 0 all return the message, counting each receive")
+        finally:
+            sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_visibility_out_of_order():
+    print_test("Messages Becoming Visible Out of Order")
+    queue_name = "test-out-of-order-queue"
     queue_url = f"{BASE_URL}/{queue_name}"
-    
-    response = sqs_request('DeleteQueue', {
-        'QueueUrl': queue_url
-    })
-    
-    assert response.status_code == 200, f"Delete queue failed: {response.status_code}"
-    print_success(f"Queue '{queue_name}' deleted")
+    sqs_request('CreateQueue', {'QueueName': queue_name})
 
-def test_admin_api_with_messages():
-    print_test("Admin API with Messages")
-    
-    # Create queue and add messages
-    queue_name = "admin-test-queue"
-    response = sqs_request('CreateQueue', {'QueueName': queue_name})
+    def receive(visibility_timeout=30):
+        response = sqs_json_request('ReceiveMessage', {
+            'QueueUrl': queue_url, 'MaxNumberOfMessages': 10, 'VisibilityTimeout': visibility_timeout
+        })
+        return {m['Body']: m['ReceiptHandle'] for m in response.json().get('Messages') or []}
+
+    try:
+        for body in ["a", "b", "c"]:
+            sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': body})
+        sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "delayed", 'DelaySeconds': '1'})
+
+        handles = receive()
+        assert sorted(handles) == ["a", "b", "c"], f"Unexpected first receive: {sorted(handles)}"
+
+        for body, timeout in [("c", 0), ("b", 2)]:
+            sqs_json_request('ChangeMessageVisibility', {
+                'QueueUrl': queue_url, 'ReceiptHandle': handles[body], 'VisibilityTimeout': timeout
+            })
+        assert list(receive()) == ["c"], "Only the message made visible again should be received"
+        print_success("A later message made visible again is received while earlier ones stay in flight")
+
+        time.sleep(1.2)
+        assert list(receive()) == ["delayed"], "Delayed message was not received once its delay passed"
+        time.sleep(1)
+        assert list(receive()) == ["b"], "Message was not received once its new timeout expired"
+        assert receive() == {}, "A message was received twice"
+        print_success("Delayed and expiring messages become receivable when their time comes")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_max_messages():
+    print_test("Queue Message Limit (emulator extension)")
+    queue_name = "test-max-messages-queue"
     queue_url = f"{BASE_URL}/{queue_name}"
-    
-    # Send 3 messages
-    for i in range(3):
-        sqs_request('SendMessage', {
+    response = requests.post(f"{BASE_URL}/admin/api/queue", json={'name': queue_name, 'max_messages': 2})
+    assert response.status_code == 200, f"Queue creation failed: {response.text}"
+
+    try:
+        for i in range(2):
+            response = sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': f"fill {i}"})
+            assert response.status_code == 200, f"Send below the limit failed: {response.text}"
+
+        response = sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "overflow"})
+        assert response.status_code == 400 and '<Code>OverLimit</Code>' in response.text, \
+            f"Expected OverLimit: {response.text}"
+        response = sqs_json_request('SendMessageBatch', {
             'QueueUrl': queue_url,
-            'MessageBody': f"Admin test message #{i+1}"
+            'Entries': [{'Id': 'a', 'MessageBody': 'overflow'}]
         })
-    
-    time.sleep(0.5)  # Brief pause for messages to be processed
-    
-    # Check admin API
-    response = requests.get(API_URL)
-    data = response.json()
-    
-    # Find our queue in the response
-    test_queue = None
-    for q in data['queues']:
-        if q['name'] == queue_name:
-            test_queue = q
-            break
-    
-    assert test_queue is not None, f"Queue '{queue_name}' not found in admin API"
-    assert test_queue['visible_count'] == 3, f"Expected 3 visible messages, got {test_queue['visible_count']}"
-    assert len(test_queue['messages']) == 3, f"Expected 3 messages in data, got {len(test_queue['messages'])}"
-    
-    print_success(f"Admin API correctly shows 3 messages in '{queue_name}'")
-    
-    # Cleanup
-    sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+        assert [(e['Id'], e['Code']) for e in response.json()['Failed']] == [('a', 'OverLimit')], \
+            f"Expected a failed batch entry: {response.text}"
+        print_success("Sends to a full queue fail with OverLimit")
 
-def test_admin_create_queue():
-    print_test("Admin API - Create Queue")
-    
-    queue_data = {
-        "name": "admin-test-queue",
-        "visibility_timeout": 60,
-        "message_retention_period": 86400,
-        "max_message_size": 131072
-    }
-    
-    response = requests.post(f"{BASE_URL}/admin/api/queue", json=queue_data)
-    assert response.status_code == 200, f"Failed to create queue via admin API: {response.text}"
-    
-    data = response.json()
-    assert data['success'] == True, "Expected success=true"
-    assert data['queue']['name'] == "admin-test-queue", f"Queue name mismatch: {data}"
-    assert data['queue']['visibility_timeout'] == 60, f"Visibility timeout mismatch: {data}"
-    
-    print_success("Queue created successfully via admin API")
-    
-def test_admin_send_message():
-    print_test("Admin API - Send Message")
-    
-    message_data = {
-        "queue_name": "admin-test-queue",
-        "message_body": "Test message from admin API",
-        "delay_seconds": 0,
-        "attributes": {}
-    }
-    
-    response = requests.post(f"{BASE_URL}/admin/api/message", json=message_data)
-    assert response.status_code == 200, f"Failed to send message via admin API: {response.text}"
-    
-    data = response.json()
-    assert data['success'] == True, "Expected success=true"
-    assert 'message_id' in data, "Missing message_id in response"
-    
-    print_success(f"Message sent successfully via admin API (ID: {data['message_id']})")
-    
-    # Verify message was sent by checking admin API
-    response = requests.get(f"{BASE_URL}/admin/api/queues")
-    data = response.json()
-    queues = {q['name']: q for q in data['queues']}
-    
-    admin_queue = queues.get('admin-test-queue')
-    assert admin_queue is not None, "Queue not found in admin API"
-    assert admin_queue['visible_count'] == 1, f"Expected 1 visible message, got {admin_queue['visible_count']}"
-    
-    print_success("Message visible in queue via admin API")
+        queue = get_admin_queue(queue_name)
+        assert (queue['message_count'], queue['max_messages']) == (2, 2), f"Unexpected admin details: {queue}"
+        print_success("Admin details show 2 of 2 messages")
 
-def test_admin_export_config():
-    print_test("Admin API - Export Config")
-    
-    response = requests.get(f"{BASE_URL}/admin/api/config/export")
-    assert response.status_code == 200, f"Failed to export config: {response.status_code}"
-    assert response.headers.get('Content-Type') == 'application/x-yaml', "Wrong content type"
-    assert 'config.yaml' in response.headers.get('Content-Disposition', ''), "Missing filename"
-    
-    config_text = response.text
-    assert 'server:' in config_text, "Missing server section in config"
-    assert 'queues:' in config_text, "Missing queues section in config"
-    assert 'admin-test-queue' in config_text, "Queue not found in exported config"
-    assert 'visibility_timeout: 60' in config_text, "Queue settings not in config"
-    
-    print_success("Config exported successfully")
-    print_info(f"Config size: {len(config_text)} bytes")
+        response = sqs_json_request('ReceiveMessage', {'QueueUrl': queue_url})
+        receipt_handle = response.json()['Messages'][0]['ReceiptHandle']
+        sqs_json_request('DeleteMessage', {'QueueUrl': queue_url, 'ReceiptHandle': receipt_handle})
+        response = sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "room again"})
+        assert response.status_code == 200, f"Send after a delete failed: {response.text}"
+        print_success("Deleting a message makes room for another")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
 
-def test_admin_delete_queue():
-    print_test("Admin API - Delete Queue")
-    
-    response = requests.delete(f"{BASE_URL}/admin/api/queue?name=admin-test-queue")
-    assert response.status_code == 200, f"Failed to delete queue via admin API: {response.text}"
-    
-    data = response.json()
-    assert data['success'] == True, "Expected success=true"
-    assert 'deleted successfully' in data['message'].lower(), f"Unexpected message: {data['message']}"
-    
-    print_success("Queue deleted successfully via admin API")
-    
-    # Verify queue is gone
-    response = requests.get(f"{BASE_URL}/admin/api/queues")
-    data = response.json()
-    queue_names = [q['name'] for q in data['queues']]
-    assert 'admin-test-queue' not in queue_names, "Queue still exists after deletion"
-    
-    print_success("Queue confirmed deleted")
+def test_in_flight_limit():
+    print_test("In-Flight Message Limit")
+    queue_name = "test-in-flight-limit-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
 
-def run_all_tests():
+    def receive():
+        return sqs_json_request('ReceiveMessage', {
+            'QueueUrl': queue_url, 'MaxNumberOfMessages': 10, 'VisibilityTimeout': 60
+        })
+
+    try:
+        for i in range(12):
+            sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': f"message {i}"})
+
+        response = receive()
+        assert response.status_code == 200, f"First receive failed: {response.text}"
+        first = response.json().get('Messages') or []
+        response = receive()
+        if response.status_code == 200:
+            print_info("Server not running with server.max_in_flight_messages: 10, skipping")
+            return
+        assert len(first) == 10, f"Expected to fill the limit of 10, got {len(first)}"
+        assert response.status_code == 400 and response.json()['__type'].endswith('#OverLimit'), \
+            f"Expected OverLimit at the limit: {response.text}"
+        print_success("A receive with the queue at its in-flight limit fails with OverLimit")
+
+        sqs_request('DeleteMessage', {'QueueUrl': queue_url, 'ReceiptHandle': first[0]['ReceiptHandle']})
+        response = receive()
+        assert response.status_code == 200, f"Receive after a delete failed: {response.text}"
+        assert len(response.json().get('Messages') or []) == 1, "Only one message fits under the limit"
+        print_success("Receives return no more messages than fit under the limit")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_long_poll_waiter_limit():
+    print_test("Long Poll Waiter Limit")
+    import threading
+    queue_name = "test-long-poll-limit-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    requests.post(f"{BASE_URL}/admin/api/queue", json={'name': queue_name, 'max_long_poll_waiters': 2})
+
+    durations = []
+    def long_poll():
+        start = time.time()
+        sqs_request('ReceiveMessage', {'QueueUrl': queue_url, 'WaitTimeSeconds': '2'})
+        durations.append(time.time() - start)
+
+    try:
+        waiters = [threading.Thread(target=long_poll) for _ in range(2)]
+        for t in waiters:
+            t.start()
+        time.sleep(0.5)
+
+        queue = get_admin_queue(queue_name)
+        assert queue['long_poll_waiters'] == 2, f"Expected 2 waiters, got {queue['long_poll_waiters']}"
+        print_success("Admin API reports 2 long-poll waiters")
+
+        excess = [threading.Thread(target=long_poll) for _ in range(3)]
+        for t in excess:
+            t.start()
+        for t in excess:
+            t.join()
+        assert all(d < 0.5 for d in durations[:3]), f"Excess waiters blocked: {durations}"
+        print_success("Receives beyond the limit return immediately")
+
+        for t in waiters:
+            t.join()
+        assert len([d for d in durations if d >= 1.5]) == 2, f"Waiters did not long poll: {durations}"
+        assert get_admin_queue(queue_name)['long_poll_waiters'] == 0, "Waiter count not released"
+        print_success("Receives within the limit wait for the full wait time")
+    finally:
+        requests.delete(f"{BASE_URL}/admin/api/queue?name={queue_name}")
+
+def test_redrive_wakes_long_poll():
+    print_test("Redrive During Long Poll")
+    dlq_name = "test-redrive-wake-dlq"
+    source_name = "test-redrive-wake-source"
+    source_url = f"{BASE_URL}/{source_name}"
+    sqs_request('CreateQueue', {'QueueName': dlq_name})
+    sqs_request('CreateQueue', {
+        'QueueName': source_name,
+        'Attribute.1.Name': 'RedrivePolicy',
+        'Attribute.1.Value': json.dumps({'deadLetterTargetArn': queue_arn(dlq_name), 'maxReceiveCount': 1})
+    })
+
+    result = {}
+    def long_poll():
+        response = sqs_json_request('ReceiveMessage', {'QueueUrl': source_url, 'WaitTimeSeconds': 10})
+        result['returned'] = time.time()
+        result['messages'] = response.json().get('Messages') or []
+
+    try:
+        sqs_request('SendMessage', {'QueueUrl': source_url, 'MessageBody': "wake up"})
+        sqs_request('ReceiveMessage', {'QueueUrl': source_url, 'VisibilityTimeout': '0'})
+        deadline = time.time() + 5
+        while get_admin_queue(dlq_name)['message_count'] < 1 and time.time() < deadline:
+            time.sleep(0.1)
+        assert get_admin_queue(source_name)['message_count'] == 0, "Message was not moved to the DLQ"
+
+        waiter = threading.Thread(target=long_poll)
+        waiter.start()
+        time.sleep(0.5)
+        redriven = time.time()
+        response = sqs_request('StartMessageMoveTask', {'SourceArn': queue_arn(dlq_name)})
+        assert response.status_code == 200, f"StartMessageMoveTask failed: {response.text}"
+        waiter.join(timeout=5)
+
+        assert 'returned' in result, "Long poll still blocked after the redrive"
+        assert [m['Body'] for m in result['messages']] == ["wake up"], f"Unexpected messages: {result['messages']}"
+        assert result['returned'] - redriven < 0.5, f"Long poll took {result['returned'] - redriven:.2f}s to wake"
+        print_success(f"A redrive wakes a waiting receive ({result['returned'] - redriven:.2f}s)")
+    finally:
+        for name in [source_name, dlq_name]:
+            sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{name}"})
+
+def test_delete_queue_wakes_long_poll():
+    print_test("Delete Queue During Long Poll")
+    import threading
+    queue_name = "test-delete-long-poll-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+
+    result = {}
+    def long_poll():
+        start = time.time()
+        response = sqs_request('ReceiveMessage', {'QueueUrl': queue_url, 'WaitTimeSeconds': '10'})
+        result['duration'] = time.time() - start
+        result['response'] = response
+
+    waiter = threading.Thread(target=long_poll)
+    waiter.start()
+    time.sleep(0.5)
+    sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+    waiter.join(timeout=5)
+
+    assert 'duration' in result, "Long poll still blocked after queue deletion"
+    assert result['duration'] < 2, f"Long poll took {result['duration']:.1f}s to return"
+    assert result['response'].status_code == 200, f"Unexpected status {result['response'].status_code}"
+    assert '<MessageId>' not in result['response'].text, "Unexpected message returned"
+    print_success(f"Long poll returned empty {result['duration']:.1f}s after start when the queue was deleted")
+
+def test_short_poll_returns_immediately():
+    print_test("Short Poll Latency")
+    queue_name = "test-short-poll-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+    rounds = 50
+
+    def median_latency(action, params):
+        latencies = []
+        for _ in range(rounds):
+            start = time.perf_counter()
+            response = sqs_request(action, params)
+            latencies.append(time.perf_counter() - start)
+            assert response.status_code == 200, f"{action} failed: {response.text}"
+        return sorted(latencies)[rounds // 2], max(latencies)
+
+    try:
+        # GetQueueUrl does no receive work at all, so it is the baseline for
+        # what a request costs
+        baseline, _ = median_latency('GetQueueUrl', {'QueueName': queue_name})
+        # The wait is given explicitly, since server.defaults may give
+        # queues a default receive wait
+        median, slowest = median_latency('ReceiveMessage', {'QueueUrl': queue_url, 'WaitTimeSeconds': '0'})
+        print_info(f"Short poll median {median * 1000:.1f}ms, slowest {slowest * 1000:.1f}ms, "
+                   f"baseline median {baseline * 1000:.1f}ms over {rounds} receives")
+        # Entering the wait loop would cost at least one 50ms poll tick
+        assert median < baseline + 0.02, f"Short poll median {median * 1000:.1f}ms is far above the baseline"
+        assert slowest < 0.5, f"A short poll took {slowest * 1000:.1f}ms"
+        assert get_admin_queue(queue_name)['long_poll_waiters'] == 0, "A short poll was counted as a waiter"
+        print_success("Receives without a wait on an empty queue return as fast as a request that does no receive work")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_queue_delay_seconds():
+    print_test("Queue-Level DelaySeconds")
+    queue_name = "test-queue-delay"
+    fifo_name = "test-queue-delay.fifo"
+    sqs_request('CreateQueue', {
+        'QueueName': queue_name,
+        'Attribute.1.Name': 'DelaySeconds', 'Attribute.1.Value': '2'
+    })
+    sqs_request('CreateQueue', {
+        'QueueName': fifo_name,
+        'Attribute.1.Name': 'FifoQueue', 'Attribute.1.Value': 'true',
+        'Attribute.2.Name': 'DelaySeconds', 'Attribute.2.Value': '2'
+    })
+
+    def receive(name):
+        return sqs_request('ReceiveMessage', {'QueueUrl': f"{BASE_URL}/{name}"}).text
+
+    try:
+        sqs_request('SendMessage', {'QueueUrl': f"{BASE_URL}/{queue_name}", 'MessageBody': "queue delay"})
+        sqs_request('SendMessage', {
+            'QueueUrl': f"{BASE_URL}/{fifo_name}",
+            'MessageBody': "fifo queue delay",
+            'MessageGroupId': 'g1',
+            'MessageDeduplicationId': 'd1'
+        })
+        assert '<MessageId>' not in receive(queue_name), "Message visible before queue delay"
+        assert '<MessageId>' not in receive(fifo_name), "FIFO message visible before queue delay"
+        time.sleep(2.5)
+        assert 'queue delay' in receive(queue_name), "Message not visible after queue delay"
+        assert 'fifo queue delay' in receive(fifo_name), "FIFO message not visible after queue delay"
+        print_success("Queue DelaySeconds applies when the send has no delay")
+
+        response = sqs_request('SendMessage', {
+            'QueueUrl': f"{BASE_URL}/{fifo_name}",
+            'MessageBody': "per-message delay",
+            'MessageGroupId': 'g1',
+            'MessageDeduplicationId': 'd2',
+            'DelaySeconds': '5'
+        })
+        assert response.status_code == 400, f"Expected 400, got {response.status_code}"
+        assert 'InvalidParameterValue' in response.text, f"Unexpected error: {response.text}"
+        print_success("FIFO queue rejects per-message DelaySeconds")
+
+        response = sqs_request('SendMessage', {
+            'QueueUrl': f"{BASE_URL}/{fifo_name}",
+            'MessageBody': "no deduplication id",
+            'MessageGroupId': 'g1'
+        })
+        assert response.status_code == 400, f"Expected 400, got {response.status_code}"
+        assert 'ContentBasedDeduplication' in response.text, f"Unexpected error: {response.text}"
+        print_success("FIFO queue without content-based dedup requires MessageDeduplicationId")
+    finally:
+        for name in [queue_name, fifo_name]:
+            sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{name}"})
+
+def test_message_body_characters():
+    print_test("Message Body Characters")
+    queue_name = "test-body-charset-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+    try:
+        response = sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': ""})
+        assert response.status_code == 200, f"Empty body rejected: {response.text}"
+        response = sqs_json_request('ReceiveMessage', {'QueueUrl': queue_url})
+        messages = response.json().get('Messages') or []
+        assert [m['Body'] for m in messages] == [""], f"Empty body not returned: {response.text}"
+        print_success("Empty message bodies are accepted and received")
+
+        allowed = "tab\tnewline\ncr\r snowman \u2603 emoji \U0001F600 replacement \ufffd"
+        response = sqs_json_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': allowed})
+        assert response.status_code == 200, f"Allowed characters rejected: {response.text}"
+        response = sqs_request('ReceiveMessage', {'QueueUrl': queue_url})
+        ET.fromstring(response.content)
+        print_success("Whitespace and non-ASCII characters are accepted and received as valid XML")
+
+        for description, body in [("a control character", "bad\x01body"), ("U+FFFF", "bad\uffffbody"),
+                                  ("a NUL", "bad\x00body"), ("invalid UTF-8", b"bad\xffbody")]:
+            response = sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': body})
+            assert response.status_code == 400 and 'InvalidMessageContents' in response.text, \
+                f"Body with {description} accepted: {response.text}"
+        response = sqs_json_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "bad\x07body"})
+        assert response.headers.get('x-amzn-query-error') == "InvalidMessageContents;Sender", \
+            f"JSON body with a control character accepted: {response.text}"
+        print_success("Bodies with disallowed characters are rejected with InvalidMessageContents")
+
+        response = sqs_json_request('SendMessageBatch', {'QueueUrl': queue_url, 'Entries': [
+            {'Id': "good", 'MessageBody': "fine"}, {'Id': "bad", 'MessageBody': "bad\x1bbody"}
+        ]})
+        result = response.json()
+        assert [e['Id'] for e in result['Successful']] == ["good"], f"Unexpected batch result: {result}"
+        assert [(e['Id'], e['Code']) for e in result['Failed']] == [("bad", "InvalidMessageContents")], \
+            f"Unexpected batch failures: {result}"
+        print_success("SendMessageBatch fails only the entry with disallowed characters")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_body_compression():
+    print_test("Body Compression")
+    queue_name = "test-compression-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+    body = "compress me " * 20000
+
+    try:
+        sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': body})
+        message = get_admin_queue(queue_name)['messages'][0]
+        if 'compressed_size' not in message:
+            print_info("Server not running with storage.compress_threshold_bytes, skipping")
+            return
+        assert message['compressed_size'] < len(body), f"Body not compressed: {message['compressed_size']}"
+        assert message['body'] == body, "Admin listing didn't decompress the body"
+        print_success(f"A {len(body)} byte body is stored in {message['compressed_size']} bytes")
+
+        response = sqs_json_request('ReceiveMessage', {'QueueUrl': queue_url})
+        received = response.json()['Messages'][0]
+        assert received['Body'] == body, "ReceiveMessage didn't decompress the body"
+        assert received['MD5OfBody'] == hashlib.md5(body.encode()).hexdigest(), \
+            "MD5OfBody should be that of the original body"
+        print_success("ReceiveMessage returns the original body with its MD5")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_message_size_counts_attributes():
+    print_test("Message Size Counts Attributes")
+    queue_name = "test-size-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    response = requests.post(f"{BASE_URL}/admin/api/queue", json={"name": queue_name, "max_message_size": 1024})
+    assert response.status_code == 200, f"Failed to create queue: {response.text}"
+    try:
+        body = "x" * 1000
+        response = sqs_json_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': body})
+        assert response.status_code == 200, f"Message under the limit was rejected: {response.text}"
+        print_success("A body under MaximumMessageSize is accepted")
+
+        attributes = {'Note': {'DataType': 'String', 'StringValue': "y" * 100}}
+        response = sqs_json_request('SendMessage', {
+            'QueueUrl': queue_url, 'MessageBody': body, 'MessageAttributes': attributes
+        })
+        assert response.status_code == 400, f"Expected 400, got {response.status_code}"
+        assert 'InvalidParameterValue' in response.text, f"Unexpected error: {response.text}"
+        assert 'Message must be shorter than 1024 bytes' in response.text, f"Unexpected message: {response.text}"
+        print_success("JSON attributes count toward the limit")
+
+        response = sqs_request('SendMessage', {
+            'QueueUrl': queue_url, 'MessageBody': body,
+            'MessageAttribute.1.Name': 'Note',
+            'MessageAttribute.1.Value.DataType': 'String',
+            'MessageAttribute.1.Value.StringValue': "y" * 100
+        })
+        assert response.status_code == 400, f"Expected 400, got {response.status_code}"
+        assert 'InvalidParameterValue' in response.text, f"Unexpected error: {response.text}"
+        print_success("Query attributes count toward the limit")
+
+        response = sqs_json_request('SendMessageBatch', {
+            'QueueUrl': queue_url,
+            'Entries': [{'Id': 'big', 'MessageBody': body, 'MessageAttributes': attributes}]
+        })
+        data = response.json()
+        assert [e['Code'] for e in data['Failed']] == ['InvalidParameterValue'], f"Unexpected result: {data}"
+        print_success("Oversized batch entries fail individually")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_message_attribute_validation():
+    print_test("Message Attribute Validation")
+    queue_name = "test-attribute-validation-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+
+    def send(attributes):
+        return sqs_json_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "x", 'MessageAttributes': attributes})
+
+    try:
+        valid = {
+            'Text': {'DataType': 'String', 'StringValue': "hello"},
+            'Count': {'DataType': 'Number', 'StringValue': "-12.5e3"},
+            'Blob': {'DataType': 'Binary', 'BinaryValue': base64.b64encode(b"\x00\x01").decode()},
+            'Price': {'DataType': 'Number.float', 'StringValue': ".5"},
+            'Doc': {'DataType': 'String.json', 'StringValue': "{}"},
+        }
+        response = send(valid)
+        assert response.status_code == 200, f"Valid attributes were rejected: {response.text}"
+        print_success("String, Number, Binary and custom types are accepted")
+
+        for attributes, reason, expected in [
+            ({f"a{i}": {'DataType': 'String', 'StringValue': "v"} for i in range(11)}, "11 attributes", "exceeds the allowed maximum [10]"),
+            ({'A': {'DataType': 'Integer', 'StringValue': "1"}}, "an unknown type", "supported type prefixes"),
+            ({'A': {'DataType': 'Number.', 'StringValue': "1"}}, "an empty custom type", "supported type prefixes"),
+            ({'A': {'StringValue': "1"}}, "a missing type", "non-empty message attribute type"),
+            ({'A': {'DataType': 'String'}}, "a String without StringValue", "non-empty value of message attribute type 'String'"),
+            ({'A': {'DataType': 'String', 'StringValue': ""}}, "an empty String", "non-empty value of message attribute type 'String'"),
+            ({'A': {'DataType': 'Binary'}}, "a Binary without BinaryValue", "non-empty value of message attribute type 'Binary'"),
+            ({'A': {'DataType': 'String', 'BinaryValue': "AAE="}}, "a String with BinaryValue", "must use field 'String'"),
+            ({'A': {'DataType': 'Binary', 'StringValue': "x", 'BinaryValue': "AAE="}}, "a Binary with StringValue", "must use field 'Binary'"),
+            ({'A': {'DataType': 'Number', 'StringValue': "twelve"}}, "a non-numeric Number", "to a number"),
+            ({'A': {'DataType': 'Number.int', 'StringValue': "NaN"}}, "NaN", "to a number"),
+            ({'A': {'DataType': 'Number', 'StringValue': "0x10"}}, "a hex Number", "to a number"),
+        ]:
+            response = send(attributes)
+            assert response.status_code == 400 and 'InvalidParameterValue' in response.text, \
+                f"Expected {reason} to be rejected: {response.text}"
+            assert expected in response.text, f"Unexpected error for {reason}: {response.text}"
+        print_success("Too many attributes, bad types, missing or misplaced values and non-numeric Numbers are rejected")
+
+        response = sqs_request('SendMessage', {
+            'QueueUrl': queue_url, 'MessageBody': "x",
+            'MessageAttribute.1.Name': 'Count',
+            'MessageAttribute.1.Value.DataType': 'Number',
+            'MessageAttribute.1.Value.StringValue': "1,000"
+        })
+        assert response.status_code == 400 and 'InvalidParameterValue' in response.text, \
+            f"Query protocol Number was not validated: {response.text}"
+        response = sqs_json_request('SendMessageBatch', {'QueueUrl': queue_url, 'Entries': [
+            {'Id': 'good', 'MessageBody': "x", 'MessageAttributes': valid},
+            {'Id': 'bad', 'MessageBody': "x", 'MessageAttributes': {'A': {'DataType': 'Bool', 'StringValue': "true"}}},
+        ]})
+        data = response.json()
+        assert [e['Id'] for e in data.get('Successful', [])] == ['good'], f"Unexpected result: {data}"
+        assert [(e['Id'], e['Code']) for e in data['Failed']] == [('bad', 'InvalidParameterValue')], f"Unexpected result: {data}"
+        assert get_admin_queue(queue_name)['message_count'] == 2, "A rejected message was stored"
+        print_success("Query requests and batch entries are validated the same way")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def attributes_md5(attributes):
+    """MD5 over message attributes the way SQS computes it"""
+    data = b''
+    for name in sorted(attributes):
+        attr = attributes[name]
+        for field in (name, attr['DataType']):
+            encoded = field.encode()
+            data += struct.pack('>I', len(encoded)) + encoded
+        if 'BinaryValue' in attr:
+            value = base64.b64decode(attr['BinaryValue'])
+            data += b'\x02' + struct.pack('>I', len(value)) + value
+        else:
+            value = attr['StringValue'].encode()
+            data += b'\x01' + struct.pack('>I', len(value)) + value
+    return hashlib.md5(data).hexdigest()
+
+def test_md5_of_system_attributes():
+    print_test("MD5OfMessageSystemAttributes")
+    queue_name = "test-system-md5-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+    trace = "Root=1-5759e988-bd862e3fe1be46a994272793;Sampled=1"
+    expected = attributes_md5({'AWSTraceHeader': {'DataType': 'String', 'StringValue': trace}})
+    try:
+        response = sqs_json_request('SendMessage', {
+            'QueueUrl': queue_url, 'MessageBody': "traced",
+            'MessageSystemAttributes': {'AWSTraceHeader': {'DataType': 'String', 'StringValue': trace}}
+        })
+        digest = response.json().get('MD5OfMessageSystemAttributes')
+        assert digest == expected, f"Expected {expected}, got {digest}"
+        print_success("JSON SendMessage returns the MD5 of the system attributes")
+
+        response = sqs_request('SendMessage', {
+            'QueueUrl': queue_url, 'MessageBody': "traced",
+            'MessageSystemAttribute.1.Name': 'AWSTraceHeader',
+            'MessageSystemAttribute.1.Value.DataType': 'String',
+            'MessageSystemAttribute.1.Value.StringValue': trace
+        })
+        assert f"<MD5OfMessageSystemAttributes>{expected}</MD5OfMessageSystemAttributes>" in response.text, \
+            f"Digest missing from XML response: {response.text}"
+        print_success("Query SendMessage returns the MD5 of the system attributes")
+
+        response = sqs_json_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "untraced"})
+        assert 'MD5OfMessageSystemAttributes' not in response.json(), f"Unexpected digest: {response.json()}"
+        response = sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "untraced"})
+        assert 'MD5OfMessageSystemAttributes' not in response.text, f"Unexpected digest: {response.text}"
+        print_success("The field is omitted without system attributes")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_multibyte_body_md5():
+    print_test("MD5 of Multibyte Message Bodies")
+    queue_name = "test-multibyte-md5"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+    ns = '{http://queue.amazonaws.com/doc/2012-11-05/}'
+
+    # AWS hashes the UTF-8 bytes of the body as sent, before any escaping
+    bodies = [
+        "emoji \U0001F600\U0001F680 and a flag \U0001F1EF\U0001F1F5",
+        "\u4e2d\u6587\u3001\u65e5\u672c\u8a9e\u3001\ud55c\uad6d\uc5b4",
+        "e\u0301 <tag attr=\"&amp;\">'quoted'</tag>",
+    ]
+    expected = {hashlib.md5(body.encode('utf-8')).hexdigest(): body for body in bodies}
+
+    try:
+        for body in bodies:
+            md5 = hashlib.md5(body.encode('utf-8')).hexdigest()
+            response = sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': body})
+            assert ET.fromstring(response.content).find(f'.//{ns}MD5OfMessageBody').text == md5, \
+                f"Query MD5 mismatch for {body!r}: {response.text}"
+            response = sqs_json_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': body})
+            assert response.json()['MD5OfMessageBody'] == md5, f"JSON MD5 mismatch for {body!r}"
+        response = sqs_json_request('SendMessageBatch', {'QueueUrl': queue_url, 'Entries': [
+            {'Id': str(i), 'MessageBody': body} for i, body in enumerate(bodies)
+        ]})
+        for entry in response.json()['Successful']:
+            assert expected[entry['MD5OfMessageBody']] == bodies[int(entry['Id'])], f"Batch MD5 mismatch: {entry}"
+        print_success("SendMessage and SendMessageBatch return the MD5 of the UTF-8 body")
+
+        response = sqs_request('ReceiveMessage', {
+            'QueueUrl': queue_url, 'MaxNumberOfMessages': '10', 'VisibilityTimeout': '0'
+        })
+        assert 'charset=utf-8' in response.headers['Content-Type'], \
+            f"XML responses don't declare their charset: {response.headers['Content-Type']}"
+        messages = ET.fromstring(response.content).findall(f'.//{ns}Message')
+        assert messages, "No messages received"
+        for message in messages:
+            body, md5 = message.find(f'{ns}Body').text, message.find(f'{ns}MD5OfBody').text
+            assert expected.get(md5) == body, f"Query receive returned {body!r} with MD5 {md5}"
+        response = sqs_json_request('ReceiveMessage', {'QueueUrl': queue_url, 'MaxNumberOfMessages': 10})
+        for message in response.json()['Messages']:
+            assert expected.get(message['MD5OfBody']) == message['Body'], f"JSON receive mismatch: {message}"
+        print_success("Received bodies are unchanged and match their MD5OfBody in both protocols")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_message_system_attributes():
+    print_test("Message System Attributes (AWSTraceHeader)")
+    queue_name = "test-system-attributes-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    trace_header = "Root=1-5759e988-bd862e3fe1be46a994272793;Sampled=1"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+
+    try:
+        sqs_request('SendMessage', {
+            'QueueUrl': queue_url,
+            'MessageBody': "traced",
+            'MessageSystemAttribute.1.Name': 'AWSTraceHeader',
+            'MessageSystemAttribute.1.Value.StringValue': trace_header,
+            'MessageSystemAttribute.1.Value.DataType': 'String'
+        })
+        response = sqs_request('ReceiveMessage', {
+            'QueueUrl': queue_url,
+            'AttributeName.1': 'AWSTraceHeader',
+            'VisibilityTimeout': '0'
+        })
+        assert '<Name>AWSTraceHeader</Name>' in response.text, f"Trace header missing: {response.text}"
+        assert f"<Value>{trace_header}</Value>" in response.text, "Trace header value wrong"
+        print_success("Query protocol round-trips AWSTraceHeader")
+
+        response = sqs_request('ReceiveMessage', {'QueueUrl': queue_url, 'VisibilityTimeout': '0'})
+        assert 'AWSTraceHeader' not in response.text, "Attribute returned without being requested"
+        print_success("Attribute is only returned when requested")
+
+        sqs_json_request('SendMessage', {
+            'QueueUrl': queue_url,
+            'MessageBody': "traced json",
+            'MessageSystemAttributes': {
+                'AWSTraceHeader': {'StringValue': trace_header, 'DataType': 'String'}
+            }
+        })
+        response = sqs_json_request('ReceiveMessage', {
+            'QueueUrl': queue_url,
+            'MaxNumberOfMessages': 10,
+            'MessageSystemAttributeNames': ['All']
+        })
+        messages = [m for m in response.json()['Messages'] if m['Body'] == "traced json"]
+        attributes = messages[0]['Attributes']
+        assert attributes['AWSTraceHeader'] == trace_header, f"Unexpected: {messages}"
+        assert attributes['ApproximateReceiveCount'] == '1', f"Unexpected: {messages}"
+        assert attributes['ApproximateFirstReceiveTimestamp'] and attributes['SentTimestamp'], f"Unexpected: {messages}"
+        print_success("JSON protocol round-trips AWSTraceHeader")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_fifo_receive_attributes():
+    print_test("FIFO Receive Attributes")
+    queue_name = "test-fifo-attributes"
+    fifo_name = "test-fifo-attributes.fifo"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+    sqs_request('CreateQueue', {
+        'QueueName': fifo_name,
+        'Attribute.1.Name': 'FifoQueue', 'Attribute.1.Value': 'true'
+    })
+
+    try:
+        sqs_request('SendMessage', {
+            'QueueUrl': f"{BASE_URL}/{fifo_name}",
+            'MessageBody': "fifo attributes",
+            'MessageGroupId': 'g2',
+            'MessageDeduplicationId': 'd3'
+        })
+        response = sqs_request('ReceiveMessage', {
+            'QueueUrl': f"{BASE_URL}/{fifo_name}",
+            'AttributeName.1': 'MessageGroupId',
+            'AttributeName.2': 'SequenceNumber'
+        })
+        assert '<Name>MessageGroupId</Name>' in response.text and '<Value>g2</Value>' in response.text, \
+            f"MessageGroupId missing: {response.text}"
+        assert '<Name>SequenceNumber</Name>' in response.text, "SequenceNumber missing"
+        assert 'MessageDeduplicationId' not in response.text, "Unrequested attribute returned"
+        print_success("FIFO receive returns the requested SequenceNumber and MessageGroupId")
+
+        # Sequence numbers are 20 digits like SQS's, so string order is numeric order
+        sequence_numbers = []
+        for i, group in enumerate(['g1', 'g2', 'g1']):
+            response = sqs_json_request('SendMessage', {
+                'QueueUrl': f"{BASE_URL}/{fifo_name}",
+                'MessageBody': f"sequenced {i}",
+                'MessageGroupId': group,
+                'MessageDeduplicationId': f"seq-{i}"
+            })
+            sequence_numbers.append(response.json()['SequenceNumber'])
+        assert all(re.fullmatch(r'[1-9]\d{19}', n) for n in sequence_numbers), \
+            f"Sequence numbers are not 20 digits: {sequence_numbers}"
+        assert sequence_numbers == sorted(sequence_numbers, key=int) == sorted(sequence_numbers) \
+            and len(set(sequence_numbers)) == 3, f"Sequence numbers don't increase across groups: {sequence_numbers}"
+        print_success("Sequence numbers are 20 digits and increase across the queue")
+
+        response = sqs_request('SendMessage', {'QueueUrl': f"{BASE_URL}/{queue_name}", 'MessageBody': "standard"})
+        assert 'SequenceNumber' not in response.text, f"Standard queue send returned SequenceNumber: {response.text}"
+        response = sqs_request('ReceiveMessage', {
+            'QueueUrl': f"{BASE_URL}/{queue_name}",
+            'AttributeName.1': 'All'
+        })
+        assert '<Body>standard</Body>' in response.text, f"Message missing: {response.text}"
+        assert 'SequenceNumber' not in response.text, "Standard queue returned SequenceNumber"
+        print_success("Standard queue omits FIFO attributes")
+    finally:
+        for name in [queue_name, fifo_name]:
+            sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{name}"})
+
+def test_fifo_sequence_numbers_concurrent():
+    print_test("FIFO Sequence Numbers Under Concurrent Sends")
+    queue_name = "test-sequence-concurrent.fifo"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name, 'Attribute.1.Name': 'FifoQueue', 'Attribute.1.Value': 'true'})
+
+    # 8 senders each send the same 30 deduplication IDs in a different
+    # order, so most sends are duplicates racing the one that enqueues
+    unique_ids = 30
+    results = []
+    lock = threading.Lock()
+
+    def sender(offset):
+        for i in range(unique_ids):
+            dedup_id = f"dedup-{(i + offset) % unique_ids}"
+            response = sqs_json_request('SendMessage', {
+                'QueueUrl': queue_url, 'MessageBody': dedup_id,
+                'MessageGroupId': f"group-{offset % 3}", 'MessageDeduplicationId': dedup_id
+            })
+            with lock:
+                results.append(response)
+
+    try:
+        senders = [threading.Thread(target=sender, args=(n * 4,)) for n in range(8)]
+        for t in senders:
+            t.start()
+        for t in senders:
+            t.join()
+
+        assert all(r.status_code == 200 for r in results), "A send failed"
+        sent = {}
+        for r in results:
+            body = r.json()
+            sent.setdefault(body['MessageId'], set()).add(body['SequenceNumber'])
+        assert len(sent) == unique_ids, f"Expected {unique_ids} messages, got {len(sent)}"
+        assert all(len(numbers) == 1 for numbers in sent.values()), "Duplicates returned a different sequence number"
+        print_success(f"{len(results)} sends enqueued {unique_ids} messages")
+
+        numbers = sorted(int(n) for numbers in sent.values() for n in numbers)
+        assert numbers == list(range(numbers[0], numbers[0] + unique_ids)), f"Sequence numbers have gaps: {numbers}"
+        stored = sorted(int(m['sequence_number']) for m in get_admin_queue(queue_name)['messages'])
+        assert stored == numbers, "Stored sequence numbers differ from the ones returned"
+        print_success("Enqueued messages have contiguous sequence numbers")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_content_dedup_with_attributes():
+    print_test("Content-Based Deduplication and Message Attributes")
+    queue_name = "test-content-dedup.fifo"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_json_request('CreateQueue', {
+        'QueueName': queue_name,
+        'Attributes': {'FifoQueue': 'true', 'ContentBasedDeduplication': 'true'}
+    })
+
+    def send(color):
+        response = sqs_json_request('SendMessage', {
+            'QueueUrl': queue_url,
+            'MessageBody': 'same body',
+            'MessageGroupId': 'g1',
+            'MessageAttributes': {'color': {'DataType': 'String', 'StringValue': color}}
+        })
+        assert response.status_code == 200, f"SendMessage failed: {response.text}"
+        return response.json()['MessageId']
+
+    try:
+        first = send('red')
+        assert send('red') == first, "Identical body and attributes were not deduplicated"
+        print_success("Same body and attributes are deduplicated")
+
+        if send('blue') == first:
+            print_info("Server hashes only the body, as SQS does; run with server.dedup_include_attributes to test attribute hashing")
+            return
+        print_success("Same body with different attributes is not deduplicated")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_deduplication_window():
+    print_test("Configurable Deduplication Window")
+    queue_name = "test-dedup-window.fifo"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    response = requests.post(f"{BASE_URL}/admin/api/queue", json={
+        'name': queue_name,
+        'deduplication_window_seconds': 1,
+        'attributes': {'FifoQueue': 'true'}
+    })
+    assert response.status_code == 200, f"Queue creation failed: {response.text}"
+
+    def send():
+        response = sqs_json_request('SendMessage', {
+            'QueueUrl': queue_url,
+            'MessageBody': 'windowed',
+            'MessageGroupId': 'g1',
+            'MessageDeduplicationId': 'dedup-1'
+        })
+        assert response.status_code == 200, f"SendMessage failed: {response.text}"
+        return response.json()['MessageId']
+
+    try:
+        assert get_admin_queue(queue_name)['deduplication_window_seconds'] == 1, "Window missing from admin details"
+        print_success("Admin details report the 1 second window")
+
+        first = send()
+        assert send() == first, "Duplicate inside the window was not deduplicated"
+        print_success("Duplicate inside the window is deduplicated")
+
+        time.sleep(1.5)
+        assert send() != first, "Duplicate after the window was deduplicated"
+        assert len(get_admin_queue(queue_name)['messages']) == 2, "Expected both messages in the queue"
+        print_success("Duplicate after the window is accepted as a new message")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_deduplication_after_delete():
+    print_test("Deduplication After the Original Is Deleted")
+    queue_name = "test-dedup-deleted.fifo"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_json_request('CreateQueue', {'QueueName': queue_name, 'Attributes': {'FifoQueue': 'true'}})
+
+    def send():
+        response = sqs_json_request('SendMessage', {
+            'QueueUrl': queue_url,
+            'MessageBody': 'consumed quickly',
+            'MessageGroupId': 'g1',
+            'MessageDeduplicationId': 'dedup-deleted'
+        })
+        assert response.status_code == 200, f"SendMessage failed: {response.text}"
+        return response.json()
+
+    try:
+        first = send()
+        response = sqs_json_request('ReceiveMessage', {'QueueUrl': queue_url})
+        messages = response.json().get('Messages', [])
+        assert [m['MessageId'] for m in messages] == [first['MessageId']], f"Unexpected receive: {messages}"
+        response = sqs_json_request('DeleteMessage', {'QueueUrl': queue_url, 'ReceiptHandle': messages[0]['ReceiptHandle']})
+        assert response.status_code == 200, f"DeleteMessage failed: {response.text}"
+
+        duplicate = send()
+        for field in ['MessageId', 'SequenceNumber', 'MD5OfMessageBody']:
+            assert duplicate[field] == first[field], f"{field} changed: {first[field]} then {duplicate[field]}"
+        assert get_admin_queue(queue_name)['message_count'] == 0, "The duplicate was enqueued"
+        response = sqs_json_request('ReceiveMessage', {'QueueUrl': queue_url})
+        assert not response.json().get('Messages'), "The duplicate was delivered"
+        print_success("A duplicate of a deleted message gets the original's MessageId and SequenceNumber and isn't enqueued")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_fifo_queue_naming():
+    print_test("FIFO Queue Naming")
+    suffix_only = "test-fifo-suffix-only.fifo"
+    try:
+        response = sqs_request('CreateQueue', {'QueueName': suffix_only})
+        assert response.status_code == 200, f"Suffix-only CreateQueue failed: {response.text}"
+        assert get_admin_queue(suffix_only)['fifo_queue'], "Queue named .fifo is not a FIFO queue"
+        print_success("A .fifo name without the FifoQueue attribute creates a FIFO queue")
+
+        response = sqs_request('CreateQueue', {
+            'QueueName': "test-fifo-attribute-only",
+            'Attribute.1.Name': 'FifoQueue', 'Attribute.1.Value': 'true'
+        })
+        assert response.status_code == 400, f"Expected 400, got {response.status_code}"
+        assert 'InvalidParameterValue' in response.text, f"Unexpected error: {response.text}"
+        assert get_admin_queue("test-fifo-attribute-only") is None, "Queue was created anyway"
+        print_success("FifoQueue=true without the .fifo suffix is rejected")
+
+        response = sqs_request('CreateQueue', {
+            'QueueName': "test-fifo-attribute-false.fifo",
+            'Attribute.1.Name': 'FifoQueue', 'Attribute.1.Value': 'false'
+        })
+        assert response.status_code == 400, f"Expected 400, got {response.status_code}"
+        print_success("FifoQueue=false with the .fifo suffix is rejected")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{suffix_only}"})
+
+def test_set_content_based_deduplication():
+    print_test("SetQueueAttributes ContentBasedDeduplication")
+    fifo_name = "test-set-dedup.fifo"
+    standard_name = "test-set-dedup-standard"
+    fifo_url = f"{BASE_URL}/{fifo_name}"
+    standard_url = f"{BASE_URL}/{standard_name}"
+    sqs_request('CreateQueue', {'QueueName': fifo_name})
+    sqs_request('CreateQueue', {'QueueName': standard_name})
+
+    def send():
+        return sqs_json_request('SendMessage', {
+            'QueueUrl': fifo_url, 'MessageBody': "same body", 'MessageGroupId': "group"
+        })
+
+    try:
+        response = send()
+        assert response.status_code == 400, f"Send without a deduplication ID was accepted: {response.text}"
+        print_success("Implicit deduplication is off by default")
+
+        response = sqs_request('SetQueueAttributes', {
+            'QueueUrl': fifo_url,
+            'Attribute.1.Name': 'ContentBasedDeduplication', 'Attribute.1.Value': 'true'
+        })
+        assert response.status_code == 200, f"SetQueueAttributes failed: {response.text}"
+        first, second = send(), send()
+        assert first.status_code == 200, f"Send failed after enabling deduplication: {first.text}"
+        assert first.json()['MessageId'] == second.json()['MessageId'], "Duplicate send was not deduplicated"
+        assert get_admin_queue(fifo_name)['message_count'] == 1, "Duplicate message was stored"
+        print_success("Enabling ContentBasedDeduplication at runtime deduplicates identical bodies")
+
+        response = sqs_json_request('SetQueueAttributes', {
+            'QueueUrl': fifo_url, 'Attributes': {'ContentBasedDeduplication': 'false'}
+        })
+        assert response.status_code == 200, f"SetQueueAttributes failed: {response.text}"
+        assert send().status_code == 400, "Send without a deduplication ID was accepted after disabling"
+        print_success("Disabling it requires explicit deduplication IDs again")
+
+        response = sqs_request('SetQueueAttributes', {
+            'QueueUrl': standard_url,
+            'Attribute.1.Name': 'ContentBasedDeduplication', 'Attribute.1.Value': 'true'
+        })
+        assert response.status_code == 400, f"Expected 400, got {response.status_code}"
+        assert 'InvalidAttributeName' in response.text, f"Unexpected error: {response.text}"
+        print_success("ContentBasedDeduplication is rejected on a standard queue")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': fifo_url})
+        sqs_request('DeleteQueue', {'QueueUrl': standard_url})
+
+def test_fifo_high_throughput():
+    print_test("FIFO high throughput mode")
+    fifo_name = "test-high-throughput.fifo"
+    standard_name = "test-high-throughput-standard"
+    fifo_url = f"{BASE_URL}/{fifo_name}"
+    standard_url = f"{BASE_URL}/{standard_name}"
+    sqs_request('CreateQueue', {'QueueName': standard_name})
+
+    def send(group):
+        return sqs_json_request('SendMessage', {
+            'QueueUrl': fifo_url, 'MessageBody': f"body for {group}",
+            'MessageGroupId': group, 'MessageDeduplicationId': "shared-id"
+        })
+
+    try:
+        response = sqs_json_request('CreateQueue', {
+            'QueueName': fifo_name,
+            'Attributes': {'FifoQueue': 'true', 'FifoThroughputLimit': 'perMessageGroupId'}
+        })
+        assert response.status_code == 400, f"perMessageGroupId with queue scope was accepted: {response.text}"
+        print_success("perMessageGroupId requires messageGroup deduplication scope")
+
+        response = sqs_json_request('CreateQueue', {
+            'QueueName': fifo_name,
+            'Attributes': {
+                'FifoQueue': 'true',
+                'DeduplicationScope': 'messageGroup',
+                'FifoThroughputLimit': 'perMessageGroupId'
+            }
+        })
+        assert response.status_code == 200, f"CreateQueue failed: {response.text}"
+
+        response = sqs_json_request('GetQueueAttributes', {'QueueUrl': fifo_url, 'AttributeNames': ['All']})
+        attributes = response.json()['Attributes']
+        assert attributes.get('DeduplicationScope') == 'messageGroup', f"Unexpected attributes: {attributes}"
+        assert attributes.get('FifoThroughputLimit') == 'perMessageGroupId', f"Unexpected attributes: {attributes}"
+        print_success("GetQueueAttributes reports the high throughput settings")
+
+        first, second, repeat = send("g1"), send("g2"), send("g1")
+        assert first.status_code == 200, f"Send failed: {first.text}"
+        assert second.status_code == 200, f"Send failed: {second.text}"
+        assert first.json()['MessageId'] != second.json()['MessageId'], "Groups share a deduplication scope"
+        assert first.json()['MessageId'] == repeat.json()['MessageId'], "Duplicate within a group was not deduplicated"
+        assert get_admin_queue(fifo_name)['message_count'] == 2, "Unexpected number of stored messages"
+        print_success("Deduplication IDs are scoped to their message group")
+
+        response = sqs_json_request('SetQueueAttributes', {
+            'QueueUrl': standard_url, 'Attributes': {'DeduplicationScope': 'messageGroup'}
+        })
+        assert response.status_code == 400, f"Expected 400, got {response.status_code}"
+        assert 'InvalidAttributeName' in response.text, f"Unexpected error: {response.text}"
+        print_success("High throughput attributes are rejected on a standard queue")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': fifo_url})
+        sqs_request('DeleteQueue', {'QueueUrl': standard_url})
+
+def test_fifo_parameters_on_standard_queue():
+    print_test("FIFO Parameters on a Standard Queue")
+    queue_name = "test-standard-dedup-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+    try:
+        for params in [{'MessageDeduplicationId': "d1"}, {'MessageGroupId': "g1"}]:
+            response = sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "not fifo", **params})
+            assert response.status_code == 400 and 'InvalidParameterValue' in response.text, \
+                f"{list(params)[0]} accepted on a standard queue: {response.text}"
+        print_success("SendMessage rejects MessageDeduplicationId and MessageGroupId with InvalidParameterValue")
+
+        response = sqs_json_request('SendMessageBatch', {'QueueUrl': queue_url, 'Entries': [
+            {'Id': "plain", 'MessageBody': "plain"},
+            {'Id': "dedup", 'MessageBody': "dedup", 'MessageDeduplicationId': "d2"}
+        ]})
+        assert response.status_code == 200, f"Batch failed: {response.text}"
+        result = response.json()
+        assert [e['Id'] for e in result['Successful']] == ["plain"], f"Unexpected successes: {result}"
+        assert [(e['Id'], e['Code']) for e in result['Failed']] == [("dedup", "InvalidParameterValue")], \
+            f"Unexpected failures: {result}"
+        assert get_admin_queue(queue_name)['message_count'] == 1, "Only the valid entry should be sent"
+        print_success("SendMessageBatch fails just the entries that carry FIFO parameters")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_fifo_group_fairness():
+    print_test("FIFO Receive Group Fairness")
+    queue_name = "test-fifo-fairness.fifo"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {
+        'QueueName': queue_name,
+        'Attribute.1.Name': 'FifoQueue', 'Attribute.1.Value': 'true'
+    })
+
+    def bodies(response):
+        return sorted(part.split('</Body>')[0] for part in response.text.split('<Body>')[1:])
+
+    try:
+        for i in range(3):
+            for group in range(5):
+                sqs_request('SendMessage', {
+                    'QueueUrl': queue_url,
+                    'MessageBody': f"g{group}-m{i}",
+                    'MessageGroupId': f"g{group}",
+                    'MessageDeduplicationId': f"g{group}-m{i}"
+                })
+
+        first_response = sqs_request('ReceiveMessage', {'QueueUrl': queue_url, 'MaxNumberOfMessages': '10'})
+        heads = bodies(first_response)
+        assert heads == [f"g{group}-m0" for group in range(5)], f"Expected the head of each group, got {heads}"
+        print_success("Receive of 10 returns the heads of all 5 groups")
+
+        response = sqs_request('ReceiveMessage', {'QueueUrl': queue_url, 'MaxNumberOfMessages': '10'})
+        assert bodies(response) == [], f"Blocked groups yielded messages: {bodies(response)}"
+        print_success("Groups with a message in flight yield nothing")
+
+        head_handle = None
+        for part in first_response.text.split('<Message>')[1:]:
+            if '<Body>g0-m0</Body>' in part:
+                head_handle = part.split('<ReceiptHandle>')[1].split('</ReceiptHandle>')[0]
+        sqs_request('DeleteMessage', {'QueueUrl': queue_url, 'ReceiptHandle': head_handle})
+        response = sqs_request('ReceiveMessage', {'QueueUrl': queue_url, 'MaxNumberOfMessages': '10'})
+        assert bodies(response) == ["g0-m1"], f"Expected only g0's next message, got {bodies(response)}"
+        print_success("Deleting a group's head unblocks only that group, in order")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_admin_receive_from_group():
+    print_test("Admin API - Receive From a Message Group")
+    queue_name = "test-group-receive.fifo"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    receive_url = f"{BASE_URL}/admin/api/queues/{queue_name}/receive"
+    sqs_request('CreateQueue', {
+        'QueueName': queue_name,
+        'Attribute.1.Name': 'FifoQueue', 'Attribute.1.Value': 'true'
+    })
+    sqs_request('CreateQueue', {'QueueName': 'test-group-receive-standard'})
+
+    def receive(**params):
+        response = requests.post(receive_url, params=params)
+        assert response.status_code == 200, f"Receive failed: {response.text}"
+        return [m['body'] for m in response.json()['messages']]
+
+    try:
+        for i in range(2):
+            for group in ['a', 'b']:
+                sqs_request('SendMessage', {
+                    'QueueUrl': queue_url,
+                    'MessageBody': f"{group}-m{i}",
+                    'MessageGroupId': group,
+                    'MessageDeduplicationId': f"{group}-m{i}"
+                })
+
+        assert receive(group_id='b', visibility_timeout='1') == ["b-m0"], "Did not receive the head of group b"
+        assert receive(group_id='b') == [], "Group b's next message overtook its in-flight head"
+        print_success("group_id receives only that group's head, and an in-flight head blocks the group")
+
+        response = requests.post(receive_url, params={'group_id': 'a'})
+        message = response.json()['messages'][0]
+        assert message['body'] == "a-m0" and message['state'] == 'in_flight', f"Unexpected message: {message}"
+        response = sqs_request('DeleteMessage', {'QueueUrl': queue_url, 'ReceiptHandle': message['receipt_handle']})
+        assert response.status_code == 200, f"Receipt handle from the admin receive was rejected: {response.text}"
+        assert receive(group_id='a') == ["a-m1"], "Deleting group a's head did not unblock it"
+        assert receive(group_id='c') == [], "An unknown group returned messages"
+        print_success("Received messages carry usable receipt handles and groups advance in order")
+
+        time.sleep(1.2)
+        assert receive(max_messages='10') == ["b-m0"], "Receive without group_id did not pick from the queue"
+        print_success("Without group_id the queue picks the messages as ReceiveMessage would")
+
+        response = requests.post(f"{BASE_URL}/admin/api/queues/test-group-receive-standard/receive", params={'group_id': 'a'})
+        assert response.status_code == 400, f"Expected 400 for a standard queue, got {response.status_code}"
+        response = requests.post(f"{BASE_URL}/admin/api/queues/test-no-such-queue/receive")
+        assert response.status_code == 404, f"Expected 404, got {response.status_code}"
+        print_success("Standard queues reject group_id and unknown queues return 404")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+        sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/test-group-receive-standard"})
+
+def test_fifo_order_across_deletes():
+    print_test("FIFO Order Across Deletes")
+    queue_name = "test-fifo-deletes.fifo"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {
+        'QueueName': queue_name,
+        'Attribute.1.Name': 'FifoQueue', 'Attribute.1.Value': 'true'
+    })
+    try:
+        for i in range(20):
+            sqs_request('SendMessage', {
+                'QueueUrl': queue_url,
+                'MessageBody': f"m{i}",
+                'MessageGroupId': "group",
+                'MessageDeduplicationId': f"m{i}"
+            })
+
+        for i in range(20):
+            response = sqs_json_request('ReceiveMessage', {'QueueUrl': queue_url})
+            messages = response.json().get('Messages', [])
+            assert [m['Body'] for m in messages] == [f"m{i}"], f"Expected m{i}, got {messages}"
+            sqs_request('DeleteMessage', {'QueueUrl': queue_url, 'ReceiptHandle': messages[0]['ReceiptHandle']})
+            if i == 9:
+                queue = get_admin_queue(queue_name)
+                assert queue['message_count'] == 10, f"Expected 10 messages left, got {queue['message_count']}"
+                assert len(queue['messages']) == 10, f"Deleted messages still listed: {len(queue['messages'])}"
+        print_success("Messages are received in order as earlier ones are deleted")
+        print_success("Deleted messages drop out of counts and listings immediately")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_get_queue_attributes(queue_name):
+    print_test("Get Queue Attributes")
+    queue_url = f"{BASE_URL}/{queue_name}"
+    
+    response = sqs_request('GetQueueAttributes', {
+        'QueueUrl': queue_url,
+        'AttributeName.1': 'All'
+    })
+    
+    assert response.status_code == 200, f"Get attributes failed: {response.status_code}"
+    assert 'ApproximateNumberOfMessages' in response.text, "Attributes not in response"
+    print_success(f"Retrieved attributes for '{queue_name}'")
+
+def test_cumulative_counters():
+    print_test("Cumulative Message Counters")
+    queue_name = "test-counter-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+
+    try:
+        for i in range(3):
+            sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': f"counted {i}"})
+        response = sqs_request('ReceiveMessage', {'QueueUrl': queue_url, 'MaxNumberOfMessages': '2'})
+        handle = response.text.split('<ReceiptHandle>')[1].split('</ReceiptHandle>')[0]
+        sqs_request('DeleteMessage', {'QueueUrl': queue_url, 'ReceiptHandle': handle})
+
+        response = sqs_json_request('GetQueueAttributes', {'QueueUrl': queue_url, 'AttributeNames': ['All']})
+        attrs = response.json()['Attributes']
+        counters = [attrs[f"EssQueueEss.NumberOfMessages{name}"] for name in ['Sent', 'Received', 'Deleted', 'MovedToDLQ']]
+        assert counters == ['3', '2', '1', '0'], f"Unexpected counters: {counters}"
+        assert attrs['ApproximateNumberOfMessages'] == '1', "Counters changed the depth gauges"
+        print_success("GetQueueAttributes returns sent/received/deleted/moved counters")
+
+        queue = get_admin_queue(queue_name)
+        counters = [queue[f"{name}_count"] for name in ['sent', 'received', 'deleted', 'dlq_moved']]
+        assert counters == [3, 2, 1, 0], f"Unexpected admin counters: {counters}"
+        print_success("Admin API reports the same counters")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_admin_metrics():
+    print_test("Admin API - CloudWatch-Style Metrics")
+    queue_name = "test-metrics-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+
+    def metrics():
+        response = requests.get(f"{BASE_URL}/admin/api/metrics")
+        assert response.status_code == 200, f"Metrics failed: {response.text}"
+        return response.json()['queues'][queue_name]
+
+    try:
+        sqs_request('ReceiveMessage', {'QueueUrl': queue_url})
+        sqs_request('ReceiveMessage', {'QueueUrl': queue_url, 'WaitTimeSeconds': '1'})
+        for i in range(3):
+            sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': f"metric {i}"})
+        response = sqs_request('ReceiveMessage', {'QueueUrl': queue_url})
+        handle = response.text.split('<ReceiptHandle>')[1].split('</ReceiptHandle>')[0]
+        sqs_request('DeleteMessage', {'QueueUrl': queue_url, 'ReceiptHandle': handle})
+
+        m = metrics()
+        assert (m['NumberOfMessagesSent'], m['NumberOfMessagesReceived'], m['NumberOfMessagesDeleted']) == (3, 1, 1), \
+            f"Unexpected message counters: {m}"
+        assert m['NumberOfEmptyReceives'] == 2, f"Expected 2 empty receives, one of them a long poll: {m}"
+        assert m['ApproximateNumberOfMessagesVisible'] == 2 and m['ApproximateNumberOfMessagesNotVisible'] == 0, \
+            f"Unexpected gauges: {m}"
+        assert 'ApproximateAgeOfOldestMessage' in m, f"Age missing: {m}"
+        print_success("Metrics use CloudWatch names and a long poll counts as one empty receive")
+
+        attrs = sqs_json_request('GetQueueAttributes', {'QueueUrl': queue_url, 'AttributeNames': ['All']}).json()['Attributes']
+        assert attrs['EssQueueEss.NumberOfEmptyReceives'] == '2', f"Empty receives missing from attributes: {attrs}"
+        assert get_admin_queue(queue_name)['empty_receive_count'] == 2, "Admin details disagree on empty receives"
+        print_success("GetQueueAttributes and the admin details return the empty receive counter too")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_age_of_oldest_message():
+    print_test("ApproximateAgeOfOldestMessage")
+    queue_name = "test-oldest-age-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+
+    def age():
+        response = sqs_json_request('GetQueueAttributes', {'QueueUrl': queue_url, 'AttributeNames': ['All']})
+        return int(response.json()['Attributes']['ApproximateAgeOfOldestMessage'])
+
+    try:
+        assert age() == 0, "Empty queue should report age 0"
+        sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "old"})
+        time.sleep(2.1)
+        sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "delayed", 'DelaySeconds': '60'})
+        assert age() >= 2, f"Expected the oldest message to be at least 2s old, got {age()}"
+        assert get_admin_queue(queue_name)['age_of_oldest_message'] >= 2, "Admin API disagrees"
+        print_success("Age of the oldest visible message is reported in GetQueueAttributes and the admin API")
+
+        sqs_request('ReceiveMessage', {'QueueUrl': queue_url})
+        assert age() == 0, f"In-flight and delayed messages should not count, got {age()}"
+        print_success("In-flight and delayed messages are ignored")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_message_state_counts():
+    print_test("Visible / Not Visible / Delayed Counts")
+    queue_name = "test-state-count-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+
+    def attribute(text, name):
+        start = text.find(f"<Name>{name}</Name>")
+        start = text.find('<Value>', start) + len('<Value>')
+        return int(text[start:text.find('</Value>', start)])
+
+    try:
+        sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "in flight"})
+        sqs_request('ReceiveMessage', {'QueueUrl': queue_url, 'VisibilityTimeout': '30'})
+        sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "visible"})
+        sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "delayed", 'DelaySeconds': '30'})
+
+        response = sqs_request('GetQueueAttributes', {'QueueUrl': queue_url, 'AttributeName.1': 'All'})
+        counts = {name: attribute(response.text, name) for name in [
+            'ApproximateNumberOfMessages',
+            'ApproximateNumberOfMessagesNotVisible',
+            'ApproximateNumberOfMessagesDelayed'
+        ]}
+        assert list(counts.values()) == [1, 1, 1], f"Unexpected counts: {counts}"
+
+        queue = get_admin_queue(queue_name)
+        states = {m['body']: m['state'] for m in queue['messages']}
+        assert states == {'in flight': 'in_flight', 'visible': 'visible', 'delayed': 'delayed'}, \
+            f"Unexpected states: {states}"
+        by_body = {m['body']: m for m in queue['messages']}
+        assert by_body['in flight'].get('visible_at'), "In-flight message is missing visible_at"
+        assert 'visible_at' not in by_body['visible'], "Never-received message has visible_at"
+        assert by_body['delayed']['delay_until'] > by_body['visible']['delay_until'], \
+            "Delayed message's delay_until is not later than an undelayed one"
+        assert (queue['visible_count'], queue['not_visible_count'], queue['delayed_count']) == (1, 1, 1)
+        print_success("GetQueueAttributes and the admin API classify messages the same way")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_state_counts_sum_near_boundaries():
+    print_test("State Counts Add Up Near Visibility Boundaries")
+    queue_name = "test-state-boundary-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    total = 30
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+
+    try:
+        for i in range(total):
+            sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': f"boundary {i}", 'DelaySeconds': str(i % 2)})
+        # Keep receiving with one-second timeouts, so messages keep crossing
+        # between visible and in flight while the counts are read
+        stop = threading.Event()
+        def churn():
+            while not stop.is_set():
+                sqs_request('ReceiveMessage', {'QueueUrl': queue_url, 'MaxNumberOfMessages': '5', 'VisibilityTimeout': '1'})
+                time.sleep(0.05)
+        worker = threading.Thread(target=churn)
+        worker.start()
+
+        try:
+            deadline = time.time() + 2.5
+            checks = 0
+            while time.time() < deadline:
+                attrs = sqs_json_request('GetQueueAttributes', {'QueueUrl': queue_url, 'AttributeNames': ['All']}).json()['Attributes']
+                counts = [int(attrs[name]) for name in [
+                    'ApproximateNumberOfMessages',
+                    'ApproximateNumberOfMessagesNotVisible',
+                    'ApproximateNumberOfMessagesDelayed'
+                ]]
+                assert sum(counts) == total, f"GetQueueAttributes counts {counts} don't add up to {total}"
+                queue = get_admin_queue(queue_name)
+                counts = [queue['visible_count'], queue['not_visible_count'], queue['delayed_count']]
+                assert sum(counts) == total and queue['message_count'] == total, \
+                    f"Admin counts {counts} don't add up to {queue['message_count']}"
+                checks += 1
+        finally:
+            stop.set()
+            worker.join()
+        print_success(f"Visible, in-flight and delayed counts added up to {total} in {checks} checks of both views")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_queue_permissions():
+    print_test("AddPermission and RemovePermission")
+    queue_name = "test-permission-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+
+    def get_policy():
+        response = sqs_json_request('GetQueueAttributes', {'QueueUrl': queue_url, 'AttributeNames': ['All']})
+        policy = response.json()['Attributes'].get('Policy')
+        return json.loads(policy) if policy else None
+
+    try:
+        response = sqs_request('AddPermission', {
+            'QueueUrl': queue_url,
+            'Label': 'send-access',
+            'AWSAccountId.1': '111122223333',
+            'ActionName.1': 'SendMessage'
+        })
+        assert response.status_code == 200, f"AddPermission failed: {response.text}"
+        response = sqs_json_request('AddPermission', {
+            'QueueUrl': queue_url,
+            'Label': 'consume-access',
+            'AWSAccountIds': ['111122223333', '444455556666'],
+            'Actions': ['ReceiveMessage', 'DeleteMessage']
+        })
+        assert response.status_code == 200, f"JSON AddPermission failed: {response.text}"
+
+        policy = get_policy()
+        statements = {s['Sid']: s for s in policy['Statement']}
+        assert statements['send-access'] == {
+            'Sid': 'send-access',
+            'Effect': 'Allow',
+            'Principal': {'AWS': 'arn:aws:iam::111122223333:root'},
+            'Action': 'SQS:SendMessage',
+            'Resource': queue_arn(queue_name)
+        }, f"Unexpected statement: {statements['send-access']}"
+        assert statements['consume-access']['Principal']['AWS'] == \
+            ['arn:aws:iam::111122223333:root', 'arn:aws:iam::444455556666:root']
+        assert statements['consume-access']['Action'] == ['SQS:ReceiveMessage', 'SQS:DeleteMessage']
+        print_success("AddPermission adds labelled statements, returned as the Policy attribute")
+
+        for params, reason in [
+            ({'Label': 'send-access', 'AWSAccountId.1': '111122223333', 'ActionName.1': 'SendMessage'}, "a duplicate label"),
+            ({'Label': 'bad-account', 'AWSAccountId.1': '1234', 'ActionName.1': 'SendMessage'}, "a malformed account ID"),
+            ({'Label': 'owner-only', 'AWSAccountId.1': '111122223333', 'ActionName.1': 'DeleteQueue'}, "an owner-only action")
+        ]:
+            response = sqs_request('AddPermission', {'QueueUrl': queue_url, **params})
+            assert response.status_code == 400 and 'InvalidParameterValue' in response.text, \
+                f"Expected {reason} to be rejected: {response.text}"
+        response = sqs_request('AddPermission', {'QueueUrl': queue_url, 'Label': 'no-actions', 'AWSAccountId.1': '111122223333'})
+        assert response.status_code == 400 and 'MissingParameter' in response.text, f"Missing actions accepted: {response.text}"
+        print_success("Duplicate labels, bad account IDs, owner-only actions and missing parameters are rejected")
+
+        response = sqs_request('RemovePermission', {'QueueUrl': queue_url, 'Label': 'send-access'})
+        assert response.status_code == 200, f"RemovePermission failed: {response.text}"
+        assert [s['Sid'] for s in get_policy()['Statement']] == ['consume-access']
+        response = sqs_request('RemovePermission', {'QueueUrl': queue_url, 'Label': 'send-access'})
+        assert response.status_code == 400 and 'InvalidParameterValue' in response.text, \
+            f"Removing an unknown label should fail: {response.text}"
+        sqs_json_request('RemovePermission', {'QueueUrl': queue_url, 'Label': 'consume-access'})
+        assert get_policy() is None, "The policy should be gone once its last statement is removed"
+        print_success("RemovePermission removes statements by label")
+
+        custom = {'Version': '2012-10-17', 'Statement': [{'Sid': 'custom', 'Effect': 'Deny', 'Principal': '*', 'Action': 'SQS:*'}]}
+        response = sqs_json_request('SetQueueAttributes', {'QueueUrl': queue_url, 'Attributes': {'Policy': json.dumps(custom)}})
+        assert response.status_code == 200, f"Setting Policy failed: {response.text}"
+        assert get_policy() == custom, f"Policy did not round-trip: {get_policy()}"
+        response = sqs_json_request('SetQueueAttributes', {'QueueUrl': queue_url, 'Attributes': {'Policy': 'not json'}})
+        assert response.status_code == 400, f"Invalid policy accepted: {response.text}"
+        print_success("The Policy attribute can be set directly and round-trips unchanged")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_purge_queue(queue_name):
+    print_test("Purge Queue")
+    queue_url = f"{BASE_URL}/{queue_name}"
+    
+    response = sqs_request('PurgeQueue', {
+        'QueueUrl': queue_url
+    })
+    
+    assert response.status_code == 200, f"Purge queue failed: {response.status_code}"
+    print_success(f"Queue '{queue_name}' purged")
+    
+    # Verify queue is empty
+    time.sleep(0.5)  # Brief pause
+    response = sqs_request('ReceiveMessage', {
+        'QueueUrl': queue_url,
+        'MaxNumberOfMessages': '10'
+    })
+    message_count = response.text.count('<MessageId>')
+    assert message_count == 0, f"Queue not empty after purge: {message_count} messages"
+    print_success("Verified queue is empty after purge")
+
+    response = sqs_request('PurgeQueue', {
+        'QueueUrl': queue_url
+    })
+    if response.status_code == 200:
+        print_info("Server running with server.purge_cooldown: 0, skipping cooldown check")
+        return
+    assert response.status_code == 400, f"Expected 400, got {response.status_code}"
+    assert 'AWS.SimpleQueueService.PurgeQueueInProgress' in response.text, f"Unexpected error: {response.text}"
+    print_success("Second purge within the cooldown is rejected")
+
+def test_queue_url_forms():
+    print_test("Queue URL Forms")
+    forms = {
+        'path': lambda name: f"/{name}",
+        'account path': lambda name: f"/000000000000/{name}",
+        'account URL': lambda name: f"{BASE_URL}/000000000000/{name}",
+        'trailing slash': lambda name: f"{BASE_URL}/000000000000/{name}/",
+        'relative path': lambda name: f"000000000000/{name}",
+        'URL without scheme': lambda name: f"{urlparse(BASE_URL).netloc}/000000000000/{name}",
+        'bare name': lambda name: name,
+    }
+    for i, (form, make_url) in enumerate(forms.items()):
+        queue_name = f"test-url-form-{i}"
+        sqs_request('CreateQueue', {'QueueName': queue_name})
+        try:
+            sqs_request('SendMessage', {'QueueUrl': make_url(queue_name), 'MessageBody': form})
+            assert get_admin_queue(queue_name)['message_count'] == 1, f"SendMessage via {form} missed the queue"
+            response = sqs_json_request('SendMessage', {'QueueUrl': make_url(queue_name), 'MessageBody': form})
+            assert response.status_code == 200, f"JSON SendMessage via {form} failed: {response.text}"
+            response = sqs_request('PurgeQueue', {'QueueUrl': make_url(queue_name)})
+            assert response.status_code == 200, f"PurgeQueue via {form} failed: {response.text}"
+            assert get_admin_queue(queue_name)['message_count'] == 0, f"PurgeQueue via {form} left messages"
+            print_success(f"{form} ({make_url(queue_name)}) resolves to {queue_name}")
+        finally:
+            sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{queue_name}"})
+
+    # A QueueUrl that names no queue is a malformed parameter, not a missing queue
+    for queue_url in ['', '   ', f"{BASE_URL}/", 'http://[bad']:
+        response = sqs_json_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': 'form'})
+        assert response.status_code == 400 and 'InvalidParameterValue' in response.json()['__type'], \
+            f"QueueUrl {queue_url!r}: {response.text}"
+        response = sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': 'form'})
+        assert '<Code>InvalidParameterValue</Code>' in response.text, f"Query QueueUrl {queue_url!r}: {response.text}"
+    response = sqs_json_request('SendMessage', {'MessageBody': 'form'})
+    assert 'InvalidParameterValue' in response.json()['__type'], f"Missing QueueUrl: {response.text}"
+    print_success("Empty and unparseable QueueUrls are InvalidParameterValue")
+
+    response = sqs_json_request('SendMessage', {'QueueUrl': f"{BASE_URL}/000000000000/test-no-such-queue",
+                                                'MessageBody': 'form'})
+    assert response.status_code == 400 and 'QueueDoesNotExist' in response.json()['__type'], \
+        f"Unknown queue: {response.text}"
+    print_success("A well-formed URL of a missing queue is NonExistentQueue")
+
+def test_delete_queue(queue_name):
+    print_test("Delete Queue")
+    queue_url = f"{BASE_URL}/{queue_name}"
+    
+    response = sqs_request('DeleteQueue', {
+        'QueueUrl': queue_url
+    })
+    
+    assert response.status_code == 200, f"Delete queue failed: {response.status_code}"
+    print_success(f"Queue '{queue_name}' deleted")
+
+def test_delete_and_purge_protocols():
+    print_test("DeleteQueue and PurgeQueue Response Protocols")
+    xml_name = "test-protocol-xml-queue"
+    json_name = "test-protocol-json-queue"
+    for name in [xml_name, json_name]:
+        sqs_request('CreateQueue', {'QueueName': name})
+        sqs_request('SendMessage', {'QueueUrl': f"{BASE_URL}/{name}", 'MessageBody': "purge me"})
+
+    try:
+        response = sqs_request('PurgeQueue', {'QueueUrl': f"{BASE_URL}/{xml_name}"})
+        assert response.status_code == 200, f"Query PurgeQueue failed: {response.text}"
+        assert ET.fromstring(response.content).tag.endswith('PurgeQueueResponse'), f"Unexpected body: {response.text}"
+        response = sqs_json_request('PurgeQueue', {'QueueUrl': f"{BASE_URL}/{json_name}"})
+        assert response.status_code == 200, f"JSON PurgeQueue failed: {response.text}"
+        assert response.headers['Content-Type'].startswith('application/x-amz-json'), \
+            f"Unexpected content type: {response.headers['Content-Type']}"
+        assert set(response.json()) <= {'ResponseMetadata'}, f"Unexpected body: {response.text}"
+        assert get_admin_queue(json_name)['message_count'] == 0, "JSON PurgeQueue left messages"
+        print_success("PurgeQueue answers Query requests with XML and JSON requests with an empty JSON result")
+
+        response = sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{xml_name}"})
+        assert response.status_code == 200, f"Query DeleteQueue failed: {response.text}"
+        assert ET.fromstring(response.content).tag.endswith('DeleteQueueResponse'), f"Unexpected body: {response.text}"
+        response = sqs_json_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{json_name}"})
+        assert response.status_code == 200, f"JSON DeleteQueue failed: {response.text}"
+        assert response.headers['Content-Type'].startswith('application/x-amz-json'), \
+            f"Unexpected content type: {response.headers['Content-Type']}"
+        assert set(response.json()) <= {'ResponseMetadata'}, f"Unexpected body: {response.text}"
+        assert get_admin_queue(json_name) is None, "JSON DeleteQueue did not delete the queue"
+        print_success("DeleteQueue answers Query requests with XML and JSON requests with an empty JSON result")
+
+        response = sqs_json_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{json_name}"})
+        assert response.status_code == 400 and response.json()['__type'].endswith('QueueDoesNotExist'), \
+            f"Unexpected error for a deleted queue: {response.text}"
+        print_success("Deleting a missing queue over JSON still returns QueueDoesNotExist")
+    finally:
+        for name in [xml_name, json_name]:
+            sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{name}"})
+
+def test_admin_api_with_messages():
+    print_test("Admin API with Messages")
+    
+    # Create queue and add messages
+    queue_name = "admin-test-queue"
+    response = sqs_request('CreateQueue', {'QueueName': queue_name})
+    queue_url = f"{BASE_URL}/{queue_name}"
+    
+    # Send 3 messages
+    for i in range(3):
+        sqs_request('SendMessage', {
+            'QueueUrl': queue_url,
+            'MessageBody': f"Admin test message #{i+1}"
+        })
+    
+    time.sleep(0.5)  # Brief pause for messages to be processed
+    
+    # Check admin API
+    response = requests.get(API_URL)
+    data = response.json()
+    
+    # Find our queue in the response
+    test_queue = None
+    for q in data['queues']:
+        if q['name'] == queue_name:
+            test_queue = q
+            break
+    
+    assert test_queue is not None, f"Queue '{queue_name}' not found in admin API"
+    assert test_queue['visible_count'] == 3, f"Expected 3 visible messages, got {test_queue['visible_count']}"
+    assert len(test_queue['messages']) == 3, f"Expected 3 messages in data, got {len(test_queue['messages'])}"
+    
+    print_success(f"Admin API correctly shows 3 messages in '{queue_name}'")
+    
+    # Cleanup
+    sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_admin_create_queue():
+    print_test("Admin API - Create Queue")
+    
+    queue_data = {
+        "name": "admin-test-queue",
+        "visibility_timeout": 60,
+        "message_retention_period": 86400,
+        "max_message_size": 131072
+    }
+    
+    response = requests.post(f"{BASE_URL}/admin/api/queue", json=queue_data)
+    assert response.status_code == 200, f"Failed to create queue via admin API: {response.text}"
+    
+    data = response.json()
+    assert data['success'] == True, "Expected success=true"
+    assert data['queue']['name'] == "admin-test-queue", f"Queue name mismatch: {data}"
+    assert data['queue']['visibility_timeout'] == 60, f"Visibility timeout mismatch: {data}"
+    
+    print_success("Queue created successfully via admin API")
+    
+def test_admin_send_message():
+    print_test("Admin API - Send Message")
+    
+    message_data = {
+        "queue_name": "admin-test-queue",
+        "message_body": "Test message from admin API",
+        "delay_seconds": 0,
+        "attributes": {}
+    }
+    
+    response = requests.post(f"{BASE_URL}/admin/api/message", json=message_data)
+    assert response.status_code == 200, f"Failed to send message via admin API: {response.text}"
+    
+    data = response.json()
+    assert data['success'] == True, "Expected success=true"
+    assert 'message_id' in data, "Missing message_id in response"
+    
+    print_success(f"Message sent successfully via admin API (ID: {data['message_id']})")
+    
+    # Verify message was sent by checking admin API
+    response = requests.get(f"{BASE_URL}/admin/api/queues")
+    data = response.json()
+    queues = {q['name']: q for q in data['queues']}
+    
+    admin_queue = queues.get('admin-test-queue')
+    assert admin_queue is not None, "Queue not found in admin API"
+    assert admin_queue['visible_count'] == 1, f"Expected 1 visible message, got {admin_queue['visible_count']}"
+    
+    print_success("Message visible in queue via admin API")
+
+def test_admin_bulk_send():
+    print_test("Admin API - Bulk Send with Templates")
+    queue_name = "test-bulk-send-queue"
+    requests.post(f"{BASE_URL}/admin/api/queue", json={'name': queue_name})
+
+    try:
+        response = requests.post(f"{BASE_URL}/admin/api/messages/bulk", json={
+            'queue_name': queue_name,
+            'count': 10,
+            'body_template': "{{.Index"
+        })
+        assert response.status_code == 400, f"Expected 400 for bad template, got {response.status_code}"
+        assert 'body_template' in response.text, f"Unexpected error: {response.text}"
+        assert len(get_admin_queue(queue_name)['messages']) == 0, "Messages sent despite bad template"
+        print_success("Invalid template is rejected before sending")
+
+        response = requests.post(f"{BASE_URL}/admin/api/messages/bulk", json={
+            'queue_name': queue_name,
+            'count': 10,
+            'body_template': "message {{.Index}} {{.UUID}} at {{.Timestamp}}"
+        })
+        assert response.status_code == 200, f"Bulk send failed: {response.text}"
+        assert response.json()['sent'] == 10, f"Unexpected result: {response.json()}"
+
+        bodies = [m['body'] for m in get_admin_queue(queue_name)['messages']]
+        assert len(bodies) == 10, f"Expected 10 messages, found {len(bodies)}"
+        for i in range(10):
+            assert any(b.startswith(f"message {i} ") for b in bodies), f"No body for index {i}"
+        assert len({b.split()[2] for b in bodies}) == 10, "UUIDs are not unique"
+        print_success("10 templated messages reflect their index")
+    finally:
+        requests.delete(f"{BASE_URL}/admin/api/queue?name={queue_name}")
+
+def test_admin_cors():
+    print_test("Admin API CORS")
+    response = requests.request('OPTIONS', API_URL, headers={
+        'Origin': 'http://localhost:3000',
+        'Access-Control-Request-Method': 'GET'
+    })
+    assert response.status_code == 204, f"Preflight returned {response.status_code}"
+    print_success("Preflight request is answered with 204")
+
+    response = requests.get(API_URL, headers={'Origin': 'http://not-allowed.example'})
+    assert response.headers.get('Access-Control-Allow-Origin') is None, "Unlisted origin was allowed"
+    print_success("Unlisted origins get no CORS headers")
+
+    response = requests.get(API_URL, headers={'Origin': 'http://localhost:3000'})
+    if response.headers.get('Access-Control-Allow-Origin') is None:
+        print_info("Server not configured with admin.cors.allowed_origins, skipping")
+        return
+    assert response.headers['Access-Control-Allow-Origin'] == 'http://localhost:3000'
+    print_success("Allowed origin is echoed in Access-Control-Allow-Origin")
+
+def read_ws_frame(sock):
+    """Read one unmasked server frame, returning (opcode, payload)"""
+    def read_exact(n):
+        data = b''
+        while len(data) < n:
+            chunk = sock.recv(n - len(data))
+            assert chunk, "WebSocket closed unexpectedly"
+            data += chunk
+        return data
+
+    head = read_exact(2)
+    length = head[1] & 0x7F
+    if length == 126:
+        length = int.from_bytes(read_exact(2), 'big')
+    elif length == 127:
+        length = int.from_bytes(read_exact(8), 'big')
+    return head[0] & 0x0F, read_exact(length)
+
+def test_admin_event_stream():
+    print_test("Admin Event Stream WebSocket")
+    queue_name = "test-event-stream-queue"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+
+    url = urlparse(BASE_URL)
+    key = base64.b64encode(os.urandom(16)).decode()
+
+    def handshake(sock, origin):
+        origin_header = f"Origin: {origin}\r\n" if origin else ""
+        sock.sendall((
+            f"GET /admin/ws HTTP/1.1\r\nHost: {url.netloc}\r\n{origin_header}"
+            f"Upgrade: websocket\r\nConnection: Upgrade\r\n"
+            f"Sec-WebSocket-Key: {key}\r\nSec-WebSocket-Version: 13\r\n\r\n"
+        ).encode())
+        response = b''
+        while b'\r\n\r\n' not in response:
+            response += sock.recv(1)
+        return response
+
+    # Browsers don't apply CORS to WebSockets, so the server checks the origin
+    with socket.create_connection((url.hostname, url.port), timeout=5) as sock:
+        response = handshake(sock, "http://evil.example")
+        assert response.startswith(b'HTTP/1.1 403'), f"Foreign origin was allowed: {response!r}"
+    print_success("Upgrade from a foreign origin is rejected")
+
+    sock = socket.create_connection((url.hostname, url.port), timeout=5)
+    try:
+        response = handshake(sock, f"{url.scheme}://{url.netloc}")
+        assert response.startswith(b'HTTP/1.1 101'), f"Upgrade failed: {response!r}"
+        accept = base64.b64encode(hashlib.sha1(
+            (key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11").encode()).digest()).decode()
+        assert f"Sec-WebSocket-Accept: {accept}".encode() in response, "Wrong Sec-WebSocket-Accept"
+        print_success("WebSocket handshake completed")
+
+        response = sqs_request('SendMessage', {
+            'QueueUrl': f"{BASE_URL}/{queue_name}",
+            'MessageBody': 'event stream test'
+        })
+        assert response.status_code == 200, f"SendMessage failed: {response.text}"
+
+        while True:
+            opcode, payload = read_ws_frame(sock)
+            assert opcode == 0x1, f"Expected a text frame, got opcode {opcode}"
+            event = json.loads(payload)
+            if event['queue'] == queue_name:
+                break
+        assert event['type'] == 'sent', f"Expected a sent event, got {event['type']}"
+        assert event['message_id'], "Event is missing message_id"
+        assert event['counts']['visible'] == 1, f"Unexpected counts: {event['counts']}"
+        print_success("Sent message produced an event with the queue's new counts")
+
+        # Masked close frame with an empty payload; the server echoes it
+        sock.sendall(bytes([0x88, 0x80]) + os.urandom(4))
+        opcode, _ = read_ws_frame(sock)
+        assert opcode == 0x8, f"Expected a close frame, got opcode {opcode}"
+        print_success("Close handshake completed")
+    finally:
+        sock.close()
+        sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{queue_name}"})
+
+def test_admin_filter_messages():
+    print_test("Admin API - Filter Messages by Attribute")
+    queue_name = "test-admin-filter-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+    try:
+        for body, kind in [("first order", "order"), ("refund", "refund"), ("second order", "order"), ("plain", None)]:
+            params = {'QueueUrl': queue_url, 'MessageBody': body}
+            if kind:
+                params.update({
+                    'MessageAttribute.1.Name': 'type',
+                    'MessageAttribute.1.Value.DataType': 'String',
+                    'MessageAttribute.1.Value.StringValue': kind
+                })
+            sqs_request('SendMessage', params)
+
+        url = f"{API_URL}/{queue_name}/messages"
+        response = requests.get(url, params={'attr': 'type', 'value': 'order'})
+        assert response.status_code == 200, f"Filter request failed: {response.text}"
+        data = response.json()
+        assert sorted(m['body'] for m in data['messages']) == ["first order", "second order"], f"Unexpected matches: {data}"
+        assert data['count'] == 2, f"Unexpected count: {data}"
+        assert data['messages'][0]['message_attributes']['type']['StringValue'] == 'order', f"Attributes missing: {data}"
+        print_success("Only messages whose attribute matches the value are returned")
+
+        response = requests.get(url, params={'attr': 'type'})
+        assert response.json()['count'] == 3, f"Expected 3 messages carrying the attribute: {response.json()}"
+        assert requests.get(url).json()['count'] == 4, "Unfiltered listing should return every message"
+        print_success("Without a value any message carrying the attribute matches")
+
+        states = [(m['state'], m['receive_count']) for m in get_admin_queue(queue_name)['messages']]
+        assert states == [('visible', 0)] * 4, f"Filtering changed message state: {states}"
+        print_success("Filtering leaves every message visible and unreceived")
+
+        response = requests.get(f"{API_URL}/no-such-queue/messages", params={'attr': 'type'})
+        assert response.status_code == 404, f"Expected 404, got {response.status_code}"
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_admin_listing_is_read_only():
+    print_test("Admin API - Listing Doesn't Receive")
+    queue_name = "test-admin-peek-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+    try:
+        sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "peek at me"})
+        response = sqs_request('ReceiveMessage', {'QueueUrl': queue_url, 'VisibilityTimeout': '60'})
+        receipt_handle = re.search(r'<ReceiptHandle>(.*?)</ReceiptHandle>', response.text).group(1)
+
+        before = get_admin_queue(queue_name)['messages'][0]
+        for _ in range(3):
+            get_admin_queue(queue_name)
+            requests.get(f"{API_URL}/{queue_name}/messages")
+        after = get_admin_queue(queue_name)['messages'][0]
+        for field in ['state', 'receive_count', 'receipt_handle', 'visible_at']:
+            assert after[field] == before[field], f"Viewing the queue changed {field}: {before[field]} -> {after[field]}"
+        assert after['receive_count'] == 1 and after['state'] == 'in_flight', f"Unexpected message: {after}"
+        print_success("Viewing a queue leaves receive counts, receipt handles and visibility alone")
+
+        response = sqs_request('DeleteMessage', {'QueueUrl': queue_url, 'ReceiptHandle': receipt_handle})
+        assert response.status_code == 200, f"Receipt handle no longer valid after viewing: {response.text}"
+        print_success("The receipt handle from the last receive still works")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_admin_export_import():
+    print_test("Admin API - Export and Import Messages")
+    source_name = "test-export-source"
+    target_name = "test-import-target"
+    fifo_name = "test-import-target.fifo"
+    admin_url = f"{BASE_URL}/admin/api/queues"
+    sqs_request('CreateQueue', {'QueueName': source_name})
+    sqs_request('CreateQueue', {'QueueName': target_name})
+    sqs_request('CreateQueue', {'QueueName': fifo_name, 'Attribute.1.Name': 'FifoQueue', 'Attribute.1.Value': 'true'})
+
+    try:
+        for i in range(2):
+            sqs_json_request('SendMessage', {
+                'QueueUrl': f"{BASE_URL}/{source_name}", 'MessageBody': f"replay {i}",
+                'MessageAttributes': {'n': {'DataType': 'Number', 'StringValue': str(i)}}
+            })
+        sqs_request('ReceiveMessage', {'QueueUrl': f"{BASE_URL}/{source_name}", 'VisibilityTimeout': '60'})
+
+        response = requests.get(f"{admin_url}/{source_name}/export")
+        assert response.status_code == 200, f"Export failed: {response.text}"
+        assert response.headers['Content-Type'].startswith('application/x-ndjson'), response.headers['Content-Type']
+        lines = [json.loads(line) for line in response.text.splitlines()]
+        assert [m['body'] for m in lines] == ["replay 0", "replay 1"], f"Unexpected export: {lines}"
+        assert lines[1]['message_attributes']['n']['StringValue'] == "1", f"Attributes missing: {lines[1]}"
+        assert sorted(m.get('receive_count', 0) for m in lines) == [0, 1], f"Metadata missing: {lines}"
+        assert [m.get('in_flight', False) for m in lines] == [m.get('receive_count', 0) == 1 for m in lines], \
+            f"in_flight doesn't mark the received message: {lines}"
+        assert len(get_admin_queue(source_name)['messages']) == 2, "Export changed the source queue"
+        print_success("Export writes one JSON line per message with attributes and metadata")
+
+        response = requests.post(f"{admin_url}/{target_name}/import", data=response.text)
+        assert response.status_code == 200 and response.json()['imported'] == 2, f"Import failed: {response.text}"
+        assert response.json()['in_flight'] == 0, f"Restored in flight without a grace period: {response.text}"
+        imported = get_admin_queue(target_name)['messages']
+        assert [m['body'] for m in imported] == ["replay 0", "replay 1"], f"Unexpected import: {imported}"
+        assert not {m['message_id'] for m in imported} & {m['message_id'] for m in lines}, "Message IDs were reused"
+        assert all(m['receive_count'] == 0 and m['state'] == 'visible' for m in imported), f"Receipt state carried over: {imported}"
+        assert imported[0]['message_attributes']['n']['StringValue'] == "0", f"Attributes lost: {imported[0]}"
+        print_success("Import loads the messages with fresh IDs and receipt state, keeping bodies and attributes")
+
+        grouped = "\n".join(json.dumps({'body': f"grouped {i}", 'message_group_id': "g1"}) for i in range(2))
+        for _ in range(2):
+            response = requests.post(f"{admin_url}/{fifo_name}/import", data=grouped)
+            assert response.status_code == 200, f"FIFO import failed: {response.text}"
+        messages = get_admin_queue(fifo_name)['messages']
+        assert len(messages) == 4 and all(m['message_group_id'] == "g1" for m in messages), f"Unexpected FIFO import: {messages}"
+        print_success("FIFO imports keep message group IDs and are never deduplicated")
+
+        response = requests.post(f"{admin_url}/{fifo_name}/import", data=json.dumps({'body': "no group"}))
+        assert response.status_code == 400, f"FIFO import without a group accepted: {response.text}"
+        response = requests.post(f"{admin_url}/{target_name}/import", data='{"body": "fine"}\n{not json')
+        assert response.status_code == 400, f"Malformed import accepted: {response.text}"
+        assert len(get_admin_queue(target_name)['messages']) == 2, "A malformed import loaded messages"
+        print_success("Malformed input is rejected without loading anything")
+
+        response = requests.get(f"{admin_url}/no-such-queue/export")
+        assert response.status_code == 404, f"Expected 404, got {response.status_code}"
+    finally:
+        for name in [source_name, target_name, fifo_name]:
+            sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{name}"})
+
+def test_admin_reset_visibility():
+    print_test("Admin API - Reset Visibility")
+    queue_name = "test-reset-visibility-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+
+    try:
+        for i in range(3):
+            sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': f"reset {i}"})
+        response = sqs_request('ReceiveMessage', {
+            'QueueUrl': queue_url, 'MaxNumberOfMessages': '2', 'VisibilityTimeout': '300'
+        })
+        handle = response.text.split('<ReceiptHandle>')[1].split('</ReceiptHandle>')[0]
+
+        response = requests.post(f"{BASE_URL}/admin/api/queues/{queue_name}/reset-visibility")
+        assert response.status_code == 200, f"Reset failed: {response.text}"
+        assert response.json()['reset_count'] == 2, f"Unexpected reset count: {response.json()}"
+        print_success("Reset reports the 2 in-flight messages")
+
+        queue = get_admin_queue(queue_name)
+        assert queue['visible_count'] == 3 and queue['not_visible_count'] == 0, \
+            f"Messages still in flight: {queue['visible_count']} visible, {queue['not_visible_count']} in flight"
+        response = sqs_request('DeleteMessage', {'QueueUrl': queue_url, 'ReceiptHandle': handle})
+        assert response.status_code == 400, "Receipt handle from before the reset still works"
+        print_success("All messages are visible again and old receipt handles are invalid")
+
+        response = requests.post(f"{BASE_URL}/admin/api/queues/test-no-such-queue/reset-visibility")
+        assert response.status_code == 404, f"Expected 404, got {response.status_code}"
+        print_success("Unknown queue returns 404")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_encryption_attributes():
+    print_test("Encryption Attribute Round-Trip")
+    queue_name = "test-sse-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_json_request('CreateQueue', {'QueueName': queue_name, 'Attributes': {
+        'KmsMasterKeyId': 'alias/aws/sqs',
+        'KmsDataKeyReusePeriodSeconds': '300',
+    }})
+
+    def get_attributes():
+        response = sqs_json_request('GetQueueAttributes', {'QueueUrl': queue_url, 'AttributeNames': ['All']})
+        return response.json()['Attributes']
+
+    try:
+        attrs = get_attributes()
+        assert attrs.get('KmsMasterKeyId') == 'alias/aws/sqs', f"KmsMasterKeyId missing: {attrs}"
+        assert attrs.get('KmsDataKeyReusePeriodSeconds') == '300', f"KmsDataKeyReusePeriodSeconds missing: {attrs}"
+        assert 'SqsManagedSseEnabled' not in attrs, f"Unset SqsManagedSseEnabled returned: {attrs}"
+        print_success("Encryption attributes given to CreateQueue are returned")
+
+        response = sqs_request('SetQueueAttributes', {
+            'QueueUrl': queue_url,
+            'Attribute.1.Name': 'KmsMasterKeyId', 'Attribute.1.Value': '',
+            'Attribute.2.Name': 'SqsManagedSseEnabled', 'Attribute.2.Value': 'true',
+        })
+        assert response.status_code == 200, f"SetQueueAttributes failed: {response.text}"
+        attrs = get_attributes()
+        assert attrs.get('KmsMasterKeyId') == '' and attrs.get('SqsManagedSseEnabled') == 'true', \
+            f"Attributes not updated verbatim: {attrs}"
+        response = sqs_request('GetQueueAttributes', {'QueueUrl': queue_url, 'AttributeName.1': 'All'})
+        xml_attrs = {a.find('{*}Name').text: a.find('{*}Value').text for a in ET.fromstring(response.content).findall('.//{*}Attribute')}
+        assert xml_attrs.get('SqsManagedSseEnabled') == 'true', f"SqsManagedSseEnabled missing from XML: {response.text}"
+        print_success("SetQueueAttributes updates them, in both protocols")
+
+        for name, value in [('KmsDataKeyReusePeriodSeconds', '30'), ('SqsManagedSseEnabled', 'yes')]:
+            response = sqs_json_request('SetQueueAttributes', {'QueueUrl': queue_url, 'Attributes': {name: value}})
+            assert response.status_code == 400, f"{name}={value} accepted: {response.text}"
+        assert get_attributes().get('KmsDataKeyReusePeriodSeconds') == '300', "Rejected value was stored"
+        print_success("Out-of-range values are rejected")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_admin_reset_queue():
+    print_test("Admin API - Reset Queue")
+    queue_name = "test-reset-queue.fifo"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_json_request('CreateQueue', {'QueueName': queue_name, 'Attributes': {'FifoQueue': 'true'}})
+
+    def send(body):
+        response = sqs_json_request('SendMessage', {
+            'QueueUrl': queue_url, 'MessageBody': body,
+            'MessageGroupId': 'group', 'MessageDeduplicationId': 'same-id',
+        })
+        return response.json()
+
+    try:
+        first = send("before reset")
+        send("second")
+        sqs_json_request('SendMessage', {
+            'QueueUrl': queue_url, 'MessageBody': "another", 'MessageGroupId': 'group', 'MessageDeduplicationId': 'other-id',
+        })
+        sqs_json_request('ReceiveMessage', {'QueueUrl': queue_url, 'VisibilityTimeout': 300})
+        sqs_request('PurgeQueue', {'QueueUrl': queue_url})
+        sqs_json_request('SendMessage', {
+            'QueueUrl': queue_url, 'MessageBody': "after purge", 'MessageGroupId': 'group', 'MessageDeduplicationId': 'third-id',
+        })
+
+        response = requests.post(f"{BASE_URL}/admin/api/queues/{queue_name}/reset")
+        assert response.status_code == 200, f"Reset failed: {response.text}"
+        assert response.json()['message_count'] == 1, f"Unexpected message count: {response.json()}"
+        assert get_admin_queue(queue_name)['message_count'] == 0, "Messages left after reset"
+        print_success("Reset empties the queue and reports how many messages it held")
+
+        after = send("after reset")
+        assert after['MessageId'] != first['MessageId'], "Deduplication ID still remembered after reset"
+        assert int(after['SequenceNumber']) > int(first['SequenceNumber']), \
+            f"Sequence number went backwards: {first['SequenceNumber']} then {after['SequenceNumber']}"
+        print_success("Deduplication IDs are forgotten and sequence numbers keep increasing")
+
+        attrs = sqs_json_request('GetQueueAttributes', {'QueueUrl': queue_url, 'AttributeNames': ['All']}).json()['Attributes']
+        assert attrs['EssQueueEss.NumberOfMessagesSent'] == '1', f"Counters not reset: {attrs}"
+        response = sqs_request('PurgeQueue', {'QueueUrl': queue_url})
+        assert response.status_code == 200, f"PurgeQueue cooldown survived the reset: {response.text}"
+        print_success("Counters and the PurgeQueue cooldown are reset")
+
+        response = requests.post(f"{BASE_URL}/admin/api/queues/test-no-such-queue/reset")
+        assert response.status_code == 404, f"Expected 404, got {response.status_code}"
+        print_success("Unknown queue returns 404")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_admin_move_to_dlq():
+    print_test("Admin API - Move Message to DLQ")
+    dlq_name = "test-move-dlq"
+    queue_name = "test-move-source-queue"
+    plain_name = "test-move-plain-queue"
+    sqs_request('CreateQueue', {'QueueName': dlq_name})
+    sqs_json_request('CreateQueue', {'QueueName': queue_name, 'Attributes': {
+        'RedrivePolicy': json.dumps({'deadLetterTargetArn': queue_arn(dlq_name), 'maxReceiveCount': 5}),
+    }})
+    sqs_request('CreateQueue', {'QueueName': plain_name})
+
+    def send(name, body):
+        response = sqs_request('SendMessage', {'QueueUrl': f"{BASE_URL}/{name}", 'MessageBody': body})
+        return response.text.split('<MessageId>')[1].split('</MessageId>')[0]
+
+    try:
+        message_id = send(queue_name, "move me")
+        send(queue_name, "leave me")
+        response = requests.post(f"{BASE_URL}/admin/api/queues/{queue_name}/messages/{message_id}/move-to-dlq")
+        assert response.status_code == 200, f"Move failed: {response.text}"
+        assert response.json()['message_id'] == message_id, f"Unexpected response: {response.json()}"
+        assert get_admin_queue(queue_name)['message_count'] == 1, "Message still in the source queue"
+        response = sqs_request('ReceiveMessage', {'QueueUrl': f"{BASE_URL}/{dlq_name}"})
+        assert '<Body>move me</Body>' in response.text, f"Message not in the DLQ: {response.text}"
+        print_success("Message moved to the DLQ without being received")
+
+        response = requests.post(f"{BASE_URL}/admin/api/queues/{queue_name}/messages/{message_id}/move-to-dlq")
+        assert response.status_code == 404, f"Expected 404 for a moved message, got {response.status_code}"
+        response = requests.post(f"{BASE_URL}/admin/api/queues/test-no-such-queue/messages/{message_id}/move-to-dlq")
+        assert response.status_code == 404, f"Expected 404 for an unknown queue, got {response.status_code}"
+        print_success("Unknown messages and queues return 404")
+
+        plain_id = send(plain_name, "no policy")
+        response = requests.post(f"{BASE_URL}/admin/api/queues/{plain_name}/messages/{plain_id}/move-to-dlq")
+        assert response.status_code == 400, f"Expected 400 without a RedrivePolicy, got {response.status_code}"
+        assert 'RedrivePolicy' in response.text, f"Unexpected error: {response.text}"
+        assert get_admin_queue(plain_name)['message_count'] == 1, "Message lost from a queue without a DLQ"
+        print_success("A queue without a RedrivePolicy is rejected")
+    finally:
+        for name in [queue_name, plain_name, dlq_name]:
+            sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{name}"})
+
+def test_admin_export_config():
+    print_test("Admin API - Export Config")
+    
+    response = requests.get(f"{BASE_URL}/admin/api/config/export")
+    assert response.status_code == 200, f"Failed to export config: {response.status_code}"
+    assert response.headers.get('Content-Type') == 'application/x-yaml', "Wrong content type"
+    assert 'config.yaml' in response.headers.get('Content-Disposition', ''), "Missing filename"
+    
+    config_text = response.text
+    assert 'server:' in config_text, "Missing server section in config"
+    assert 'queues:' in config_text, "Missing queues section in config"
+    assert 'admin-test-queue' in config_text, "Queue not found in exported config"
+    assert 'visibility_timeout: 60' in config_text, "Queue settings not in config"
+    
+    print_success("Config exported successfully")
+    print_info(f"Config size: {len(config_text)} bytes")
+
+def test_admin_delete_queue():
+    print_test("Admin API - Delete Queue")
+    
+    response = requests.delete(f"{BASE_URL}/admin/api/queue?name=admin-test-queue")
+    assert response.status_code == 200, f"Failed to delete queue via admin API: {response.text}"
+    
+    data = response.json()
+    assert data['success'] == True, "Expected success=true"
+    assert 'deleted successfully' in data['message'].lower(), f"Unexpected message: {data['message']}"
+    
+    print_success("Queue deleted successfully via admin API")
+    
+    # Verify queue is gone
+    response = requests.get(f"{BASE_URL}/admin/api/queues")
+    data = response.json()
+    queue_names = [q['name'] for q in data['queues']]
+    assert 'admin-test-queue' not in queue_names, "Queue still exists after deletion"
+    
+    print_success("Queue confirmed deleted")
+
+def test_redrive_policy_requires_existing_dlq():
+    print_test("RedrivePolicy Target Validation")
+    queue_name = "test-missing-dlq-source"
+    redrive_policy = json.dumps({
+        'deadLetterTargetArn': queue_arn('test-no-such-dlq'),
+        'maxReceiveCount': 3
+    })
+
+    response = sqs_request('CreateQueue', {
+        'QueueName': queue_name,
+        'Attribute.1.Name': 'RedrivePolicy',
+        'Attribute.1.Value': redrive_policy
+    })
+    assert response.status_code == 400, f"Expected 400 for missing DLQ, got {response.status_code}"
+    assert 'InvalidParameterValue' in response.text, f"Unexpected error: {response.text}"
+    print_success("CreateQueue rejects a RedrivePolicy with a missing dead-letter target")
+
+    self_policy = json.dumps({'deadLetterTargetArn': queue_arn(queue_name), 'maxReceiveCount': 1})
+    response = sqs_request('CreateQueue', {
+        'QueueName': queue_name,
+        'Attribute.1.Name': 'RedrivePolicy',
+        'Attribute.1.Value': self_policy
+    })
+    assert response.status_code == 400, f"Expected 400 for a queue as its own DLQ, got {response.status_code}"
+    assert 'InvalidParameterValue' in response.text, f"Unexpected error: {response.text}"
+
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+    try:
+        response = sqs_request('SetQueueAttributes', {
+            'QueueUrl': queue_url,
+            'Attribute.1.Name': 'RedrivePolicy', 'Attribute.1.Value': self_policy
+        })
+        assert response.status_code == 400, f"Expected 400 for a queue as its own DLQ, got {response.status_code}"
+        assert 'InvalidParameterValue' in response.text, f"Unexpected error: {response.text}"
+        assert get_admin_queue(queue_name).get('redrive_policy') is None, "Rejected policy was attached"
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+    print_success("CreateQueue and SetQueueAttributes reject a queue as its own dead-letter queue")
+
+def test_dlq_preserves_provenance():
+    print_test("DLQ Message Provenance")
+    dlq_name = "test-provenance-dlq"
+    source_name = "test-provenance-source"
+    sqs_request('CreateQueue', {'QueueName': dlq_name})
+    sqs_request('CreateQueue', {
+        'QueueName': source_name,
+        'Attribute.1.Name': 'RedrivePolicy',
+        'Attribute.1.Value': json.dumps({
+            'deadLetterTargetArn': queue_arn(dlq_name),
+            'maxReceiveCount': 1
+        })
+    })
+
+    try:
+        sqs_request('SendMessage', {'QueueUrl': f"{BASE_URL}/{source_name}", 'MessageBody': "poison"})
+        sent = get_admin_queue(source_name)['messages'][0]['sent_timestamp']
+        sqs_request('ReceiveMessage', {'QueueUrl': f"{BASE_URL}/{source_name}", 'VisibilityTimeout': '1'})
+
+        deadline = time.time() + 5
+        while not get_admin_queue(dlq_name)['messages'] and time.time() < deadline:
+            time.sleep(0.2)
+        messages = get_admin_queue(dlq_name)['messages']
+        assert len(messages) == 1, "Message was not moved to the DLQ"
+        assert messages[0]['dead_letter_source_arn'].endswith(f":{source_name}"), \
+            f"Unexpected source ARN: {messages[0].get('dead_letter_source_arn')}"
+        assert messages[0]['sent_timestamp'] == sent, "DLQ message lost its original SentTimestamp"
+        assert messages[0]['receive_count'] == 1, "DLQ message lost its receive count"
+        print_success("Admin API shows the source ARN, original send time and receive count")
+
+        source = get_admin_queue(source_name)
+        dlq = get_admin_queue(dlq_name)
+        assert source['dlq_moved_count'] == 1, f"Source queue dlq_moved_count: {source['dlq_moved_count']}"
+        assert dlq['redrive_source_queues'] == [source_name], \
+            f"DLQ redrive_source_queues: {dlq.get('redrive_source_queues')}"
+        assert 'redrive_source_queues' not in source, "A queue that isn't a DLQ should have no redrive sources"
+        print_success("Admin API shows the source's dlq_moved_count and the DLQ's redrive_source_queues")
+
+        response = sqs_request('ReceiveMessage', {
+            'QueueUrl': f"{BASE_URL}/{dlq_name}",
+            'AttributeName.1': 'DeadLetterQueueSourceArn'
+        })
+        assert '<Name>DeadLetterQueueSourceArn</Name>' in response.text and f":{source_name}<" in response.text, \
+            f"DeadLetterQueueSourceArn missing: {response.text}"
+        print_success("ReceiveMessage on the DLQ returns DeadLetterQueueSourceArn")
+    finally:
+        for name in [source_name, dlq_name]:
+            sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{name}"})
+
+def test_admin_pending_dlq():
+    print_test("Admin API - Pending DLQ Moves")
+    dlq_name = "test-pending-dlq"
+    source_name = "test-pending-source"
+    source_url = f"{BASE_URL}/{source_name}"
+    sqs_request('CreateQueue', {'QueueName': dlq_name})
+    sqs_request('CreateQueue', {
+        'QueueName': source_name,
+        'Attribute.1.Name': 'RedrivePolicy',
+        'Attribute.1.Value': json.dumps({'deadLetterTargetArn': queue_arn(dlq_name), 'maxReceiveCount': 2})
+    })
+
+    try:
+        sqs_request('SendMessage', {'QueueUrl': source_url, 'MessageBody': "doomed"})
+        message = get_admin_queue(source_name)['messages'][0]
+        assert message['pending_dlq'] is False, f"Unreceived message marked pending: {message}"
+
+        response = sqs_request('ReceiveMessage', {'QueueUrl': source_url, 'VisibilityTimeout': '0'})
+        assert 'doomed' in response.text, f"Receive failed: {response.text}"
+        message = get_admin_queue(source_name)['messages'][0]
+        assert message['pending_dlq'] is False, f"Message marked pending after 1 of 2 receives: {message}"
+        print_success("A message with receives left isn't pending a DLQ move")
+
+        response = sqs_request('ReceiveMessage', {'QueueUrl': source_url, 'VisibilityTimeout': '60'})
+        assert 'doomed' in response.text, f"Second receive failed: {response.text}"
+        message = get_admin_queue(source_name)['messages'][0]
+        assert message['receive_count'] == 2 and message['pending_dlq'] is True, \
+            f"Message that used up its receives not marked pending: {message}"
+        print_success("A message that has used up maxReceiveCount is marked pending_dlq")
+
+        sqs_request('SendMessage', {'QueueUrl': f"{BASE_URL}/{dlq_name}", 'MessageBody': "no policy"})
+        message = get_admin_queue(dlq_name)['messages'][0]
+        assert message['pending_dlq'] is False, f"Message on a queue without a redrive policy marked pending: {message}"
+        print_success("Messages on queues without a redrive policy are never pending")
+    finally:
+        for name in [source_name, dlq_name]:
+            sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{name}"})
+
+def test_set_redrive_policy():
+    print_test("SetQueueAttributes RedrivePolicy Attach and Detach")
+    dlq_name = "test-attach-dlq"
+    source_name = "test-attach-source"
+    source_url = f"{BASE_URL}/{source_name}"
+    sqs_request('CreateQueue', {'QueueName': dlq_name})
+    sqs_request('CreateQueue', {'QueueName': source_name})
+
+    def set_redrive_policy(value):
+        return sqs_request('SetQueueAttributes', {
+            'QueueUrl': source_url,
+            'Attribute.1.Name': 'RedrivePolicy', 'Attribute.1.Value': value
+        })
+
+    def poison(body):
+        sqs_request('SendMessage', {'QueueUrl': source_url, 'MessageBody': body})
+        sqs_request('ReceiveMessage', {'QueueUrl': source_url, 'VisibilityTimeout': '1'})
+
+    try:
+        response = set_redrive_policy(json.dumps({'deadLetterTargetArn': queue_arn('test-attach-missing'), 'maxReceiveCount': 1}))
+        assert response.status_code == 400, f"Attaching a missing DLQ was accepted: {response.text}"
+        assert get_admin_queue(source_name).get('redrive_policy') is None, "Rejected policy was attached"
+        print_success("Attaching a dead-letter queue that doesn't exist is rejected")
+
+        response = set_redrive_policy(json.dumps({'deadLetterTargetArn': queue_arn(dlq_name), 'maxReceiveCount': 1}))
+        assert response.status_code == 200, f"Attaching the DLQ failed: {response.text}"
+        poison("attached")
+        deadline = time.time() + 5
+        while not get_admin_queue(dlq_name)['messages'] and time.time() < deadline:
+            time.sleep(0.2)
+        assert [m['body'] for m in get_admin_queue(dlq_name)['messages']] == ["attached"], \
+            "Message was not moved to the attached DLQ"
+        print_success("After attaching a RedrivePolicy, poison messages move to the DLQ")
+
+        response = set_redrive_policy("")
+        assert response.status_code == 200, f"Detaching the DLQ failed: {response.text}"
+        assert get_admin_queue(source_name).get('redrive_policy') is None, "RedrivePolicy still set after detaching"
+        poison("detached")
+        time.sleep(2.5)
+        assert [m['body'] for m in get_admin_queue(source_name)['messages']] == ["detached"], \
+            "Message left the source queue after detaching"
+        assert len(get_admin_queue(dlq_name)['messages']) == 1, "Message was moved to the detached DLQ"
+        print_success("An empty RedrivePolicy detaches the DLQ and moves stop")
+    finally:
+        for name in [source_name, dlq_name]:
+            sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{name}"})
+
+def test_dlq_order_by_sent_time():
+    print_test("DLQ Order by Sent Time")
+    dlq_name = "test-order-dlq"
+    source_name = "test-order-source"
+    sqs_request('CreateQueue', {'QueueName': dlq_name})
+    sqs_request('CreateQueue', {
+        'QueueName': source_name,
+        'Attribute.1.Name': 'RedrivePolicy',
+        'Attribute.1.Value': json.dumps({
+            'deadLetterTargetArn': queue_arn(dlq_name),
+            'maxReceiveCount': 1
+        })
+    })
+
+    try:
+        sqs_request('SendMessage', {'QueueUrl': f"{BASE_URL}/{source_name}", 'MessageBody': "older"})
+        time.sleep(0.05)
+        sqs_request('SendMessage', {'QueueUrl': f"{BASE_URL}/{dlq_name}", 'MessageBody': "newer"})
+        sqs_request('ReceiveMessage', {'QueueUrl': f"{BASE_URL}/{source_name}", 'VisibilityTimeout': '1'})
+
+        deadline = time.time() + 5
+        while len(get_admin_queue(dlq_name)['messages']) < 2 and time.time() < deadline:
+            time.sleep(0.2)
+        order = [m['body'] for m in get_admin_queue(dlq_name)['messages']]
+        assert sorted(order) == ["newer", "older"], f"Message was not moved to the DLQ: {order}"
+        if order == ["newer", "older"]:
+            print_info("Moved messages are appended; run with server.order_by_sent_time to test ordering by sent time")
+            return
+        assert order == ["older", "newer"], f"Unexpected DLQ order: {order}"
+        print_success("A message moved to the DLQ is inserted by its original send time")
+
+        response = sqs_json_request('ReceiveMessage', {'QueueUrl': f"{BASE_URL}/{dlq_name}"})
+        bodies = [m['Body'] for m in response.json().get('Messages') or []]
+        assert bodies == ["older"], f"Expected the oldest message first, got {bodies}"
+        print_success("Receives return the oldest visible message first")
+    finally:
+        for name in [source_name, dlq_name]:
+            sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{name}"})
+
+def test_max_receive_count_precedence():
+    print_test("Max Receive Count Precedence")
+    dlq_name = "test-precedence-dlq"
+    policy_name = "test-precedence-policy-count"
+    queue_name = "test-precedence-queue-count"
+    sqs_request('CreateQueue', {'QueueName': dlq_name})
+    # The policy's own maxReceiveCount of 1 wins over the queue's 5
+    sqs_json_request('CreateQueue', {'QueueName': policy_name, 'Attributes': {
+        'MaxReceiveCount': '5',
+        'RedrivePolicy': json.dumps({'deadLetterTargetArn': queue_arn(dlq_name), 'maxReceiveCount': 1}),
+    }})
+    # A policy without maxReceiveCount uses the queue's 2
+    sqs_json_request('CreateQueue', {'QueueName': queue_name, 'Attributes': {
+        'MaxReceiveCount': '2',
+        'RedrivePolicy': json.dumps({'deadLetterTargetArn': queue_arn(dlq_name)}),
+    }})
+
+    def receive_once(name):
+        response = sqs_request('ReceiveMessage', {'QueueUrl': f"{BASE_URL}/{name}", 'VisibilityTimeout': '0'})
+        assert '<Message>' in response.text, f"Nothing received from {name}"
+
+    def wait_for_checker():
+        time.sleep(1.5)
+
+    try:
+        for name in [policy_name, queue_name]:
+            sqs_request('SendMessage', {'QueueUrl': f"{BASE_URL}/{name}", 'MessageBody': name})
+            receive_once(name)
+        wait_for_checker()
+        assert get_admin_queue(policy_name)['message_count'] == 0, "The policy's maxReceiveCount was not used"
+        print_success("A RedrivePolicy's maxReceiveCount takes precedence over the queue's")
+
+        queue = get_admin_queue(queue_name)
+        assert queue['message_count'] == 1, "Message moved before the queue's MaxReceiveCount was reached"
+        assert queue['redrive_policy']['maxReceiveCount'] == 2, f"Unexpected policy: {queue['redrive_policy']}"
+        receive_once(queue_name)
+        wait_for_checker()
+        assert get_admin_queue(queue_name)['message_count'] == 0, "Message not moved at the queue's MaxReceiveCount"
+        assert sorted(m['body'] for m in get_admin_queue(dlq_name)['messages']) == sorted([policy_name, queue_name])
+        print_success("A RedrivePolicy without maxReceiveCount falls back to the queue's MaxReceiveCount")
+    finally:
+        for name in [policy_name, queue_name, dlq_name]:
+            sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{name}"})
+
+def test_redrive_resets_first_receive_time():
+    print_test("ApproximateFirstReceiveTimestamp After Redrive")
+    dlq_name = "test-first-receive-dlq"
+    source_name = "test-first-receive-source"
+    source_url = f"{BASE_URL}/{source_name}"
+    dlq_url = f"{BASE_URL}/{dlq_name}"
+    sqs_request('CreateQueue', {'QueueName': dlq_name})
+    sqs_request('CreateQueue', {
+        'QueueName': source_name,
+        'Attribute.1.Name': 'RedrivePolicy',
+        'Attribute.1.Value': json.dumps({'deadLetterTargetArn': queue_arn(dlq_name), 'maxReceiveCount': 1})
+    })
+
+    def receive(url):
+        response = sqs_json_request('ReceiveMessage', {
+            'QueueUrl': url, 'VisibilityTimeout': 0, 'MessageSystemAttributeNames': ['All']
+        })
+        messages = response.json().get('Messages') or []
+        assert len(messages) == 1, f"Expected one message, got {messages}"
+        return messages[0]['Attributes']
+
+    try:
+        sqs_request('SendMessage', {'QueueUrl': source_url, 'MessageBody': "redriven"})
+        response = sqs_json_request('ReceiveMessage', {
+            'QueueUrl': source_url, 'VisibilityTimeout': 1, 'MessageSystemAttributeNames': ['All']
+        })
+        first_receive = int(response.json()['Messages'][0]['Attributes']['ApproximateFirstReceiveTimestamp'])
+
+        deadline = time.time() + 5
+        while get_admin_queue(dlq_name)['message_count'] < 1 and time.time() < deadline:
+            time.sleep(0.2)
+        attributes = receive(dlq_url)
+        assert int(attributes['ApproximateFirstReceiveTimestamp']) == first_receive, \
+            f"First receive time changed in the DLQ: {attributes}"
+        assert attributes['ApproximateReceiveCount'] == '2', f"Unexpected receive count: {attributes}"
+        print_success("A message moved to the DLQ keeps its receive count and first receive time")
+
+        response = sqs_request('StartMessageMoveTask', {'SourceArn': queue_arn(dlq_name)})
+        assert response.status_code == 200, f"StartMessageMoveTask failed: {response.text}"
+        attributes = receive(source_url)
+        assert attributes['ApproximateReceiveCount'] == '1', f"Receive count not reset: {attributes}"
+        assert int(attributes['ApproximateFirstReceiveTimestamp']) > first_receive, \
+            f"First receive time not reset by redrive: {attributes}"
+        print_success("A redriven message is stamped again on its next receive")
+    finally:
+        for name in [source_name, dlq_name]:
+            sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{name}"})
+
+def test_message_move_task_validation():
+    print_test("StartMessageMoveTask Source and Destination Validation")
+    arn_prefix = queue_arn("")
+    dlq_name = "test-move-dlq"
+    sources = ["test-move-source-a", "test-move-source-b"]
+    plain_name = "test-move-plain"
+    fifo_name = "test-move-fifo.fifo"
+
+    sqs_request('CreateQueue', {'QueueName': dlq_name})
+    sqs_request('CreateQueue', {'QueueName': plain_name})
+    sqs_json_request('CreateQueue', {'QueueName': fifo_name, 'Attributes': {'FifoQueue': 'true'}})
+    for name in sources:
+        sqs_request('CreateQueue', {
+            'QueueName': name,
+            'Attribute.1.Name': 'RedrivePolicy',
+            'Attribute.1.Value': json.dumps({'deadLetterTargetArn': arn_prefix + dlq_name, 'maxReceiveCount': 1})
+        })
+
+    try:
+        response = sqs_request('StartMessageMoveTask', {'SourceArn': arn_prefix + plain_name})
+        assert response.status_code == 400, f"Expected 400, got {response.status_code}"
+        assert 'ResourceNotFoundException' in response.text, f"Unexpected error: {response.text}"
+        print_success("Redrive from a queue that isn't a DLQ is rejected")
+
+        response = sqs_request('StartMessageMoveTask', {'SourceArn': arn_prefix + dlq_name})
+        assert response.status_code == 400, f"Expected 400, got {response.status_code}"
+        assert 'specify DestinationArn' in response.text, f"Unexpected error: {response.text}"
+        print_success("Redrive without DestinationArn from a shared DLQ is rejected as ambiguous")
+
+        other_account = "999999999999" if ":000000000000:" in arn_prefix else "000000000000"
+        bad_arns = {
+            'a bare queue name': dlq_name,
+            'too few fields': f"arn:aws:sqs:{dlq_name}",
+            'too many fields': arn_prefix + dlq_name + ":extra",
+            'another service': arn_prefix.replace(":sqs:", ":sns:") + dlq_name,
+            'another region': arn_prefix.replace(":us-east-1:", ":eu-west-1:") + dlq_name,
+            'another account': f"arn:aws:sqs:us-east-1:{other_account}:{dlq_name}",
+            'an empty queue name': arn_prefix,
+        }
+        for case, arn in bad_arns.items():
+            response = sqs_request('StartMessageMoveTask', {'SourceArn': arn})
+            assert response.status_code == 400 and 'ResourceNotFoundException' in response.text, \
+                f"SourceArn with {case} was not rejected: {response.text}"
+            response = sqs_request('StartMessageMoveTask', {
+                'SourceArn': arn_prefix + dlq_name,
+                'DestinationArn': arn.replace(dlq_name, sources[0])
+            })
+            assert response.status_code == 400 and 'ResourceNotFoundException' in response.text, \
+                f"DestinationArn with {case} was not rejected: {response.text}"
+        print_success("Malformed ARNs and ARNs for another region or account are rejected")
+
+        sqs_request('SendMessage', {'QueueUrl': f"{BASE_URL}/{dlq_name}", 'MessageBody': "redrive me"})
+        response = sqs_request('StartMessageMoveTask', {
+            'SourceArn': arn_prefix + dlq_name,
+            'DestinationArn': arn_prefix + "test-move-missing"
+        })
+        assert response.status_code == 400 and 'ResourceNotFoundException' in response.text, \
+            f"Missing destination was not rejected: {response.text}"
+        response = sqs_json_request('StartMessageMoveTask', {
+            'SourceArn': arn_prefix + dlq_name,
+            'DestinationArn': arn_prefix + fifo_name
+        })
+        assert response.status_code == 400 and 'InvalidParameterValue' in response.text, \
+            f"FIFO destination for a standard DLQ was not rejected: {response.text}"
+        assert 'same type' in response.text, f"Unexpected error: {response.text}"
+        assert get_admin_queue(dlq_name)['message_count'] == 1, "A rejected move task moved messages"
+        assert get_admin_queue(fifo_name)['message_count'] == 0, "A standard message reached a FIFO queue"
+        print_success("Missing destinations and destinations of another queue type are rejected, moving nothing")
+
+        response = sqs_request('StartMessageMoveTask', {
+            'SourceArn': arn_prefix + dlq_name,
+            'DestinationArn': arn_prefix + dlq_name
+        })
+        assert response.status_code == 400 and 'InvalidParameterValue' in response.text, \
+            f"The source as its own destination was not rejected: {response.text}"
+        assert get_admin_queue(dlq_name)['message_count'] == 1, "The DLQ is unusable after a self-move"
+        print_success("A destination that is the source queue itself is rejected")
+
+        response = sqs_request('StartMessageMoveTask', {
+            'SourceArn': arn_prefix + dlq_name,
+            'DestinationArn': arn_prefix + sources[1]
+        })
+        assert response.status_code == 200, f"Move task failed: {response.text}"
+        response = sqs_request('ReceiveMessage', {'QueueUrl': f"{BASE_URL}/{sources[1]}"})
+        assert 'redrive me' in response.text, "Message not moved to the destination"
+        print_success("Redrive with an explicit DestinationArn moves the messages")
+    finally:
+        for name in sources + [dlq_name, plain_name, fifo_name]:
+            sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{name}"})
+
+def test_receive_auto_delete():
+    print_test("ReceiveMessage AutoDelete (lenient extension)")
+    # AutoDelete needs server.lenient, so the test starts its own server
+    server_binary = os.environ.get('SERVER_BINARY')
+    if not server_binary:
+        print_info("SERVER_BINARY not set, skipping")
+        return
+
+    config_path = write_temp_config("server:\n"
+                                    "  lenient: true\n"
+                                    "queues:\n"
+                                    "  - name: test-auto-delete-queue\n")
+    server, url = start_config_server(server_binary, config_path)
+    try:
+        queue_url = f"{url}/000000000000/test-auto-delete-queue"
+        for i in range(3):
+            requests.post(url, data={
+                'Action': 'SendMessage',
+                'QueueUrl': queue_url,
+                'MessageBody': f"Auto delete message #{i+1}"
+            })
+
+        response = requests.post(url, data={
+            'Action': 'ReceiveMessage',
+            'QueueUrl': queue_url,
+            'MaxNumberOfMessages': '10',
+            'AutoDelete': 'true'
+        })
+        assert response.status_code == 200, f"Receive with AutoDelete failed: {response.text}"
+        message_count = response.text.count('<MessageId>')
+        assert message_count == 3, f"Expected 3 messages, got {message_count}"
+        print_success("Received 3 messages with AutoDelete")
+
+        queue = requests.get(f"{url}/admin/api/queues").json()['queues'][0]
+        assert queue['message_count'] == 0, f"Expected auto-deleted messages to be gone, found {queue['message_count']}"
+        response = requests.post(url, data={'Action': 'ReceiveMessage', 'QueueUrl': queue_url, 'VisibilityTimeout': '0'})
+        assert '<MessageId>' not in response.text, f"An auto-deleted message was received again: {response.text}"
+        print_success("Auto-deleted messages are no longer in the queue")
+    finally:
+        server.terminate()
+        server.wait()
+        os.unlink(config_path)
+
+def test_message_retention_override():
+    print_test("Per-Message Retention Period (lenient extension)")
+    queue_name = "test-message-ttl-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+
+    def send(body, retention=None):
+        params = {'QueueUrl': queue_url, 'MessageBody': body}
+        if retention is not None:
+            params.update({
+                'MessageAttribute.1.Name': 'MessageRetentionPeriod',
+                'MessageAttribute.1.Value.DataType': 'Number',
+                'MessageAttribute.1.Value.StringValue': retention
+            })
+        return sqs_request('SendMessage', params)
+
+    try:
+        response = requests.post(f"{BASE_URL}/admin/api/message", json={
+            'queue_name': queue_name, 'message_body': "admin short-lived", 'message_retention_period': 2
+        })
+        if response.status_code == 400 and 'lenient' in response.text:
+            print_info("Server not running with server.lenient, skipping")
+            return
+        assert response.status_code == 200, f"Admin send failed: {response.text}"
+
+        response = send("bad", "0")
+        assert response.status_code == 400 and 'InvalidParameterValue' in response.text, \
+            f"Invalid retention period accepted: {response.text}"
+        print_success("A retention period outside 1-1209600 seconds is rejected")
+
+        assert send("short-lived", "2").status_code == 200
+        assert send("long-lived").status_code == 200
+        sqs_request('ReceiveMessage', {'QueueUrl': queue_url, 'MaxNumberOfMessages': '10', 'VisibilityTimeout': '60'})
+
+        deadline = time.time() + 6
+        while len(get_admin_queue(queue_name)['messages']) > 1 and time.time() < deadline:
+            time.sleep(0.2)
+        bodies = [m['body'] for m in get_admin_queue(queue_name)['messages']]
+        assert bodies == ["long-lived"], f"Expected only the message without an override to remain, got {bodies}"
+        print_success("Messages expire after their own retention period, even in flight; others keep the queue's")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_delivery_history():
+    print_test("Message Delivery History (debug)")
+    queue_name = "test-delivery-history-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    sqs_request('CreateQueue', {'QueueName': queue_name})
+
+    try:
+        sqs_request('SendMessage', {
+            'QueueUrl': queue_url,
+            'MessageBody': "Message that keeps bouncing"
+        })
+
+        # Receive with a zero visibility timeout so the message is immediately visible again
+        for _ in range(3):
+            response = sqs_request('ReceiveMessage', {
+                'QueueUrl': queue_url,
+                'VisibilityTimeout': '0'
+            })
+            assert '<MessageId>' in response.text, "Expected to receive the message"
+            time.sleep(0.05)
+
+        message = get_admin_queue(queue_name)['messages'][0]
+        if 'history' not in message:
+            print_info("Server not running with server.debug, skipping")
+            return
+
+        receives = [e for e in message['history'] if e['event'] == 'received']
+        assert len(receives) == 3, f"Expected 3 receive events, got {message['history']}"
+        print_success("Delivery history records each receive")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+
+def test_subscription_fan_out():
+    print_test("Subscription Fan-Out")
+    topic = "test-fanout-topic"
+    subscribers = ["test-fanout-sub-a", "test-fanout-sub-b"]
+    for name in [topic] + subscribers:
+        sqs_request('CreateQueue', {'QueueName': name})
+
+    try:
+        response = sqs_request('SendMessage', {
+            'QueueUrl': f"{BASE_URL}/{topic}",
+            'MessageBody': "fanned out"
+        })
+        original_id = response.text.split('<MessageId>')[1].split('</MessageId>')[0]
+
+        copies = {}
+        for name in subscribers:
+            queue = get_admin_queue(name)
+            copies[name] = queue['messages'] if queue else []
+        if not any(copies.values()):
+            print_info("Server not configured with subscriptions for test-fanout-topic, skipping")
+            return
+
+        for name, messages in copies.items():
+            assert len(messages) == 1, f"{name} has {len(messages)} messages, expected 1"
+            response = sqs_request('ReceiveMessage', {'QueueUrl': f"{BASE_URL}/{name}"})
+            assert '<Body>fanned out</Body>' in response.text, f"{name} is missing the body: {response.text}"
+            assert original_id not in response.text, f"{name} reused the original MessageId"
+        print_success("Each subscriber got a copy of the body with a new MessageId")
+
+        assert len(get_admin_queue(topic)['messages']) == 1, "Source queue should keep its own message"
+        print_success("Source queue keeps the original message")
+    finally:
+        for name in [topic] + subscribers:
+            sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{name}"})
+
+def test_webhook_trigger():
+    print_test("Webhook Trigger")
+    queue_name = "test-trigger-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    if get_admin_queue(queue_name) is None:
+        print_info("Server not configured with a trigger on test-trigger-queue, skipping")
+        return
+
+    events = []
+    statuses = [500, 200]
+
+    class Hook(http.server.BaseHTTPRequestHandler):
+        def do_POST(self):
+            events.append(json.loads(self.rfile.read(int(self.headers['Content-Length']))))
+            self.send_response(statuses[min(len(events), len(statuses)) - 1])
+            self.end_headers()
+
+        def log_message(self, *args):
+            pass
+
+    hook = http.server.HTTPServer(('127.0.0.1', 9399), Hook)
+    threading.Thread(target=hook.serve_forever, daemon=True).start()
+    try:
+        sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "triggered"})
+
+        deadline = time.time() + 15
+        while len(events) < 2 and time.time() < deadline:
+            time.sleep(0.2)
+        assert len(events) == 2, f"Expected a failed and a retried delivery, got {len(events)}"
+
+        record = events[0]['Records'][0]
+        assert record['body'] == "triggered", f"Unexpected body: {record['body']}"
+        assert record['eventSource'] == "aws:sqs", f"Unexpected eventSource: {record['eventSource']}"
+        assert record['eventSourceARN'].endswith(f":{queue_name}"), f"Unexpected ARN: {record['eventSourceARN']}"
+        print_success("Webhook received the message as an SQS event")
+
+        retry = events[1]['Records'][0]
+        assert retry['messageId'] == record['messageId'], "Retry delivered a different message"
+        assert retry['attributes']['ApproximateReceiveCount'] == '2', \
+            f"Unexpected receive count: {retry['attributes']['ApproximateReceiveCount']}"
+        print_success("Failed delivery was retried after the visibility timeout")
+
+        deadline = time.time() + 2
+        while get_admin_queue(queue_name)['message_count'] and time.time() < deadline:
+            time.sleep(0.1)
+        assert get_admin_queue(queue_name)['message_count'] == 0, "Message not deleted after a 2xx response"
+        print_success("Message deleted after the webhook answered 2xx")
+    finally:
+        hook.shutdown()
+        hook.server_close()
+
+def test_instance_isolation():
+    print_test("Named Instances (acct1, acct2)")
+    queue_name = "test-instance-queue"
+
+    def instance_request(instance, action, params):
+        params['Action'] = action
+        return requests.post(f"{BASE_URL}/{instance}/", data=params)
+
+    response = instance_request('acct1', 'CreateQueue', {'QueueName': queue_name})
+    if not re.search(f"/acct1/\\d{{12}}/{queue_name}<", response.text):
+        print_info("Server not configured with acct1/acct2 instances, skipping")
+        sqs_request('DeleteQueue', {'QueueUrl': f"{BASE_URL}/{queue_name}"})
+        return
+    instance_request('acct2', 'CreateQueue', {'QueueName': queue_name})
+
+    try:
+        instance_request('acct1', 'SendMessage', {
+            'QueueUrl': f"{BASE_URL}/acct1/{queue_name}",
+            'MessageBody': "Only in acct1"
+        })
+
+        response = instance_request('acct2', 'ReceiveMessage', {
+            'QueueUrl': f"{BASE_URL}/acct2/{queue_name}"
+        })
+        assert '<MessageId>' not in response.text, "Message leaked into acct2"
+
+        response = instance_request('acct1', 'ReceiveMessage', {
+            'QueueUrl': f"{BASE_URL}/acct1/{queue_name}"
+        })
+        assert 'Only in acct1' in response.text, "Message missing from acct1"
+
+        response = sqs_request('ListQueues', {'QueueNamePrefix': queue_name})
+        assert '<QueueUrl>' not in response.text, "Instance queue visible in default instance"
+        print_success("Same-named queues in acct1 and acct2 are isolated")
+    finally:
+        for instance in ['acct1', 'acct2']:
+            instance_request(instance, 'DeleteQueue', {'QueueUrl': f"{BASE_URL}/{instance}/{queue_name}"})
+
+def sigv4_headers(body, access_key="test", secret_key="test", region="us-east-1"):
+    """Sign a form-encoded POST to BASE_URL with AWS Signature Version 4"""
+    now = datetime.datetime.now(datetime.timezone.utc)
+    amz_date = now.strftime('%Y%m%dT%H%M%SZ')
+    date = now.strftime('%Y%m%d')
+    host = BASE_URL.split('://', 1)[1]
+    content_type = 'application/x-www-form-urlencoded'
+
+    canonical_headers = f"content-type:{content_type}\nhost:{host}\nx-amz-date:{amz_date}\n"
+    signed_headers = 'content-type;host;x-amz-date'
+    canonical_request = '\n'.join([
+        'POST', '/', '', canonical_headers, signed_headers,
+        hashlib.sha256(body.encode()).hexdigest()
+    ])
+    scope = f"{date}/{region}/sqs/aws4_request"
+    string_to_sign = '\n'.join([
+        'AWS4-HMAC-SHA256', amz_date, scope,
+        hashlib.sha256(canonical_request.encode()).hexdigest()
+    ])
+
+    key = f"AWS4{secret_key}".encode()
+    for part in [date, region, 'sqs', 'aws4_request']:
+        key = hmac.new(key, part.encode(), hashlib.sha256).digest()
+    signature = hmac.new(key, string_to_sign.encode(), hashlib.sha256).hexdigest()
+
+    return {
+        'Content-Type': content_type,
+        'X-Amz-Date': amz_date,
+        'Authorization': f"AWS4-HMAC-SHA256 Credential={access_key}/{scope}, "
+                         f"SignedHeaders={signed_headers}, Signature={signature}"
+    }
+
+def test_signature_verification():
+    print_test("SigV4 Signature Verification")
+    body = urlencode({'Action': 'ListQueues'})
+
+    headers = sigv4_headers(body, secret_key="wrong-secret")
+    response = requests.post(BASE_URL, data=body, headers=headers)
+    if response.status_code == 200:
+        print_success("Signatures are not verified by default")
+        print_info("Server not running with auth.verify_signature, skipping")
+        return
+    assert response.status_code == 403, f"Expected 403, got {response.status_code}"
+    assert '<Code>SignatureDoesNotMatch</Code>' in response.text, "Expected SignatureDoesNotMatch"
+    print_success("Request signed with the wrong secret is rejected")
+
+    response = requests.post(BASE_URL, data=body, headers=sigv4_headers(body))
+    assert response.status_code == 200, f"Correctly signed request failed: {response.text}"
+    print_success("Correctly signed request is accepted")
+
+# The config tests start their own server from SERVER_BINARY, on a spare port
+# so it doesn't clash with the server under test
+
+def write_temp_config(text):
+    with tempfile.NamedTemporaryFile('w', suffix='.yaml', delete=False) as f:
+        f.write(text)
+        return f.name
+
+def spare_port():
+    with socket.socket() as s:
+        s.bind(('', 0))
+        return s.getsockname()[1]
+
+def start_config_server(server_binary, config_path):
+    """Start the server with a config on a spare port and wait until it is healthy"""
+    port = spare_port()
+    server = subprocess.Popen([server_binary, '--config', config_path], env=dict(os.environ, PORT=str(port)),
+                              stdout=subprocess.DEVNULL, stderr=subprocess.DEVNULL)
+    url = f"http://localhost:{port}"
+    for _ in range(50):
+        try:
+            if requests.get(f"{url}/health").status_code == 200:
+                return server, url
+        except requests.exceptions.ConnectionError:
+            pass
+        time.sleep(0.1)
+    server.terminate()
+    server.wait()
+    raise AssertionError(f"Server with config {config_path} never became healthy")
+
+def test_duplicate_queue_names_in_config():
+    print_test("Duplicate Queue Names in Config")
+    # Set SERVER_BINARY to the server binary to start it with a bad config
+    server_binary = os.environ.get('SERVER_BINARY')
+    if not server_binary:
+        print_info("SERVER_BINARY not set, skipping")
+        return
+
+    config_path = write_temp_config("queues:\n"
+                                    "  - name: test-duplicate-queue\n"
+                                    "    visibility_timeout: 10\n"
+                                    "  - name: test-duplicate-queue\n"
+                                    "    visibility_timeout: 60\n")
+    try:
+        env = dict(os.environ, PORT=str(spare_port()))
+        try:
+            result = subprocess.run([server_binary, '--config', config_path],
+                                    env=env, capture_output=True, text=True, timeout=5)
+        except subprocess.TimeoutExpired:
+            raise AssertionError("Server started with a duplicated queue name")
+        assert result.returncode != 0, "Server exited cleanly with a duplicated queue name"
+        assert 'duplicate queue name' in result.stderr and 'test-duplicate-queue' in result.stderr, \
+            f"Startup error doesn't name the duplicate: {result.stderr}"
+        print_success("Startup fails and names the duplicated queue")
+
+        result = subprocess.run([server_binary, '--config', config_path, '--validate-config'],
+                                capture_output=True, text=True, timeout=5)
+        assert result.returncode == 1, f"--validate-config exited {result.returncode}"
+        assert 'test-duplicate-queue' in result.stdout, f"Report doesn't name the duplicate: {result.stdout}"
+        print_success("--validate-config reports the duplicate")
+    finally:
+        os.unlink(config_path)
+
+def test_invalid_config_is_fatal():
+    print_test("Startup Fails on a Config That Doesn't Load")
+    server_binary = os.environ.get('SERVER_BINARY')
+    if not server_binary:
+        print_info("SERVER_BINARY not set, skipping")
+        return
+
+    # Starting on the defaults instead would leave admin and SQS auth off
+    config_path = write_temp_config("admin:\n"
+                                    "  auth_token: test-admin-token\n"
+                                    "auth:\n"
+                                    "  verify_signature: true\n"
+                                    "  access_key: test-access-key\n"
+                                    "  secret_key: test-secret-key\n"
+                                    "queues:\n"
+                                    "  - name: test-broken-config-source\n"
+                                    "    dead_letter_target: test-broken-config-missing\n")
+    try:
+        env = dict(os.environ, PORT=str(spare_port()))
+        try:
+            result = subprocess.run([server_binary, '--config', config_path],
+                                    env=env, capture_output=True, text=True, timeout=5)
+        except subprocess.TimeoutExpired:
+            raise AssertionError("Server started with a config that doesn't load")
+        assert result.returncode != 0, "Server exited cleanly with a config that doesn't load"
+        assert 'failed to load config' in result.stderr, f"Startup error doesn't say why: {result.stderr}"
+        print_success("Startup fails instead of running on the defaults without auth")
+    finally:
+        os.unlink(config_path)
+
+def test_config_dead_letter_target():
+    print_test("Dead-Letter Queues Declared in Config")
+    server_binary = os.environ.get('SERVER_BINARY')
+    if not server_binary:
+        print_info("SERVER_BINARY not set, skipping")
+        return
+
+    # The source comes first, so its dead-letter queue doesn't exist yet when
+    # it is created
+    config_path = write_temp_config("queues:\n"
+                                    "  - name: test-config-source.fifo\n"
+                                    "    fifo: true\n"
+                                    "    content_based_deduplication: true\n"
+                                    "    dead_letter_target: test-config-dlq.fifo\n"
+                                    "    max_receive_count: 2\n"
+                                    "  - name: test-config-dlq.fifo\n"
+                                    "    fifo: true\n")
+    server, url = start_config_server(server_binary, config_path)
+    try:
+        queues = {q['name']: q for q in requests.get(f"{url}/admin/api/queues").json()['queues']}
+        source = queues['test-config-source.fifo']
+        assert source['fifo_queue'] and source.get('content_based_deduplication'), f"FIFO settings not applied: {source}"
+        assert source.get('redrive_policy') == {
+            'deadLetterTargetArn': 'arn:aws:sqs:us-east-1:000000000000:test-config-dlq.fifo',
+            'maxReceiveCount': 2,
+        }, f"Unexpected redrive policy: {source.get('redrive_policy')}"
+        assert queues['test-config-dlq.fifo']['fifo_queue'], "Dead-letter queue is not FIFO"
+        print_success("fifo, content_based_deduplication and dead_letter_target are applied")
+    finally:
+        server.terminate()
+        server.wait()
+        os.unlink(config_path)
+
+    config_path = write_temp_config("queues:\n"
+                                    "  - name: test-config-source\n"
+                                    "    dead_letter_target: test-config-missing-dlq\n")
+    try:
+        result = subprocess.run([server_binary, '--config', config_path, '--validate-config'],
+                                capture_output=True, text=True, timeout=5)
+        assert result.returncode == 1 and 'test-config-missing-dlq' in result.stdout, \
+            f"Missing dead_letter_target not reported: {result.stdout}"
+        print_success("A dead_letter_target that isn't in the config is rejected")
+    finally:
+        os.unlink(config_path)
+
+def test_config_reload_dead_letter_target():
+    print_test("Config Reload Adds a Queue and Its Dead-Letter Queue")
+    server_binary = os.environ.get('SERVER_BINARY')
+    if not server_binary:
+        print_info("SERVER_BINARY not set, skipping")
+        return
+
+    config_path = write_temp_config("queues:\n"
+                                    "  - name: test-reload-existing\n")
+    server, url = start_config_server(server_binary, config_path)
+    try:
+        # The new source comes before its new dead-letter queue
+        with open(config_path, 'w') as f:
+            f.write("queues:\n"
+                    "  - name: test-reload-existing\n"
+                    "  - name: test-reload-source\n"
+                    "    dead_letter_target: test-reload-dlq\n"
+                    "  - name: test-reload-dlq\n")
+        server.send_signal(signal.SIGHUP)
+
+        last_reload = None
+        for _ in range(50):
+            last_reload = requests.get(f"{url}/admin/api/last-reload").json()['last_reload']
+            if last_reload:
+                break
+            time.sleep(0.1)
+        assert last_reload, "The reload never happened"
+        assert not last_reload.get('error'), f"Reload failed: {last_reload['error']}"
+        assert last_reload['added'] == ['test-reload-source', 'test-reload-dlq'], f"Unexpected reload: {last_reload}"
+
+        queues = {q['name']: q for q in requests.get(f"{url}/admin/api/queues").json()['queues']}
+        assert queues['test-reload-source'].get('redrive_policy', {}).get('deadLetterTargetArn', '').endswith(':test-reload-dlq'), \
+            f"Redrive policy not applied: {queues['test-reload-source']}"
+        print_success("A reloaded queue can name a dead-letter queue added after it in the file")
+    finally:
+        server.terminate()
+        server.wait()
+        os.unlink(config_path)
+
+def test_visibility_limit_from_first_receive():
+    print_test("Visibility Limit Measured From the First Receive (config)")
+    server_binary = os.environ.get('SERVER_BINARY')
+    if not server_binary:
+        print_info("SERVER_BINARY not set, skipping")
+        return
+
+    # Lenient mode lets the test move this server's clock past 12 hours
+    config_path = write_temp_config("server:\n"
+                                    "  lenient: true\n"
+                                    "queues:\n"
+                                    "  - name: test-visibility-limit\n")
+    server, url = start_config_server(server_binary, config_path)
+    try:
+        queue_url = f"{url}/000000000000/test-visibility-limit"
+
+        def advance(seconds):
+            response = requests.post(f"{url}/admin/api/clock/advance", json={'seconds': seconds})
+            assert response.status_code == 200, f"Advancing the clock failed: {response.text}"
+
+        def receive(visibility_timeout):
+            response = requests.post(url, data={
+                'Action': 'ReceiveMessage', 'QueueUrl': queue_url, 'VisibilityTimeout': str(visibility_timeout)
+            })
+            handles = re.findall(r'<ReceiptHandle>(.*?)</ReceiptHandle>', response.text)
+            assert len(handles) == 1, f"Expected one message: {response.text}"
+            return handles[0]
+
+        def change_visibility(handle, visibility_timeout):
+            return requests.post(url, data={
+                'Action': 'ChangeMessageVisibility', 'QueueUrl': queue_url,
+                'ReceiptHandle': handle, 'VisibilityTimeout': str(visibility_timeout)
+            })
+
+        requests.post(url, data={'Action': 'SendMessage', 'QueueUrl': queue_url, 'MessageBody': "long lived"})
+        receive(60)
+        advance(13 * 3600)
+        handle = receive(60)
+        response = change_visibility(handle, 30)
+        assert response.status_code == 400 and 'InvalidParameterValue' in response.text, \
+            f"Extending 13 hours after the first receive should fail, even after a later receive: {response.text}"
+        response = change_visibility(handle, 0)
+        assert response.status_code == 200, f"A timeout of 0 should always be accepted: {response.text}"
+        print_success("The cap counts from the first receive, and a timeout of 0 is accepted past it")
+    finally:
+        server.terminate()
+        server.wait()
+        os.unlink(config_path)
+
+def test_startup_grace_period():
+    print_test("Startup Grace Period for Imported In-Flight Messages (config)")
+    server_binary = os.environ.get('SERVER_BINARY')
+    if not server_binary:
+        print_info("SERVER_BINARY not set, skipping")
+        return
+
+    grace = 4
+    config_path = write_temp_config("server:\n"
+                                    f"  startup_grace_period: {grace}\n"
+                                    "queues:\n"
+                                    "  - name: test-grace-queue\n")
+    export = "\n".join(json.dumps({'body': f"message {i}", 'in_flight': i >= 2}) for i in range(5))
+    server, url = start_config_server(server_binary, config_path)
+    try:
+        queue_url = f"{url}/000000000000/test-grace-queue"
+
+        def receive():
+            response = requests.post(url, data={
+                'Action': 'ReceiveMessage', 'QueueUrl': queue_url,
+                'MaxNumberOfMessages': '10', 'VisibilityTimeout': '300'
+            })
+            return re.findall(r'<Body>(.*?)</Body>', response.text)
+
+        imported_at = time.time()
+        response = requests.post(f"{url}/admin/api/queues/test-grace-queue/import", data=export)
+        assert response.status_code == 200, f"Import failed: {response.text}"
+        assert response.json()['imported'] == 5 and response.json()['in_flight'] == 3, \
+            f"Expected 3 of 5 messages restored in flight: {response.text}"
+        assert sorted(receive()) == ["message 0", "message 1"], "Only the messages that weren't in flight should be visible"
+        print_success("Messages exported in flight are imported in flight during the grace period")
+
+        returned = {}
+        while len(returned) < 3 and time.time() < imported_at + grace + 3:
+            for body in receive():
+                returned[body] = time.time() - imported_at
+            time.sleep(0.1)
+        assert sorted(returned) == ["message 2", "message 3", "message 4"], f"Not every message returned: {returned}"
+        times = sorted(returned.values())
+        assert times[0] > 0.5 and times[-1] - times[0] > 1, f"Returns weren't staggered: {returned}"
+        assert times[-1] < grace + 1, f"A message stayed in flight past the grace period: {returned}"
+        print_success("They return one by one, the last by the end of the grace period")
+    finally:
+        server.terminate()
+        server.wait()
+        os.unlink(config_path)
+
+def test_strict_ordering():
+    print_test("Strict Ordering for Standard Queues (config)")
+    server_binary = os.environ.get('SERVER_BINARY')
+    if not server_binary:
+        print_info("SERVER_BINARY not set, skipping")
+        return
+
+    config_path = write_temp_config("queues:\n"
+                                    "  - name: test-strict-ordering\n"
+                                    "    strict_ordering: true\n")
+    server, url = start_config_server(server_binary, config_path)
+    try:
+        queue_url = f"{url}/000000000000/test-strict-ordering"
+
+        # Interleave delays so the messages become visible in the reverse of
+        # the order they were sent
+        for body, delay in [('first', 2), ('second', 1), ('third', 0)]:
+            response = requests.post(url, data={
+                'Action': 'SendMessage', 'QueueUrl': queue_url, 'MessageBody': body, 'DelaySeconds': str(delay)
+            })
+            assert response.status_code == 200, f"SendMessage failed: {response.text}"
+        time.sleep(2.5)
+
+        response = requests.post(url, data={
+            'Action': 'ReceiveMessage', 'QueueUrl': queue_url, 'MaxNumberOfMessages': '10'
+        })
+        bodies = re.findall(r'<Body>(.*?)</Body>', response.text)
+        assert bodies == ['first', 'second', 'third'], f"Messages not in send order: {bodies}"
+        print_success("Receives return the earliest-sent visible message first")
+
+        response = requests.post(url, data={
+            'Action': 'SendMessage', 'QueueUrl': queue_url, 'MessageBody': 'fourth', 'DelaySeconds': '1'
+        })
+        requests.post(url, data={'Action': 'SendMessage', 'QueueUrl': queue_url, 'MessageBody': 'fifth'})
+        response = requests.post(url, data={
+            'Action': 'ReceiveMessage', 'QueueUrl': queue_url, 'MaxNumberOfMessages': '10'
+        })
+        bodies = re.findall(r'<Body>(.*?)</Body>', response.text)
+        assert bodies == ['fifth'], f"Delayed message was received early: {bodies}"
+        print_success("Delayed messages are still held back until they are visible")
+    finally:
+        server.terminate()
+        server.wait()
+        os.unlink(config_path)
+
+def test_chaos_injection():
+    print_test("Fault Injection (chaos config)")
+    server_binary = os.environ.get('SERVER_BINARY')
+    if not server_binary:
+        print_info("SERVER_BINARY not set, skipping")
+        return
+
+    def run_server(config_text, check):
+        config_path = write_temp_config(config_text)
+        server, url = start_config_server(server_binary, config_path)
+        try:
+            check(url, f"{url}/000000000000/test-chaos-queue")
+        finally:
+            server.terminate()
+            server.wait()
+            os.unlink(config_path)
+
+    def check_send_errors_and_latency(url, queue_url):
+        response = requests.post(url, data={'Action': 'SendMessage', 'QueueUrl': queue_url, 'MessageBody': 'hello'})
+        assert response.status_code == 503, f"Expected 503, got {response.status_code}"
+        assert 'ServiceUnavailable' in response.text, f"Unexpected error: {response.text}"
+        print_success("Sends fail with ServiceUnavailable at send_error_rate 1")
+
+        start = time.time()
+        response = requests.post(url, data={'Action': 'ReceiveMessage', 'QueueUrl': queue_url})
+        elapsed = time.time() - start
+        assert response.status_code == 200, f"ReceiveMessage failed: {response.text}"
+        assert elapsed >= 0.4, f"Receive returned after {elapsed:.2f}s"
+        print_success(f"Receives are delayed by receive_latency_ms ({elapsed:.2f}s)")
+
+        response = requests.post(url, data={'Action': 'GetQueueUrl', 'QueueName': 'test-chaos-queue'})
+        assert response.status_code == 200, f"Other actions were affected: {response.text}"
+        print_success("Other actions are unaffected")
+
+    def check_throttling(url, queue_url):
+        response = requests.post(url, data=json.dumps({'QueueName': 'test-chaos-queue'}), headers={
+            'X-Amz-Target': 'AmazonSQS.GetQueueUrl', 'Content-Type': 'application/x-amz-json-1.0'
+        })
+        assert response.status_code == 403, f"Expected 403, got {response.status_code}"
+        assert response.headers.get('x-amzn-query-error', '').startswith('RequestThrottled'), \
+            f"Unexpected error: {response.headers.get('x-amzn-query-error')}"
+        print_success("Requests fail with RequestThrottled at throttle_rate 1")
+
+    run_server("chaos:\n"
+               "  receive_latency_ms: 400\n"
+               "  send_error_rate: 1\n"
+               "queues:\n"
+               "  - name: test-chaos-queue\n", check_send_errors_and_latency)
+    run_server("chaos:\n"
+               "  throttle_rate: 1\n"
+               "queues:\n"
+               "  - name: test-chaos-queue\n", check_throttling)
+
+def test_rate_limit():
+    print_test("Rate Limiting Sends and Receives")
+    server_binary = os.environ.get('SERVER_BINARY')
+    if not server_binary:
+        print_info("SERVER_BINARY not set, skipping")
+        return
+
+    config_path = write_temp_config("rate_limit:\n"
+                                    "  send_per_second: 1\n"
+                                    "queues:\n"
+                                    "  - name: test-rate-limit-queue\n"
+                                    "    rate_limit:\n"
+                                    "      receive_per_second: 2\n"
+                                    "  - name: test-rate-limit-other\n")
+    server, url = start_config_server(server_binary, config_path)
+    try:
+        queue_url = f"{url}/000000000000/test-rate-limit-queue"
+        other_url = f"{url}/000000000000/test-rate-limit-other"
+
+        def receive():
+            return requests.post(url, data={'Action': 'ReceiveMessage', 'QueueUrl': queue_url})
+
+        statuses = [receive().status_code for _ in range(4)]
+        assert statuses == [200, 200, 403, 403], f"Unexpected receive statuses: {statuses}"
+        response = receive()
+        assert '<Code>RequestThrottled</Code>' in response.text, f"Unexpected error: {response.text}"
+        print_success("Receives beyond receive_per_second are throttled with RequestThrottled (403)")
+
+        response = requests.post(url, data=json.dumps({'QueueUrl': queue_url}), headers={
+            'X-Amz-Target': 'AmazonSQS.ReceiveMessage', 'Content-Type': 'application/x-amz-json-1.0'
+        })
+        assert response.status_code == 403, f"Expected 403, got {response.status_code}"
+        assert response.json()['__type'].endswith('#ThrottlingException'), f"Unexpected error: {response.text}"
+        print_success("JSON protocol requests get ThrottlingException")
+
+        time.sleep(1.1)
+        assert receive().status_code == 200, "Receives were not allowed again after a second"
+        print_success("The limit refills over time")
+
+        def send(target):
+            return requests.post(url, data={'Action': 'SendMessage', 'QueueUrl': target, 'MessageBody': 'hi'})
+
+        assert send(queue_url).status_code == 200, "First send was throttled"
+        assert send(queue_url).status_code == 403, "Second send in the same second was not throttled"
+        assert send(other_url).status_code == 200, "Each queue should have its own limit"
+        response = requests.post(url, data={'Action': 'GetQueueUrl', 'QueueName': 'test-rate-limit-queue'})
+        assert response.status_code == 200, f"Other actions were throttled: {response.text}"
+        print_success("The global send_per_second limit applies to each queue separately")
+    finally:
+        server.terminate()
+        server.wait()
+        os.unlink(config_path)
+
+def test_admin_auth_token():
+    print_test("Admin API Authentication")
+    server_binary = os.environ.get('SERVER_BINARY')
+    if not server_binary:
+        print_info("SERVER_BINARY not set, skipping")
+        return
+
+    config_path = write_temp_config("admin:\n"
+                                    "  auth_token: test-admin-token\n"
+                                    "queues:\n"
+                                    "  - name: test-admin-auth-queue\n")
+    server, url = start_config_server(server_binary, config_path)
+    try:
+        for path in ['/admin', '/admin/api/queues']:
+            response = requests.get(f"{url}{path}")
+            assert response.status_code == 401, f"{path} without a token returned {response.status_code}"
+            response = requests.get(f"{url}{path}", headers={'Authorization': 'Bearer wrong-token'})
+            assert response.status_code == 401, f"{path} with a wrong token returned {response.status_code}"
+        print_success("Admin requests without the right token get 401")
+
+        response = requests.get(f"{url}/admin/api/queues", headers={'Authorization': 'Bearer test-admin-token'})
+        assert response.status_code == 200, f"Admin API rejected the token: {response.status_code}"
+        assert any(q['name'] == 'test-admin-auth-queue' for q in response.json()['queues']), "Queue not listed"
+        print_success("Admin requests with the token are accepted")
+
+        response = requests.get(f"{url}/admin?token=wrong-token", allow_redirects=False)
+        assert response.status_code == 401, f"/admin with a wrong token parameter returned {response.status_code}"
+        session = requests.Session()
+        response = session.get(f"{url}/admin?token=test-admin-token", allow_redirects=False)
+        assert response.status_code == 303, f"/admin with the token parameter returned {response.status_code}"
+        assert response.headers['Location'] == '/admin', f"Redirect kept the token: {response.headers['Location']}"
+        assert 'ess_admin_token' in session.cookies, "Token cookie wasn't set"
+        response = session.get(f"{url}/admin")
+        assert response.status_code == 200, f"Admin UI rejected the token cookie: {response.status_code}"
+        response = session.get(f"{url}/admin/api/queues")
+        assert response.status_code == 200, f"Admin API rejected the token cookie: {response.status_code}"
+        print_success("Browsers can open the admin UI with ?token= and then use the cookie")
+
+        response = requests.post(url, data={'Action': 'GetQueueUrl', 'QueueName': 'test-admin-auth-queue'})
+        assert response.status_code == 200, f"SQS request was affected by admin auth: {response.text}"
+        print_success("SQS requests don't need the admin token")
+    finally:
+        server.terminate()
+        server.wait()
+        os.unlink(config_path)
+
+def test_advance_clock():
+    print_test("Advancing the Clock (lenient extension)")
+    queue_name = "test-clock-queue"
+    queue_url = f"{BASE_URL}/{queue_name}"
+    dlq_name = "test-clock-dlq"
+    dlq_url = f"{BASE_URL}/{dlq_name}"
+    fifo_name = "test-clock-queue.fifo"
+    fifo_url = f"{BASE_URL}/{fifo_name}"
+
+    def advance(seconds):
+        return requests.post(f"{BASE_URL}/admin/api/clock/advance", json={'seconds': seconds})
+
+    def receive(url, visibility_timeout):
+        response = sqs_json_request('ReceiveMessage', {
+            'QueueUrl': url, 'MaxNumberOfMessages': 10, 'VisibilityTimeout': visibility_timeout
+        })
+        return [m['Body'] for m in response.json().get('Messages') or []]
+
+    try:
+        response = advance(1)
+        if response.status_code == 400 and 'lenient' in response.text:
+            print_info("Server not running with server.lenient, skipping")
+            return
+        assert response.status_code == 200, f"Advancing the clock failed: {response.text}"
+        assert advance(0).status_code == 400, "A non-positive advance should be rejected"
+
+        sqs_request('CreateQueue', {'QueueName': queue_name})
+        sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "in flight"})
+        sqs_request('SendMessage', {'QueueUrl': queue_url, 'MessageBody': "delayed", 'DelaySeconds': '900'})
+        assert receive(queue_url, 600) == ["in flight"]
+        assert receive(queue_url, 600) == []
+
+        advance(900)
+        assert sorted(receive(queue_url, 600)) == ["delayed", "in flight"], \
+            "Messages should be visible once the clock passes their visibility timeout and delay"
+        print_success("Visibility timeouts and delays end when the clock is advanced past them")
+
+        sqs_request('CreateQueue', {'QueueName': dlq_name})
+        sqs_request('SetQueueAttributes', {
+            'QueueUrl': queue_url,
+            'Attribute.1.Name': 'RedrivePolicy',
+            'Attribute.1.Value': json.dumps({'deadLetterTargetArn': queue_arn(dlq_name), 'maxReceiveCount': 1})
+        })
+        advance(600)
+        dlq_bodies = sorted(m['body'] for m in get_admin_queue(dlq_name)['messages'])
+        assert dlq_bodies == ["delayed", "in flight"], f"Expected both messages in the DLQ right away, got {dlq_bodies}"
+        print_success("Advancing the clock runs the redrive check immediately")
+
+        sqs_request('CreateQueue', {
+            'QueueName': fifo_name,
+            'Attribute.1.Name': 'FifoQueue',
+            'Attribute.1.Value': 'true'
+        })
+
+        def send_fifo():
+            response = sqs_json_request('SendMessage', {
+                'QueueUrl': fifo_url, 'MessageBody': "dedup me",
+                'MessageGroupId': "g", 'MessageDeduplicationId': "clock-dedup"
+            })
+            return response.json()['MessageId']
+
+        first = send_fifo()
+        assert send_fifo() == first, "A duplicate inside the window should return the original message"
+        advance(300)
+        assert send_fifo() != first, "The deduplication window should end when the clock passes it"
+        print_success("The FIFO deduplication window follows the clock")
+    finally:
+        sqs_request('DeleteQueue', {'QueueUrl': queue_url})
+        sqs_request('DeleteQueue', {'QueueUrl': dlq_url})
+        sqs_request('DeleteQueue', {'QueueUrl': fifo_url})
+
+def run_all_tests():
     print(f"\n{Colors.BLUE}{'='*60}{Colors.END}")
     print(f"{Colors.BLUE}  Ess-Queue-Ess Integration Tests{Colors.END}")
     print(f"{Colors.BLUE}{'='*60}{Colors.END}")
@@ -340,17 +3904,66 @@ def run_all_tests():
         # Queue operations
         queue_name = test_create_queue()
         test_list_queues(expected_count=1)
+        test_list_queues_pagination()
+        test_account_queue_urls()
+        test_request_id()
+        test_xml_namespace()
+        test_json_content_types()
+        test_json_parameters()
+        test_nonexistent_queue_errors()
+        test_correlation_id()
+        test_signature_verification()
         
         # Message operations
         test_send_message(queue_name)
         test_send_multiple_messages(queue_name, count=5)
         test_receive_message(queue_name, expected_count=6)
         test_delete_message(queue_name)
+        test_delete_message_errors()
+        test_change_message_visibility()
+        test_batch_entry_validation()
+        test_zero_visibility_timeout()
+        test_visibility_out_of_order()
+        test_in_flight_limit()
+        test_max_messages()
+        test_long_poll_waiter_limit()
+        test_redrive_wakes_long_poll()
+        test_delete_queue_wakes_long_poll()
+        test_short_poll_returns_immediately()
+        test_queue_delay_seconds()
+        test_multibyte_body_md5()
+        test_message_system_attributes()
+        test_md5_of_system_attributes()
+        test_message_body_characters()
+        test_message_size_counts_attributes()
+        test_message_attribute_validation()
+        test_body_compression()
+        test_fifo_receive_attributes()
+        test_fifo_sequence_numbers_concurrent()
+        test_fifo_queue_naming()
+        test_fifo_parameters_on_standard_queue()
+        test_fifo_group_fairness()
+        test_admin_receive_from_group()
+        test_fifo_order_across_deletes()
+        test_content_dedup_with_attributes()
+        test_deduplication_window()
+        test_deduplication_after_delete()
+        test_set_content_based_deduplication()
+        test_fifo_high_throughput()
         test_get_queue_attributes(queue_name)
+        test_message_state_counts()
+        test_state_counts_sum_near_boundaries()
+        test_cumulative_counters()
+        test_admin_metrics()
+        test_age_of_oldest_message()
         
         # Advanced operations
         test_purge_queue(queue_name)
+        test_queue_permissions()
+        test_encryption_attributes()
+        test_queue_url_forms()
         test_delete_queue(queue_name)
+        test_delete_and_purge_protocols()
         
         # Admin integration
         test_admin_api_with_messages()
@@ -358,8 +3971,48 @@ def run_all_tests():
         # New admin API tests
         test_admin_create_queue()
         test_admin_send_message()
+        test_admin_bulk_send()
+        test_admin_filter_messages()
+        test_admin_listing_is_read_only()
+        test_admin_export_import()
+        test_admin_reset_visibility()
+        test_admin_reset_queue()
+        test_admin_move_to_dlq()
         test_admin_export_config()
+        test_admin_cors()
+        test_admin_event_stream()
         test_admin_delete_queue()
+
+        # DLQ validation
+        test_redrive_policy_requires_existing_dlq()
+        test_message_move_task_validation()
+        test_dlq_preserves_provenance()
+        test_dlq_order_by_sent_time()
+        test_max_receive_count_precedence()
+        test_redrive_resets_first_receive_time()
+        test_admin_pending_dlq()
+        test_set_redrive_policy()
+
+        # Emulator extensions
+        test_receive_auto_delete()
+        test_message_retention_override()
+        test_delivery_history()
+        test_instance_isolation()
+        test_subscription_fan_out()
+        test_webhook_trigger()
+        test_duplicate_queue_names_in_config()
+        test_invalid_config_is_fatal()
+        test_config_dead_letter_target()
+        test_config_reload_dead_letter_target()
+        test_visibility_limit_from_first_receive()
+        test_startup_grace_period()
+        test_strict_ordering()
+        test_admin_auth_token()
+        test_chaos_injection()
+        test_rate_limit()
+
+        # Moves the server's clock forward for good, so it runs last
+        test_advance_clock()
         
         print(f"\n{Colors.GREEN}{'='*60}{Colors.END}")
         print(f"{Colors.GREEN}  ✓ All tests passed!{Colors.END}")
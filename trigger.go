@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// triggerPollInterval is how often an idle trigger checks for messages
+	triggerPollInterval = 500 * time.Millisecond
+
+	// triggerMinBackoff and triggerMaxBackoff bound the exponential backoff
+	// after a failed delivery
+	triggerMinBackoff = 1 * time.Second
+	triggerMaxBackoff = 30 * time.Second
+
+	// triggerTimeout bounds a single webhook call
+	triggerTimeout = 30 * time.Second
+)
+
+var triggerClient = &http.Client{Timeout: triggerTimeout}
+
+// sqsEvent is the event a Lambda function receives from an SQS trigger
+type sqsEvent struct {
+	Records []sqsEventRecord `json:"Records"`
+}
+
+type sqsEventRecord struct {
+	MessageID         string                 `json:"messageId"`
+	ReceiptHandle     string                 `json:"receiptHandle"`
+	Body              string                 `json:"body"`
+	Attributes        map[string]string      `json:"attributes"`
+	MessageAttributes map[string]interface{} `json:"messageAttributes"`
+	MD5OfBody         string                 `json:"md5OfBody"`
+	EventSource       string                 `json:"eventSource"`
+	EventSourceARN    string                 `json:"eventSourceARN"`
+	AWSRegion         string                 `json:"awsRegion"`
+}
+
+// startTrigger starts the trigger worker unless one is already running. The
+// caller must hold the queue lock.
+func (q *Queue) startTrigger() {
+	if q.triggerRunning {
+		return
+	}
+	q.triggerRunning = true
+	go q.triggerWorker()
+}
+
+// triggerWorker delivers the queue's messages to its webhook, like a Lambda
+// event source mapping. Each batch is received with the queue's visibility
+// timeout and deleted once the webhook answers 2xx. On failure the batch is
+// left in flight, so it becomes visible again when the timeout expires and
+// counts toward the redrive policy, and the worker backs off before trying
+// again. The worker exits when the queue is deleted or its trigger removed.
+func (q *Queue) triggerWorker() {
+	var backoff time.Duration
+	for {
+		q.mu.Lock()
+		trigger := q.Trigger
+		if trigger == nil {
+			q.triggerRunning = false
+			q.mu.Unlock()
+			return
+		}
+		visibilityTimeout := q.VisibilityTimeout
+		stop := q.stopChan
+		q.mu.Unlock()
+
+		// Move poison messages to the DLQ before receiving, so one that has
+		// used up its receives isn't delivered again before the background
+		// checker gets to it
+		q.checkVisibilityTimeoutsAndDLQ()
+
+		wait := triggerPollInterval
+		if messages, _ := q.ReceiveMessages(trigger.BatchSize, visibilityTimeout); len(messages) > 0 {
+			if err := q.deliverToTrigger(trigger.URL, messages); err != nil {
+				backoff = min(max(2*backoff, triggerMinBackoff), triggerMaxBackoff)
+				wait = backoff
+				slog.Warn("trigger delivery failed", "queue", q.Name, "url", trigger.URL,
+					"messages", len(messages), "retry_in", backoff, "error", err)
+			} else {
+				backoff = 0
+				wait = 0
+				for _, msg := range messages {
+					q.DeleteMessage(msg.ReceiptHandle)
+				}
+				slog.Debug("trigger delivered messages", "queue", q.Name, "url", trigger.URL,
+					"messages", len(messages))
+			}
+		}
+
+		select {
+		case <-stop:
+			q.mu.Lock()
+			q.triggerRunning = false
+			q.mu.Unlock()
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// deliverToTrigger POSTs the messages to the webhook as an SQS event and
+// returns an error unless it answers with a 2xx status
+func (q *Queue) deliverToTrigger(url string, messages []*Message) error {
+	q.mu.RLock()
+	event := sqsEvent{Records: make([]sqsEventRecord, 0, len(messages))}
+	for _, msg := range messages {
+		event.Records = append(event.Records, q.eventRecord(msg))
+	}
+	q.mu.RUnlock()
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	resp, err := triggerClient.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// eventRecord builds the Lambda event record for a received message. The
+// caller must hold the queue lock.
+func (q *Queue) eventRecord(msg *Message) sqsEventRecord {
+	attributes := map[string]string{
+		"ApproximateReceiveCount":          strconv.Itoa(msg.ReceiveCount),
+		"SentTimestamp":                    strconv.FormatInt(msg.SentTimestamp.UnixMilli(), 10),
+		"SenderId":                         activeConfig.Server.AccountID,
+		"ApproximateFirstReceiveTimestamp": strconv.FormatInt(msg.FirstReceivedTime.UnixMilli(), 10),
+	}
+	if q.FifoQueue {
+		attributes["SequenceNumber"] = msg.SequenceNumber
+		attributes["MessageGroupId"] = msg.MessageGroupId
+		attributes["MessageDeduplicationId"] = msg.MessageDeduplicationId
+	}
+	for name, value := range msg.MessageSystemAttributes {
+		attributes[name] = value
+	}
+
+	messageAttributes := msg.MessageAttributes
+	if messageAttributes == nil {
+		messageAttributes = map[string]interface{}{}
+	}
+
+	return sqsEventRecord{
+		MessageID:         msg.MessageID,
+		ReceiptHandle:     msg.ReceiptHandle,
+		Body:              msg.bodyText(),
+		Attributes:        attributes,
+		MessageAttributes: messageAttributes,
+		MD5OfBody:         msg.MD5OfBody,
+		EventSource:       "aws:sqs",
+		EventSourceARN:    queueArn(q.Name),
+		AWSRegion:         awsRegion,
+	}
+}
@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Validate checks the queues of a loaded configuration for the mistakes that
+// would otherwise only surface when the server bootstraps them: duplicate
+// names, FIFO attributes that contradict the name, dead-letter targets that
+// aren't configured queues and settings outside the SQS limits. It returns
+// every problem found rather than stopping at the first.
+func (c *Config) Validate() []error {
+	errs := c.validateQueues("", c.Queues)
+	for _, instance := range c.Instances {
+		errs = append(errs, c.validateQueues(instance.Name, instance.Queues)...)
+	}
+	return errs
+}
+
+// validateQueues validates one instance's queues. Dead-letter targets must be
+// in the same set, since instances don't share queues.
+func (c *Config) validateQueues(instance string, queues []QueueConfig) []error {
+	var errs []error
+	fail := func(queue, format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		if queue != "" {
+			msg = "queue " + queue + ": " + msg
+		}
+		if instance != "" {
+			msg = "instance " + instance + ": " + msg
+		}
+		errs = append(errs, fmt.Errorf("%s", msg))
+	}
+
+	names := make(map[string]int)
+	for _, q := range queues {
+		names[q.Name]++
+	}
+
+	for i, q := range queues {
+		if q.Name == "" {
+			fail("", "queue %d has no name", i+1)
+			continue
+		}
+		if names[q.Name] > 1 {
+			// Report each duplicated name once
+			fail(q.Name, "defined %d times", names[q.Name])
+			names[q.Name] = -1
+		}
+
+		fifo := strings.HasSuffix(q.Name, ".fifo")
+		if fifoAttr, ok := q.Attributes["FifoQueue"]; ok {
+			switch {
+			case fifoAttr == "true" && !fifo:
+				fail(q.Name, "FifoQueue is true but the name does not end with .fifo")
+			case fifoAttr != "true" && fifo:
+				fail(q.Name, "the name ends with .fifo but FifoQueue is %q", fifoAttr)
+			}
+		}
+
+		if policyJSON := q.Attributes["RedrivePolicy"]; policyJSON != "" {
+			if policy, err := parseRedrivePolicy(policyJSON); err != nil {
+				fail(q.Name, "%v", err)
+			} else {
+				region, account, dlq, ok := parseQueueArn(policy.DeadLetterTargetArn)
+				switch {
+				case !ok || region != awsRegion || account != c.Server.AccountID:
+					fail(q.Name, "dead-letter target %s is not a queue ARN of this server", policy.DeadLetterTargetArn)
+				case dlq == q.Name:
+					fail(q.Name, "a queue can't be its own dead-letter queue")
+				case names[dlq] == 0:
+					fail(q.Name, "dead-letter target %s is not a configured queue", policy.DeadLetterTargetArn)
+				case strings.HasSuffix(dlq, ".fifo") != fifo:
+					fail(q.Name, "dead-letter queue %s must be the same type of queue as the source", dlq)
+				}
+			}
+		}
+
+		for _, r := range []struct {
+			field    string
+			value    int
+			min, max int
+		}{
+			{"visibility_timeout", q.VisibilityTimeout, 0, 43200},
+			{"message_retention_period", q.MessageRetentionPeriod, 60, 1209600},
+			{"maximum_message_size", q.MaximumMessageSize, 1024, 262144},
+			{"delay_seconds", q.DelaySeconds, 0, 900},
+			{"receive_message_wait_time", q.ReceiveMessageWaitTime, 0, 20},
+			{"max_visibility_timeout", q.MaxVisibilityTimeout, 0, 43200},
+		} {
+			if r.value < r.min || r.value > r.max {
+				fail(q.Name, "%s must be from %d to %d, got %d", r.field, r.min, r.max, r.value)
+			}
+		}
+		if q.MaxReceiveCount < 1 {
+			fail(q.Name, "max_receive_count must be at least 1, got %d", q.MaxReceiveCount)
+		}
+		if q.DeduplicationWindow < 1 {
+			fail(q.Name, "deduplication_window_seconds must be at least 1, got %d", q.DeduplicationWindow)
+		}
+		if q.StrictOrdering && fifo {
+			fail(q.Name, "strict_ordering is only valid for standard queues")
+		}
+		if q.RateLimit.SendPerSecond < 0 || q.RateLimit.ReceivePerSecond < 0 {
+			fail(q.Name, "rate_limit rates must not be negative")
+		}
+		if q.MaxMessages < 0 {
+			fail(q.Name, "max_messages must not be negative, got %d", q.MaxMessages)
+		}
+		if q.MaxLongPollWaiters < 0 {
+			fail(q.Name, "max_long_poll_waiters must not be negative, got %d", q.MaxLongPollWaiters)
+		}
+	}
+	return errs
+}
+
+// validateConfigFile loads and validates the configuration file at path and
+// writes a report to out. It reports whether the file is valid.
+func validateConfigFile(path string, out io.Writer) bool {
+	config, err := LoadConfig(path)
+	if err != nil {
+		fmt.Fprintf(out, "%s: %v\n", path, err)
+		return false
+	}
+
+	errs := config.Validate()
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(out, "%s: %v\n", path, err)
+		}
+		fmt.Fprintf(out, "%s: %d error(s)\n", path, len(errs))
+		return false
+	}
+
+	queues := len(config.Queues)
+	for _, instance := range config.Instances {
+		queues += len(instance.Queues)
+	}
+	fmt.Fprintf(out, "%s: OK (%d queues, %d instances)\n", path, queues, len(config.Instances))
+	return true
+}
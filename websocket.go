@@ -0,0 +1,249 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webSocketGUID is the fixed key suffix from RFC 6455 section 1.3
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes used by the event stream
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+)
+
+// wsMaxControlPayload is the largest payload RFC 6455 allows on a control frame
+const wsMaxControlPayload = 125
+
+// wsWriteTimeout bounds how long a single frame write may block on a slow client
+const wsWriteTimeout = 10 * time.Second
+
+// adminWebSocketHandler upgrades the request to a WebSocket and streams queue
+// events to the client as JSON text frames until either side closes. Only the
+// small part of RFC 6455 the event stream needs is implemented: unfragmented
+// server frames, plus close and ping handling for client frames.
+func adminWebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	// Browsers don't apply CORS to WebSockets, so any page could otherwise
+	// open the stream, along with the admin token cookie
+	if !webSocketOriginAllowed(r) {
+		http.Error(w, "WebSocket origin not allowed", http.StatusForbidden)
+		return
+	}
+	if !headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "expected a WebSocket upgrade request", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket upgrade not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		slog.Error("websocket hijack failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + webSocketAccept(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	events, unsubscribe := queueEvents.Subscribe()
+	defer unsubscribe()
+
+	ws := &wsConn{conn: conn}
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		ws.readLoop(rw.Reader)
+	}()
+
+	slog.Debug("websocket client connected", "remote_addr", r.RemoteAddr)
+	defer slog.Debug("websocket client disconnected", "remote_addr", r.RemoteAddr)
+
+	for {
+		select {
+		case event := <-events:
+			payload, err := json.Marshal(event)
+			if err != nil {
+				slog.Error("failed to encode queue event", "error", err)
+				continue
+			}
+			if err := ws.writeFrame(wsOpText, payload); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// webSocketOriginAllowed reports whether a WebSocket upgrade may proceed: the
+// request has no Origin (it isn't from a browser), comes from a page served
+// by this host, or its origin is in admin.cors.allowed_origins
+func webSocketOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return corsOriginAllowed(origin)
+}
+
+// webSocketAccept computes the Sec-WebSocket-Accept value for a client key
+func webSocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerContainsToken reports whether a comma-separated header contains the
+// token, ignoring case
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn is the server side of a hijacked WebSocket connection
+type wsConn struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+}
+
+// writeFrame writes a single unmasked, unfragmented frame
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n <= 125:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readLoop consumes client frames until the connection closes or the client
+// sends a close frame. Data frames are discarded; the stream is one-way.
+func (c *wsConn) readLoop(r *bufio.Reader) {
+	for {
+		opcode, payload, err := readClientFrame(r)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsOpClose:
+			c.writeFrame(wsOpClose, payload)
+			return
+		case wsOpPing:
+			if c.writeFrame(wsOpPong, payload) != nil {
+				return
+			}
+		}
+	}
+}
+
+// readClientFrame reads one frame from the client and unmasks its payload.
+// Control frames are limited to 125 bytes; data frames are read and dropped
+// without buffering them.
+func readClientFrame(r *bufio.Reader) (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if opcode&0x8 == 0 {
+		_, err := io.CopyN(io.Discard, r, int64(length))
+		return opcode, nil, err
+	}
+	if length > wsMaxControlPayload {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}